@@ -0,0 +1,388 @@
+package common
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// roles a user can be assigned in the registry
+const (
+	RoleAdmin      = "admin"
+	RoleClinician  = "clinician"
+	RoleNurse      = "nurse"
+	RolePharmacist = "pharmacist"
+	RoleBilling    = "billing"
+	RolePatient    = "patient"
+)
+
+/**
+ * msp of the first admin: the role attribute of a certificate of this msp grants the admin role
+ * only until an admin is registered, from then on admins are granted by the role registry only
+ */
+const AdminMSPID = "Org1MSP"
+
+// key of the world state entry written when an admin is first registered, it closes the bootstrap by certificate attribute
+const adminRegisteredKey = "adminRegistered"
+
+// resources guarded by the permission matrix
+const (
+	ResourcePatientInformation = "PatientInformation"
+	ResourceMedicalRecord      = "MedicalRecord"
+	ResourceDrugInformation    = "DrugInformation"
+	ResourceHospitalFees       = "HospitalFees"
+	ResourceAccessLog          = "Query"
+	ResourceInventory          = "Inventory"
+)
+
+// actions a role can be granted on a resource, prescriptions are part of the drug information of a patient
+const (
+	ActionRead      = "read"
+	ActionModify    = "modify"
+	ActionPrescribe = "prescribe"
+	ActionDispense  = "dispense"
+)
+
+/**
+ * permission matrix: role -> resource -> allowed actions
+ * a patient is only granted these actions on records of its own patient id
+ */
+var Permissions = map[string]map[string][]string{
+	RoleAdmin: {
+		ResourceAccessLog: {ActionRead},
+	},
+	RoleClinician: {
+		ResourcePatientInformation: {ActionRead, ActionModify},
+		ResourceMedicalRecord:      {ActionRead, ActionModify},
+		ResourceDrugInformation:    {ActionRead, ActionModify, ActionPrescribe},
+		ResourceInventory:          {ActionRead},
+	},
+	RoleNurse: {
+		ResourcePatientInformation: {ActionRead, ActionModify},
+		ResourceMedicalRecord:      {ActionRead},
+		ResourceDrugInformation:    {ActionRead},
+		ResourceInventory:          {ActionRead},
+	},
+	RolePharmacist: {
+		ResourcePatientInformation: {ActionRead},
+		ResourceDrugInformation:    {ActionRead, ActionModify, ActionDispense},
+		ResourceInventory:          {ActionRead, ActionModify},
+	},
+	RoleBilling: {
+		ResourcePatientInformation: {ActionRead},
+		ResourceHospitalFees:       {ActionRead, ActionModify},
+	},
+	RolePatient: {
+		ResourceAccessLog:          {ActionRead},
+		ResourcePatientInformation: {ActionRead},
+		ResourceMedicalRecord:      {ActionRead},
+		ResourceDrugInformation:    {ActionRead},
+		ResourceHospitalFees:       {ActionRead},
+	},
+}
+
+// User registered in the role registry, stored in RegistryCollection under user~mspid~enrollmentid so patient ids stay off world state
+type User struct {
+	ObjectType   string   `json:"docType"`
+	ID           string   `json:"id"`
+	MSPID        string   `json:"mspid"`
+	EnrollmentID string   `json:"enrollment_id"`
+	Roles        []string `json:"roles"`
+	PatientID    string   `json:"patientid"`
+}
+
+func IsRole(role string) bool {
+	_, found := Permissions[role]
+	return found
+}
+
+func HasRole(user *User, role string) bool {
+	for _, userRole := range user.Roles {
+		if userRole == role {
+			return true
+		}
+	}
+	return false
+}
+
+func IsAllowed(role string, resource string, action string) bool {
+	for _, allowedAction := range Permissions[role][resource] {
+		if allowedAction == action {
+			return true
+		}
+	}
+	return false
+}
+
+//get user from registry, return nil if user is not registered
+func GetUser(stub shim.ChaincodeStubInterface, mspid string, enrollmentID string) (*User, error) {
+	userKey, errUserKey := stub.CreateCompositeKey("user", []string{mspid, enrollmentID})
+	if errUserKey != nil {
+		return nil, errUserKey
+	}
+
+	userAsBytes, errUserAsByte := stub.GetPrivateData(RegistryCollection, userKey)
+	if errUserAsByte != nil {
+		return nil, NewError(CodeStorage, "cannot get user from registry: "+errUserAsByte.Error())
+	} else if userAsBytes == nil {
+		return nil, nil
+	}
+
+	user := &User{}
+	errUserAsByte = json.Unmarshal(userAsBytes, user)
+	if errUserAsByte != nil {
+		return nil, NewError(CodeCorruptRecord, "cannot read user from registry: "+errUserAsByte.Error())
+	}
+	return user, nil
+}
+
+func PutUser(stub shim.ChaincodeStubInterface, user *User) error {
+	userKey, errUserKey := stub.CreateCompositeKey("user", []string{user.MSPID, user.EnrollmentID})
+	if errUserKey != nil {
+		return errUserKey
+	}
+
+	userAsByte, errUserAsByte := json.Marshal(user)
+	if errUserAsByte != nil {
+		return errUserAsByte
+	}
+	return stub.PutPrivateData(RegistryCollection, userKey, userAsByte)
+}
+
+/**
+ * check the permission matrix for the invoker before touching private data
+ * on success identity.Role is set to the role that granted access
+ * @param: patientid the request is about, used to restrict patients to their own records
+ */
+func Authorize(stub shim.ChaincodeStubInterface, identity *Identity, resource string, action string, patientid string) error {
+	user, errUser := GetUser(stub, identity.MSPID, identity.EnrollmentID)
+	if errUser != nil {
+		return errUser
+	} else if user == nil {
+		return NewError(CodeForbidden, "user "+identity.ID+" is not registered")
+	}
+
+	for _, role := range user.Roles {
+		if !IsAllowed(role, resource, action) {
+			continue
+		}
+		if role == RolePatient && (len(user.PatientID) == 0 || user.PatientID != patientid) {
+			continue
+		}
+		identity.Role = role
+		return nil
+	}
+
+	return NewError(CodeForbidden, "user "+identity.ID+" is not allowed to "+action+" "+resource)
+}
+
+//get identity of the invoker and check the permission matrix for it
+func AuthorizeInvoker(stub shim.ChaincodeStubInterface, resource string, action string, patientid string) (*Identity, error) {
+	identity, errIdentity := GetIdentity(stub)
+	if errIdentity != nil {
+		return nil, errIdentity
+	}
+
+	errPermission := Authorize(stub, identity, resource, action, patientid)
+	if errPermission != nil {
+		return nil, errPermission
+	}
+	return identity, nil
+}
+
+/**
+ * only admins of the role registry may change it
+ * the first admin is bootstrapped by the role attribute of its certificate, which is only accepted
+ * from AdminMSPID and only while no admin is registered
+ */
+func AuthorizeAdmin(stub shim.ChaincodeStubInterface) error {
+	identity, errIdentity := GetIdentity(stub)
+	if errIdentity != nil {
+		return errIdentity
+	}
+
+	user, errUser := GetUser(stub, identity.MSPID, identity.EnrollmentID)
+	if errUser != nil {
+		return errUser
+	} else if user != nil && HasRole(user, RoleAdmin) {
+		return nil
+	}
+
+	if identity.Role == RoleAdmin && identity.MSPID == AdminMSPID {
+		adminRegistered, errAdminRegistered := stub.GetState(adminRegisteredKey)
+		if errAdminRegistered != nil {
+			return NewError(CodeStorage, "cannot get role registry: "+errAdminRegistered.Error())
+		} else if adminRegistered == nil {
+			return nil
+		}
+	}
+	return NewError(CodeForbidden, "user "+identity.ID+" is not an admin")
+}
+
+//record an admin is registered, which ends the bootstrap by certificate attribute
+func putAdminRegistered(stub shim.ChaincodeStubInterface, user *User) error {
+	errAdminRegistered := stub.PutState(adminRegisteredKey, []byte(user.ID))
+	if errAdminRegistered != nil {
+		return NewError(CodeStorage, "cannot save role registry: "+errAdminRegistered.Error())
+	}
+	return nil
+}
+
+/**
+ * register user in the role registry
+ * @param: mspid
+ * @param: enrollmentId
+ * @param: role
+ * @param: patientid, required when role is patient
+ */
+func RegisterUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 && len(args) != 4 {
+		return ErrorResponse(NewError(CodeInvalidArgument, "expecting 3 or 4 argument"))
+	}
+
+	errArgs := CheckNotEmpty(args[:3])
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	mspid := args[0]
+	enrollmentID := args[1]
+	role := args[2]
+	patientid := ""
+	if len(args) == 4 {
+		patientid = args[3]
+	}
+
+	if !IsRole(role) {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "role", "role "+role+" does not exist"))
+	} else if role == RolePatient && len(patientid) == 0 {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "patientid", "patient must be registered with a patient id"))
+	}
+
+	errAdmin := AuthorizeAdmin(stub)
+	if errAdmin != nil {
+		return ErrorResponse(errAdmin)
+	}
+
+	existingUser, errUser := GetUser(stub, mspid, enrollmentID)
+	if errUser != nil {
+		return ErrorResponse(errUser)
+	} else if existingUser != nil {
+		return ErrorResponse(NewError(CodeConflict, "user "+existingUser.ID+" is already registered"))
+	}
+
+	objectType := ObjectTypeUser
+	user := &User{objectType, mspid + "::" + enrollmentID, mspid, enrollmentID, []string{role}, patientid}
+	errUser = PutUser(stub, user)
+	if errUser != nil {
+		return ErrorResponse(NewError(CodeStorage, "cannot save user: "+errUser.Error()))
+	}
+	if role == RoleAdmin {
+		errUser = putAdminRegistered(stub, user)
+		if errUser != nil {
+			return ErrorResponse(errUser)
+		}
+	}
+
+	return shim.Success(nil)
+}
+
+/**
+ * assign another role to a registered user
+ * @param: mspid
+ * @param: enrollmentId
+ * @param: role
+ */
+func AssignRole(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 3)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	mspid := args[0]
+	enrollmentID := args[1]
+	role := args[2]
+
+	if !IsRole(role) {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "role", "role "+role+" does not exist"))
+	}
+
+	errAdmin := AuthorizeAdmin(stub)
+	if errAdmin != nil {
+		return ErrorResponse(errAdmin)
+	}
+
+	user, errUser := GetUser(stub, mspid, enrollmentID)
+	if errUser != nil {
+		return ErrorResponse(errUser)
+	} else if user == nil {
+		return ErrorResponse(NewError(CodeNotFound, "user "+mspid+"::"+enrollmentID+" is not registered"))
+	}
+
+	if HasRole(user, role) {
+		return shim.Success(nil)
+	} else if role == RolePatient && len(user.PatientID) == 0 {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "patientid", "patient must be registered with a patient id"))
+	}
+
+	user.Roles = append(user.Roles, role)
+	errUser = PutUser(stub, user)
+	if errUser != nil {
+		return ErrorResponse(NewError(CodeStorage, "cannot save user: "+errUser.Error()))
+	}
+	if role == RoleAdmin {
+		errUser = putAdminRegistered(stub, user)
+		if errUser != nil {
+			return ErrorResponse(errUser)
+		}
+	}
+
+	return shim.Success(nil)
+}
+
+/**
+ * revoke a role of a registered user
+ * @param: mspid
+ * @param: enrollmentId
+ * @param: role
+ */
+func RevokeRole(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 3)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	mspid := args[0]
+	enrollmentID := args[1]
+	role := args[2]
+
+	errAdmin := AuthorizeAdmin(stub)
+	if errAdmin != nil {
+		return ErrorResponse(errAdmin)
+	}
+
+	user, errUser := GetUser(stub, mspid, enrollmentID)
+	if errUser != nil {
+		return ErrorResponse(errUser)
+	} else if user == nil {
+		return ErrorResponse(NewError(CodeNotFound, "user "+mspid+"::"+enrollmentID+" is not registered"))
+	} else if !HasRole(user, role) {
+		return ErrorResponse(NewError(CodeNotFound, "user "+user.ID+" does not have role "+role))
+	}
+
+	roles := []string{}
+	for _, userRole := range user.Roles {
+		if userRole != role {
+			roles = append(roles, userRole)
+		}
+	}
+	user.Roles = roles
+
+	errUser = PutUser(stub, user)
+	if errUser != nil {
+		return ErrorResponse(NewError(CodeStorage, "cannot save user: "+errUser.Error()))
+	}
+
+	return shim.Success(nil)
+}
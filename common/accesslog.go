@@ -0,0 +1,313 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/**
+ * append an access entry to the log of a collection, one entry per transaction
+ * entry is stored under userid~patientid~txid and indexed by patientid~userid~txid
+ * so it can be looked up by user and by patient
+ */
+func LogAccess(stub shim.ChaincodeStubInterface, collection string, query *Query) error {
+	queryAsByte, errQueryAsByte := json.Marshal(query)
+	if errQueryAsByte != nil {
+		return errQueryAsByte
+	}
+
+	//save entry
+	queryKey, errQueryKey := stub.CreateCompositeKey("userid~patientid", []string{query.UserID, query.PatientID, query.TxID})
+	if errQueryKey != nil {
+		return errQueryKey
+	}
+	errQueryAsByte = stub.PutPrivateData(collection, queryKey, queryAsByte)
+	if errQueryAsByte != nil {
+		return NewError(CodeStorage, "cannot save access log: "+errQueryAsByte.Error())
+	}
+
+	//save index by patient
+	patientIndexKey, errPatientIndexKey := stub.CreateCompositeKey("patientid~userid", []string{query.PatientID, query.UserID, query.TxID})
+	if errPatientIndexKey != nil {
+		return errPatientIndexKey
+	}
+	value := []byte{0x00}
+	errPatientIndex := stub.PutPrivateData(collection, patientIndexKey, value)
+	if errPatientIndex != nil {
+		return NewError(CodeStorage, "cannot save access log index: "+errPatientIndex.Error())
+	}
+
+	return nil
+}
+
+/**
+ * check that the invoker may access a patient's record and append the access to the log
+ * checks the permission matrix and the consent of the patient
+ * a query is logged to queryCollection, a modification to modifyCollection
+ * @param: purpose, PurposeQuery or PurposeModify
+ */
+func CheckAccess(stub shim.ChaincodeStubInterface, resource string, purpose string, patientid string, location string) (*Identity, error) {
+	action := ActionRead
+	if purpose == PurposeModify {
+		action = ActionModify
+	}
+	return checkAccess(stub, resource, action, purpose, patientid, location, nil)
+}
+
+//check that the invoker may modify a patient's record and log the fields a patch changes
+func CheckPatchAccess(stub shim.ChaincodeStubInterface, resource string, patientid string, location string, fields []string) (*Identity, error) {
+	return checkAccess(stub, resource, ActionModify, PurposeModify, patientid, location, fields)
+}
+
+//check that the invoker may take an action modifying a patient's record, like ActionPrescribe, and log the modification
+func CheckActionAccess(stub shim.ChaincodeStubInterface, resource string, action string, patientid string, location string) (*Identity, error) {
+	return checkAccess(stub, resource, action, PurposeModify, patientid, location, nil)
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, resource string, action string, purpose string, patientid string, location string, fields []string) (*Identity, error) {
+	collection := QueryCollection
+	if purpose == PurposeModify {
+		collection = ModifyCollection
+	}
+
+	identity, errIdentity := AuthorizeInvoker(stub, resource, action, patientid)
+	if errIdentity != nil {
+		return nil, errIdentity
+	}
+
+	errConsent := CheckConsent(stub, identity, resource, purpose, patientid)
+	if errConsent != nil {
+		return nil, errConsent
+	}
+
+	timeQuery, errTimeQuery := GetTxTimestamp(stub)
+	if errTimeQuery != nil {
+		return nil, errTimeQuery
+	}
+
+	query := &Query{ObjectTypeQuery, identity.ID, identity.MSPID, identity.Role, patientid, location, timeQuery, purpose, stub.GetTxID(), fields, nil}
+	errLogAccess := LogAccess(stub, collection, query)
+	if errLogAccess != nil {
+		return nil, errLogAccess
+	}
+
+	return identity, nil
+}
+
+/**
+ * record the conflicts a prescriber accepted in the modify log
+ * a transaction cannot read its own writes, so an entry the transaction already logged is written again under the same key
+ * @param: identity, as authorized by CheckAccess or CheckPatchAccess
+ * @param: fields, the fields a patch changed, nil for other modifications
+ */
+func LogOverride(stub shim.ChaincodeStubInterface, identity *Identity, patientid string, location string, fields []string, override *SafetyOverride) error {
+	timeQuery, errTimeQuery := GetTxTimestamp(stub)
+	if errTimeQuery != nil {
+		return errTimeQuery
+	}
+
+	query := &Query{ObjectTypeQuery, identity.ID, identity.MSPID, identity.Role, patientid, location, timeQuery, PurposeModify, stub.GetTxID(), fields, override}
+	return LogAccess(stub, ModifyCollection, query)
+}
+
+/**
+ * order entries of the access log by time of access
+ * times of older entries are converted to RFC 3339 by the compatibility parser,
+ * entries with a time that cannot be parsed are kept first
+ */
+func SortAccessLog(queries []*Query) {
+	accessTimes := make(map[*Query]time.Time, len(queries))
+	for _, query := range queries {
+		accessTime, errAccessTime := ParseTimestamp(query.Time)
+		if errAccessTime == nil {
+			query.Time = FormatTimestamp(accessTime)
+			accessTimes[query] = accessTime
+		}
+	}
+
+	sort.SliceStable(queries, func(i, j int) bool {
+		return accessTimes[queries[i]].Before(accessTimes[queries[j]])
+	})
+}
+
+/**
+ * get every access of a user from the log of a collection, ordered by time
+ * the single record the log used to keep under the userid key is included
+ */
+func GetAccessLogByUser(stub shim.ChaincodeStubInterface, collection string, userid string) ([]*Query, error) {
+	queries := []*Query{}
+
+	legacyQueryAsBytes, errLegacyQueryAsByte := stub.GetPrivateData(collection, userid)
+	if errLegacyQueryAsByte != nil {
+		return nil, NewError(CodeStorage, "cannot get access log: "+errLegacyQueryAsByte.Error())
+	} else if legacyQueryAsBytes != nil {
+		legacyQuery := &Query{}
+		if json.Unmarshal(legacyQueryAsBytes, legacyQuery) == nil {
+			queries = append(queries, legacyQuery)
+		}
+	}
+
+	queryIterator, errQueryIterator := stub.GetPrivateDataByPartialCompositeKey(collection, "userid~patientid", []string{userid})
+	if errQueryIterator != nil {
+		return nil, NewError(CodeStorage, "cannot get access log: "+errQueryIterator.Error())
+	}
+	defer queryIterator.Close()
+
+	for queryIterator.HasNext() {
+		queryKV, errQueryKV := queryIterator.Next()
+		if errQueryKV != nil {
+			return nil, NewError(CodeStorage, "cannot get access log: "+errQueryKV.Error())
+		}
+
+		//skip index keys written by the old log, they only hold a marker value
+		if bytes.Equal(queryKV.Value, []byte{0x00}) {
+			continue
+		}
+
+		query := &Query{}
+		errQuery := json.Unmarshal(queryKV.Value, query)
+		if errQuery != nil {
+			return nil, NewError(CodeCorruptRecord, "cannot read access log: "+errQuery.Error())
+		}
+		queries = append(queries, query)
+	}
+
+	SortAccessLog(queries)
+	return queries, nil
+}
+
+//get every access to the records of a patient from the log of a collection, ordered by time
+func GetAccessLogByPatient(stub shim.ChaincodeStubInterface, collection string, patientid string) ([]*Query, error) {
+	indexIterator, errIndexIterator := stub.GetPrivateDataByPartialCompositeKey(collection, "patientid~userid", []string{patientid})
+	if errIndexIterator != nil {
+		return nil, NewError(CodeStorage, "cannot get access log: "+errIndexIterator.Error())
+	}
+	defer indexIterator.Close()
+
+	queries := []*Query{}
+	for indexIterator.HasNext() {
+		indexKV, errIndexKV := indexIterator.Next()
+		if errIndexKV != nil {
+			return nil, NewError(CodeStorage, "cannot get access log: "+errIndexKV.Error())
+		}
+
+		_, keyParts, errKeyParts := stub.SplitCompositeKey(indexKV.Key)
+		if errKeyParts != nil || len(keyParts) != 3 {
+			return nil, NewError(CodeCorruptRecord, "invalid access log index "+indexKV.Key)
+		}
+
+		queryKey, errQueryKey := stub.CreateCompositeKey("userid~patientid", []string{keyParts[1], keyParts[0], keyParts[2]})
+		if errQueryKey != nil {
+			return nil, errQueryKey
+		}
+		queryAsBytes, errQueryAsByte := stub.GetPrivateData(collection, queryKey)
+		if errQueryAsByte != nil {
+			return nil, NewError(CodeStorage, "cannot get access log: "+errQueryAsByte.Error())
+		} else if queryAsBytes == nil {
+			continue
+		}
+
+		query := &Query{}
+		errQuery := json.Unmarshal(queryAsBytes, query)
+		if errQuery != nil {
+			return nil, NewError(CodeCorruptRecord, "cannot read access log: "+errQuery.Error())
+		}
+		queries = append(queries, query)
+	}
+
+	SortAccessLog(queries)
+	return queries, nil
+}
+
+/**
+ * view history query of user
+ * params: userid
+ * ouput: every query of user, ordered by time
+ */
+func HistoryQuery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return historyByUser(stub, args, QueryCollection)
+}
+
+/**
+ * view history modify data of user
+ * params: userid
+ * ouput: every modification of user, ordered by time
+ */
+func HistoryModify(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return historyByUser(stub, args, ModifyCollection)
+}
+
+func historyByUser(stub shim.ChaincodeStubInterface, args []string, collection string) pb.Response {
+	// check require argument
+	errArgs := CheckArgs(args, 1)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	//define argument
+	userid := args[0]
+
+	//check permission of user before view access log
+	_, errPermission := AuthorizeInvoker(stub, ResourceAccessLog, ActionRead, "")
+	if errPermission != nil {
+		return ErrorResponse(errPermission)
+	}
+
+	//get every access of user
+	accesses, errAccesses := GetAccessLogByUser(stub, collection, userid)
+	if errAccesses != nil {
+		return ErrorResponse(errAccesses)
+	}
+	accessDataAsBytes, errAccessDataAsByte := json.Marshal(accesses)
+	if errAccessDataAsByte != nil {
+		return ErrorResponse(NewError(CodeInternal, "cannot get access data of user"))
+	}
+
+	return shim.Success(accessDataAsBytes)
+}
+
+/**
+ * view history query and modify of a patient's records, so a patient can see everyone who accessed them
+ * params: patientid
+ * ouput: every query and modification of patient's records, ordered by time
+ */
+func HistoryPatient(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// check require argument
+	errArgs := CheckArgs(args, 1)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	//define argument
+	patientid := args[0]
+
+	//check permission of user before view access log
+	_, errPermission := AuthorizeInvoker(stub, ResourceAccessLog, ActionRead, patientid)
+	if errPermission != nil {
+		return ErrorResponse(errPermission)
+	}
+
+	//get every query and modification of patient's records
+	queries, errQueries := GetAccessLogByPatient(stub, QueryCollection, patientid)
+	if errQueries != nil {
+		return ErrorResponse(errQueries)
+	}
+	modifies, errModifies := GetAccessLogByPatient(stub, ModifyCollection, patientid)
+	if errModifies != nil {
+		return ErrorResponse(errModifies)
+	}
+	accesses := append(queries, modifies...)
+	SortAccessLog(accesses)
+
+	accessDataAsBytes, errAccessDataAsByte := json.Marshal(accesses)
+	if errAccessDataAsByte != nil {
+		return ErrorResponse(NewError(CodeInternal, "cannot get access data of patient"))
+	}
+
+	return shim.Success(accessDataAsBytes)
+}
@@ -0,0 +1,190 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// key of the transient map holding the salt of the hash anchor of a record
+const TransientSaltKey = "salt"
+
+// minimum length of a salt, so hashes of guessed records cannot be matched against anchors
+const minSaltLength = 16
+
+// collections whose records are anchored to world state on every create and modification
+var AnchorCollections = map[string]bool{
+	PatientInformationCollection: true,
+	MedicalRecordCollection:      true,
+}
+
+/**
+ * Anchor is the public proof of a version of a private record, saved in world state
+ * under anchor~collection~hash, hash is the hex sha256 of the salt followed by the record as json
+ * the anchor holds neither the record nor its id, so it discloses no health information
+ */
+type Anchor struct {
+	ObjectType string `json:"docType"`
+	Collection string `json:"collection"`
+	Version    int64  `json:"version"`
+	TxID       string `json:"txid"`
+	Time       string `json:"time"`
+}
+
+// Verification is the result of verifyRecord, the anchor is set when the document is authentic
+type Verification struct {
+	Verified bool    `json:"verified"`
+	Anchor   *Anchor `json:"anchor,omitempty"`
+}
+
+//get the salt of the hash anchor from the transient map of a create or modify function
+func GetSalt(stub shim.ChaincodeStubInterface) ([]byte, error) {
+	transient, errTransient := stub.GetTransient()
+	if errTransient != nil {
+		return nil, NewError(CodeInvalidArgument, "cannot get transient map: "+errTransient.Error())
+	}
+	salt := transient[TransientSaltKey]
+	if len(salt) < minSaltLength {
+		return nil, NewFieldError(CodeInvalidArgument, TransientSaltKey, "salt of at least "+strconv.Itoa(minSaltLength)+" bytes must be passed in the transient map")
+	}
+	return salt, nil
+}
+
+func AnchorHash(salt []byte, recordAsByte []byte) string {
+	hash := sha256.Sum256(append(append([]byte{}, salt...), recordAsByte...))
+	return hex.EncodeToString(hash[:])
+}
+
+/**
+ * save the hash anchor of the current version of a record to world state
+ * the salt is read from the transient map, the client keeps it to disclose the record later
+ */
+func PutAnchor(stub shim.ChaincodeStubInterface, collection string, record VersionedRecord) error {
+	salt, errSalt := GetSalt(stub)
+	if errSalt != nil {
+		return errSalt
+	}
+
+	recordAsByte, errRecordAsByte := json.Marshal(record)
+	if errRecordAsByte != nil {
+		return errRecordAsByte
+	}
+
+	timeAnchor, errTimeAnchor := GetTxTimestamp(stub)
+	if errTimeAnchor != nil {
+		return errTimeAnchor
+	}
+
+	anchor := &Anchor{ObjectTypeAnchor, collection, record.GetVersion(), stub.GetTxID(), timeAnchor}
+	anchorAsByte, errAnchorAsByte := json.Marshal(anchor)
+	if errAnchorAsByte != nil {
+		return errAnchorAsByte
+	}
+
+	anchorKey, errAnchorKey := stub.CreateCompositeKey("anchor", []string{collection, AnchorHash(salt, recordAsByte)})
+	if errAnchorKey != nil {
+		return errAnchorKey
+	}
+	errAnchorAsByte = stub.PutState(anchorKey, anchorAsByte)
+	if errAnchorAsByte != nil {
+		return NewError(CodeStorage, "cannot save anchor: "+errAnchorAsByte.Error())
+	}
+	return nil
+}
+
+/**
+ * decode a disclosed record of an anchored collection into its canonical json, as it was stored
+ * the document must be a record of the collection with the given id, unknown fields are rejected
+ */
+func CanonicalRecord(collection string, id string, document []byte) ([]byte, error) {
+	var record interface{}
+	var objectType *string
+	var recordID *string
+	var expectedObjectType string
+	switch collection {
+	case PatientInformationCollection:
+		patient := &PatientInformation{}
+		record, objectType, recordID, expectedObjectType = patient, &patient.ObjectType, &patient.ID, ObjectTypePatientInformation
+	case MedicalRecordCollection:
+		medicalRecord := &MedicalRecord{}
+		record, objectType, recordID, expectedObjectType = medicalRecord, &medicalRecord.ObjectType, &medicalRecord.ID, ObjectTypeMedicalRecord
+	default:
+		return nil, NewFieldError(CodeInvalidArgument, "collection", "records of "+collection+" are not anchored")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.DisallowUnknownFields()
+	errRecord := decoder.Decode(record)
+	if errRecord != nil {
+		return nil, NewFieldError(CodeInvalidArgument, TransientDocumentKey, "invalid json document: "+errRecord.Error())
+	} else if *objectType != expectedObjectType {
+		return nil, NewFieldError(CodeInvalidArgument, TransientDocumentKey, "expecting docType "+expectedObjectType+", got "+*objectType)
+	} else if *recordID != id {
+		return nil, NewFieldError(CodeInvalidArgument, "id", "id of arguments and document differ")
+	}
+
+	return json.Marshal(record)
+}
+
+/**
+ * verify a disclosed copy of a private record against its hash anchor, without reading the record
+ * the document is the record as returned by query, it and its salt are read from the transient map
+ * so no health information is recorded in the block
+ * @param: collection, id
+ */
+func VerifyRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 2)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	collection := args[0]
+	id := args[1]
+
+	transient, errTransient := stub.GetTransient()
+	if errTransient != nil {
+		return ErrorResponse(NewError(CodeInvalidArgument, "cannot get transient map: "+errTransient.Error()))
+	}
+	document := transient[TransientDocumentKey]
+	salt := transient[TransientSaltKey]
+	if len(document) == 0 {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, TransientDocumentKey, "document must be passed in the transient map"))
+	} else if len(salt) == 0 {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, TransientSaltKey, "salt must be passed in the transient map"))
+	}
+
+	recordAsByte, errRecordAsByte := CanonicalRecord(collection, id, document)
+	if errRecordAsByte != nil {
+		return ErrorResponse(errRecordAsByte)
+	}
+
+	anchorKey, errAnchorKey := stub.CreateCompositeKey("anchor", []string{collection, AnchorHash(salt, recordAsByte)})
+	if errAnchorKey != nil {
+		return ErrorResponse(errAnchorKey)
+	}
+	anchorAsBytes, errAnchorAsByte := stub.GetState(anchorKey)
+	if errAnchorAsByte != nil {
+		return ErrorResponse(NewError(CodeStorage, "cannot get anchor: "+errAnchorAsByte.Error()))
+	}
+
+	verification := &Verification{}
+	if anchorAsBytes != nil {
+		verification.Anchor = &Anchor{}
+		errAnchor := json.Unmarshal(anchorAsBytes, verification.Anchor)
+		if errAnchor != nil {
+			return ErrorResponse(NewError(CodeCorruptRecord, "cannot read anchor: "+errAnchor.Error()))
+		}
+		verification.Verified = true
+	}
+
+	verificationAsByte, errVerificationAsByte := json.Marshal(verification)
+	if errVerificationAsByte != nil {
+		return ErrorResponse(errVerificationAsByte)
+	}
+	return shim.Success(verificationAsByte)
+}
@@ -0,0 +1,256 @@
+package common
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// purposes a consent can be granted for, same as the purpose of the access log
+const (
+	PurposeQuery  = "query"
+	PurposeModify = "modify"
+)
+
+// Consent given by a patient, stored in ConsentCollection under consent~patientid~id so the patient is not linked to the grantee in world state
+type Consent struct {
+	ObjectType string `json:"docType"`
+	ID         string `json:"id"`
+	PatientID  string `json:"patientid"`
+	Grantee    string `json:"grantee"`
+	Resource   string `json:"resource"`
+	Purpose    string `json:"purpose"`
+	Expiry     string `json:"expiry"`
+	Revoked    bool   `json:"revoked"`
+}
+
+func IsConsentResource(resource string) bool {
+	return resource == ResourcePatientInformation || resource == ResourceMedicalRecord ||
+		resource == ResourceDrugInformation || resource == ResourceHospitalFees
+}
+
+//get patient id of the invoker, who must be registered as a patient
+func GetPatientID(stub shim.ChaincodeStubInterface) (string, error) {
+	identity, errIdentity := GetIdentity(stub)
+	if errIdentity != nil {
+		return "", errIdentity
+	}
+
+	user, errUser := GetUser(stub, identity.MSPID, identity.EnrollmentID)
+	if errUser != nil {
+		return "", errUser
+	} else if user == nil || !HasRole(user, RolePatient) || len(user.PatientID) == 0 {
+		return "", NewError(CodeForbidden, "user "+identity.ID+" is not a patient")
+	}
+	return user.PatientID, nil
+}
+
+func GetConsents(stub shim.ChaincodeStubInterface, patientid string) ([]*Consent, error) {
+	consentIterator, errConsentIterator := stub.GetPrivateDataByPartialCompositeKey(ConsentCollection, "consent", []string{patientid})
+	if errConsentIterator != nil {
+		return nil, NewError(CodeStorage, "cannot get consents: "+errConsentIterator.Error())
+	}
+	defer consentIterator.Close()
+
+	consents := []*Consent{}
+	for consentIterator.HasNext() {
+		consentKV, errConsentKV := consentIterator.Next()
+		if errConsentKV != nil {
+			return nil, NewError(CodeStorage, "cannot get consents: "+errConsentKV.Error())
+		}
+
+		consent := &Consent{}
+		errConsent := json.Unmarshal(consentKV.Value, consent)
+		if errConsent != nil {
+			return nil, NewError(CodeCorruptRecord, "cannot read consent: "+errConsent.Error())
+		}
+		consents = append(consents, consent)
+	}
+	return consents, nil
+}
+
+/**
+ * refuse access to a patient's record when no valid consent covers it
+ * a patient accessing its own record does not need a consent
+ * @param: identity already authorized for the resource
+ */
+func CheckConsent(stub shim.ChaincodeStubInterface, identity *Identity, resource string, purpose string, patientid string) error {
+	if identity.Role == RolePatient {
+		return nil
+	}
+
+	txTime, errTxTime := GetTxTime(stub)
+	if errTxTime != nil {
+		return errTxTime
+	}
+
+	consents, errConsents := GetConsents(stub, patientid)
+	if errConsents != nil {
+		return errConsents
+	}
+
+	for _, consent := range consents {
+		if consent.Revoked || consent.Resource != resource || consent.Purpose != purpose {
+			continue
+		}
+		if consent.Grantee != identity.ID && consent.Grantee != identity.MSPID {
+			continue
+		}
+		expiry, errExpiry := ParseTimestamp(consent.Expiry)
+		if errExpiry == nil && txTime.Before(expiry) {
+			return nil
+		}
+	}
+
+	return NewError(CodeForbidden, "missing consent of patient "+patientid+" for "+identity.ID+" (or "+identity.MSPID+") to "+purpose+" "+resource)
+}
+
+/**
+ * grant consent to access the invoker's records
+ * @param: grantee, user id (mspid::enrollmentId) or msp id of an org
+ * @param: resource
+ * @param: purpose
+ * @param: expiry, RFC 3339
+ * ouput: id of consent
+ */
+func GrantConsent(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 4)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	grantee := args[0]
+	resource := args[1]
+	purpose := args[2]
+	expiryAsString := args[3]
+
+	if !IsConsentResource(resource) {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "resource", "resource "+resource+" does not exist"))
+	} else if purpose != PurposeQuery && purpose != PurposeModify {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "purpose", "purpose must be "+PurposeQuery+" or "+PurposeModify))
+	}
+
+	expiry, errExpiry := time.Parse(time.RFC3339, expiryAsString)
+	if errExpiry != nil {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "expiry", "expiry must be a RFC 3339 time"))
+	}
+	txTime, errTxTime := GetTxTime(stub)
+	if errTxTime != nil {
+		return ErrorResponse(errTxTime)
+	} else if !txTime.Before(expiry) {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "expiry", "expiry must be in the future"))
+	}
+
+	patientid, errPatientID := GetPatientID(stub)
+	if errPatientID != nil {
+		return ErrorResponse(errPatientID)
+	}
+
+	objectType := ObjectTypeConsent
+	consent := &Consent{objectType, stub.GetTxID(), patientid, grantee, resource, purpose,
+		FormatTimestamp(expiry), false}
+	consentAsByte, errConsentAsByte := json.Marshal(consent)
+	if errConsentAsByte != nil {
+		return ErrorResponse(errConsentAsByte)
+	}
+
+	consentKey, errConsentKey := stub.CreateCompositeKey("consent", []string{consent.PatientID, consent.ID})
+	if errConsentKey != nil {
+		return ErrorResponse(errConsentKey)
+	}
+	errConsentAsByte = stub.PutPrivateData(ConsentCollection, consentKey, consentAsByte)
+	if errConsentAsByte != nil {
+		return ErrorResponse(NewError(CodeStorage, "cannot save consent: "+errConsentAsByte.Error()))
+	}
+
+	return shim.Success([]byte(consent.ID))
+}
+
+/**
+ * revoke a consent of the invoker
+ * @param: consentId
+ */
+func RevokeConsent(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 1)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	consentid := args[0]
+
+	patientid, errPatientID := GetPatientID(stub)
+	if errPatientID != nil {
+		return ErrorResponse(errPatientID)
+	}
+
+	consentKey, errConsentKey := stub.CreateCompositeKey("consent", []string{patientid, consentid})
+	if errConsentKey != nil {
+		return ErrorResponse(errConsentKey)
+	}
+	consentAsBytes, errConsentAsByte := stub.GetPrivateData(ConsentCollection, consentKey)
+	if errConsentAsByte != nil {
+		return ErrorResponse(NewError(CodeStorage, "cannot get consent: "+errConsentAsByte.Error()))
+	} else if consentAsBytes == nil {
+		return ErrorResponse(NewFieldError(CodeNotFound, "consentId", "consent "+consentid+" does not exist"))
+	}
+
+	consent := &Consent{}
+	errConsentAsByte = json.Unmarshal(consentAsBytes, consent)
+	if errConsentAsByte != nil {
+		return ErrorResponse(NewError(CodeCorruptRecord, "cannot read consent: "+errConsentAsByte.Error()))
+	}
+
+	consent.Revoked = true
+	consentAsByte, errConsentAsByte := json.Marshal(consent)
+	if errConsentAsByte != nil {
+		return ErrorResponse(errConsentAsByte)
+	}
+	errConsentAsByte = stub.PutPrivateData(ConsentCollection, consentKey, consentAsByte)
+	if errConsentAsByte != nil {
+		return ErrorResponse(NewError(CodeStorage, "cannot save consent: "+errConsentAsByte.Error()))
+	}
+
+	return shim.Success(nil)
+}
+
+/**
+ * list consents of a patient, including revoked and expired ones
+ * @param: patientid, optional for a patient listing its own consents
+ */
+func ListConsents(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) > 1 {
+		return ErrorResponse(NewError(CodeInvalidArgument, "expecting at most 1 argument"))
+	}
+
+	var patientid string
+	if len(args) == 1 && len(args[0]) > 0 {
+		//only the patient itself or an admin may list consents of a patient
+		patientid = args[0]
+		ownPatientID, errPatientID := GetPatientID(stub)
+		if errPatientID != nil || ownPatientID != patientid {
+			errAdmin := AuthorizeAdmin(stub)
+			if errAdmin != nil {
+				return ErrorResponse(errAdmin)
+			}
+		}
+	} else {
+		ownPatientID, errPatientID := GetPatientID(stub)
+		if errPatientID != nil {
+			return ErrorResponse(errPatientID)
+		}
+		patientid = ownPatientID
+	}
+
+	consents, errConsents := GetConsents(stub, patientid)
+	if errConsents != nil {
+		return ErrorResponse(errConsents)
+	}
+
+	consentsAsByte, errConsentsAsByte := json.Marshal(consents)
+	if errConsentsAsByte != nil {
+		return ErrorResponse(errConsentsAsByte)
+	}
+	return shim.Success(consentsAsByte)
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/**
+ * codes of the error responses, clients branch on these and they do not change between releases
+ * CodeInvalidArgument: arguments are missing or malformed, Field names the argument
+ * CodeUnauthenticated: identity of the invoker cannot be read from its certificate
+ * CodeForbidden: invoker is not registered, lacks a role or a consent of the patient
+ * CodeNotFound: record, user or consent does not exist
+ * CodeConflict: record, user or role already exists, or the state changed since it was read
+ * CodeCorruptRecord: stored record is not a valid document of its type
+ * CodeStorage: ledger cannot be read or written
+ * CodeInternal: any other failure of the chaincode
+ */
+const (
+	CodeInvalidArgument = "INVALID_ARGUMENT"
+	CodeUnauthenticated = "UNAUTHENTICATED"
+	CodeForbidden       = "FORBIDDEN"
+	CodeNotFound        = "NOT_FOUND"
+	CodeConflict        = "CONFLICT"
+	CodeCorruptRecord   = "CORRUPT_RECORD"
+	CodeStorage         = "STORAGE_ERROR"
+	CodeInternal        = "INTERNAL"
+)
+
+// Error returned by every chaincode function, the message of its response is this error as json
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+func NewError(code string, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+//error about a single argument, field is its name
+func NewFieldError(code string, field string, message string) *Error {
+	return &Error{Code: code, Message: message, Field: field}
+}
+
+//get code of an error, errors without one are internal
+func ToError(err error) *Error {
+	switch typedErr := err.(type) {
+	case *Error:
+		return typedErr
+	case *NotFoundError:
+		return NewError(CodeNotFound, typedErr.Error())
+	case *CorruptRecordError:
+		return NewError(CodeCorruptRecord, typedErr.Error())
+	case *StorageError:
+		return NewError(CodeStorage, typedErr.Error())
+	case *VersionConflictError:
+		return NewFieldError(CodeConflict, "version", typedErr.Error())
+	default:
+		return NewError(CodeInternal, err.Error())
+	}
+}
+
+//error response with the error as json message
+func ErrorResponse(err error) pb.Response {
+	errorAsByte, errErrorAsByte := json.Marshal(ToError(err))
+	if errErrorAsByte != nil {
+		return shim.Error(`{"code":"` + CodeInternal + `","message":"cannot marshal error"}`)
+	}
+	return shim.Error(string(errorAsByte))
+}
+
+//parse message of an error response, used by clients and tests
+func ParseError(message string) (*Error, error) {
+	err := &Error{}
+	errParse := json.Unmarshal([]byte(message), err)
+	if errParse != nil {
+		return nil, errParse
+	}
+	return err, nil
+}
@@ -0,0 +1,41 @@
+package common_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+)
+
+func TestErrorResponse(t *testing.T) {
+	cases := []struct {
+		err   error
+		code  string
+		field string
+	}{
+		{common.CheckArgs([]string{"P1", ""}, 2), common.CodeInvalidArgument, "args[1]"},
+		{common.CheckArgCount([]string{"P1"}, 2), common.CodeInvalidArgument, ""},
+		{common.NewError(common.CodeConflict, "user is already registered"), common.CodeConflict, ""},
+		{&common.NotFoundError{Collection: common.DrugInformationCollection, Key: "P1"}, common.CodeNotFound, ""},
+		{&common.CorruptRecordError{Collection: common.DrugInformationCollection, Key: "P1", Err: errors.New("unexpected end of JSON input")}, common.CodeCorruptRecord, ""},
+		{&common.StorageError{Collection: common.DrugInformationCollection, Key: "P1", Err: errors.New("disk failure")}, common.CodeStorage, ""},
+		{errors.New("unexpected failure"), common.CodeInternal, ""},
+	}
+
+	for _, testCase := range cases {
+		response := common.ErrorResponse(testCase.err)
+		if response.Status != shim.ERROR {
+			t.Fatalf("%s: expecting status %d, got %d", testCase.err, shim.ERROR, response.Status)
+		}
+
+		errResponse, errParse := common.ParseError(response.Message)
+		if errParse != nil {
+			t.Fatalf("%s: expecting json error, got %q", testCase.err, response.Message)
+		} else if errResponse.Code != testCase.code || errResponse.Field != testCase.field {
+			t.Fatalf("%s: expecting code %s and field %q, got %+v", testCase.err, testCase.code, testCase.field, errResponse)
+		} else if errResponse.Message != testCase.err.Error() {
+			t.Fatalf("expecting message %q, got %q", testCase.err.Error(), errResponse.Message)
+		}
+	}
+}
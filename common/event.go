@@ -0,0 +1,41 @@
+package common
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// names of the chaincode events, a transaction emits at most one event
+const (
+	EventRecordCreated  = "RecordCreated"
+	EventRecordModified = "RecordModified"
+	EventRecordQueried  = "RecordQueried"
+)
+
+/**
+ * Event is the payload of a chaincode event, listeners read the record through the chaincode
+ * so the payload carries no health information
+ */
+type Event struct {
+	EventType  string `json:"event_type"`
+	RecordType string `json:"record_type"`
+	RecordID   string `json:"record_id"`
+	Actor      string `json:"actor"`
+	TxID       string `json:"txid"`
+}
+
+//emit the chaincode event of a create, modification or query of a record by actor
+func SetRecordEvent(stub shim.ChaincodeStubInterface, eventType string, recordType string, recordID string, actor string) error {
+	event := &Event{eventType, recordType, recordID, actor, stub.GetTxID()}
+	eventAsByte, errEventAsByte := json.Marshal(event)
+	if errEventAsByte != nil {
+		return errEventAsByte
+	}
+
+	errEvent := stub.SetEvent(eventType, eventAsByte)
+	if errEvent != nil {
+		return NewError(CodeInternal, "cannot set event: "+errEvent.Error())
+	}
+	return nil
+}
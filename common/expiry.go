@@ -0,0 +1,135 @@
+package common
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// name of the index of drug information by expiration date, its keys sort by date as dates are ISO 8601
+const ExpirationIndex = "expirationDate~patient"
+
+// ExpiringDrug is an entry of listExpiringDrugs, DaysUntilExpiry is negative once the drug expired
+type ExpiringDrug struct {
+	ID              string `json:"id"`
+	PatientID       string `json:"patientid"`
+	DrugName        string `json:"drug_name"`
+	ExpirationDate  string `json:"expiration_date"`
+	DaysUntilExpiry int64  `json:"days_until_expiry"`
+}
+
+/**
+ * list the drug information expiring before a date, in order of expiration date, a page of DefaultPageSize at a time
+ * so pharmacies can pull the stock and patients can be prescribed again
+ * the drugs are read from the expiration index; only drugs of patients whose consent covers the invoker
+ * are listed, each of them is logged to the access log
+ * at most MaxPageSize entries are scanned, so entries left out of the page cannot make a page read the whole index
+ * @param: before, ISO 8601 date, drugs expiring on that date are not listed
+ * @param: bookmark, optional, returned by the previous page
+ */
+func ListExpiringDrugs(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return ErrorResponse(NewError(CodeInvalidArgument, "expecting 1 or 2 argument"))
+	}
+
+	errArgs := CheckNotEmpty(args[:1])
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+	errArgs = CheckDate("before", args[0])
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	before := args[0]
+	bookmark := ""
+	if len(args) == 2 {
+		bookmark = args[1]
+	}
+
+	//a listing spans patients, so a patient cannot list and reads its own record with query
+	identity, errPermission := AuthorizeInvoker(stub, ResourceDrugInformation, ActionRead, "")
+	if errPermission != nil {
+		return ErrorResponse(errPermission)
+	}
+
+	txTime, errTxTime := GetTxTime(stub)
+	if errTxTime != nil {
+		return ErrorResponse(errTxTime)
+	}
+	today, _ := time.Parse(DateLayout, txTime.Format(DateLayout))
+
+	entryIterator, errEntryIterator := stub.GetPrivateDataByPartialCompositeKey(DrugInformationCollection, ExpirationIndex, []string{})
+	if errEntryIterator != nil {
+		return ErrorResponse(&StorageError{DrugInformationCollection, ExpirationIndex, errEntryIterator})
+	}
+	defer entryIterator.Close()
+
+	page := &Page{Records: []interface{}{}}
+	read := 0
+	lastKey := ""
+	for entryIterator.HasNext() {
+		entryKV, errEntryKV := entryIterator.Next()
+		if errEntryKV != nil {
+			return ErrorResponse(&StorageError{DrugInformationCollection, ExpirationIndex, errEntryKV})
+		}
+		//the bookmark is the last entry of the previous page
+		if entryKV.Key <= bookmark {
+			continue
+		}
+
+		_, attributes, errAttributes := stub.SplitCompositeKey(entryKV.Key)
+		if errAttributes != nil || len(attributes) != 2 {
+			return ErrorResponse(NewError(CodeCorruptRecord, "invalid entry of index "+ExpirationIndex+" in "+DrugInformationCollection))
+		}
+		expirationDate, patientid := attributes[0], attributes[1]
+		//entries are in order of date, so the first one on or after before ends the listing
+		if expirationDate >= before {
+			break
+		}
+		//an entry past a full page or past the scanned entries only tells there is a next page
+		if len(page.Records) == DefaultPageSize || read == MaxPageSize {
+			page.Bookmark = lastKey
+			break
+		}
+		read++
+		lastKey = entryKV.Key
+
+		//dates written before they were typed are not ISO 8601, they are listed once migrated
+		expiration, errExpiration := time.Parse(DateLayout, expirationDate)
+		if errExpiration != nil {
+			continue
+		}
+
+		//drugs of patients who did not consent are left out of the page
+		errConsent := CheckConsent(stub, identity, ResourceDrugInformation, PurposeQuery, patientid)
+		if errConsent != nil && ToError(errConsent).Code == CodeForbidden {
+			continue
+		} else if errConsent != nil {
+			return ErrorResponse(errConsent)
+		}
+
+		drug := &DrugInformation{}
+		errDrug := GetRecord(stub, DrugInformationCollection, patientid, ObjectTypeDrugInformation, drug)
+		if errDrug != nil {
+			return ErrorResponse(errDrug)
+		}
+
+		query := &Query{ObjectTypeQuery, identity.ID, identity.MSPID, identity.Role, patientid, "", FormatTimestamp(txTime), PurposeQuery, stub.GetTxID(), nil, nil}
+		errLogAccess := LogAccess(stub, QueryCollection, query)
+		if errLogAccess != nil {
+			return ErrorResponse(errLogAccess)
+		}
+
+		daysUntilExpiry := int64(expiration.Sub(today).Hours() / 24)
+		page.Records = append(page.Records, &ExpiringDrug{drug.ID, patientid, drug.DrugName, expirationDate, daysUntilExpiry})
+	}
+
+	pageAsByte, errPageAsByte := json.Marshal(page)
+	if errPageAsByte != nil {
+		return ErrorResponse(errPageAsByte)
+	}
+	return shim.Success(pageAsByte)
+}
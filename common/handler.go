@@ -0,0 +1,296 @@
+package common
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//fields of a record or decoded document, as json values
+func RecordFields(record interface{}) (map[string]interface{}, error) {
+	recordAsByte, errRecordAsByte := json.Marshal(record)
+	if errRecordAsByte != nil {
+		return nil, errRecordAsByte
+	}
+	fields := map[string]interface{}{}
+	errFields := json.Unmarshal(recordAsByte, &fields)
+	if errFields != nil {
+		return nil, errFields
+	}
+	return fields, nil
+}
+
+/**
+ * create a record of a patient
+ * @param: id, as positional arguments or a json object
+ * transient document: json document of schema
+ * transient salt: salt of the hash anchor, for records of AnchorCollections
+ * @param: record, pointer to an empty struct of the record, with its docType set
+ */
+func CreateRecord(stub shim.ChaincodeStubInterface, args []string, schema *Schema, resource string, collection string, objectType string, record VersionedRecord) pb.Response {
+	//decode json document, or positional arguments of older clients
+	errArgs := schema.Decode(stub, args, record)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+	fields, errFields := RecordFields(record)
+	if errFields != nil {
+		return ErrorResponse(errFields)
+	}
+	patientid, _ := fields[schema.Routing[0]].(string)
+	//a new record has version 1
+	record.NextVersion()
+
+	//check permission of user before create
+	identity, errPermission := AuthorizeInvoker(stub, resource, ActionModify, patientid)
+	if errPermission != nil {
+		return ErrorResponse(errPermission)
+	}
+
+	//check the drug against the medications and allergies of the patient
+	if drug, isDrug := record.(*DrugInformation); isDrug {
+		_, errSafety := CheckDrugSafety(stub, identity, patientid, "", nil, drug.DrugName, false)
+		if errSafety != nil {
+			return ErrorResponse(errSafety)
+		}
+	}
+
+	//a record is created once, later changes go through a modify or patch function
+	existingAsBytes, errExisting := stub.GetPrivateData(collection, patientid)
+	if errExisting != nil {
+		return ErrorResponse(&StorageError{collection, patientid, errExisting})
+	} else if existingAsBytes != nil {
+		return ErrorResponse(NewFieldError(CodeConflict, schema.Routing[0], "record "+patientid+" already exists in "+collection))
+	}
+
+	//update the secondary indexes, before the record replaces the stored one
+	errRecord := PutIndexes(stub, collection, patientid, record)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	//save to ledger
+	errRecord = PutRecord(stub, collection, patientid, record)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	//keep the first revision of a record of a collection keeping revisions
+	if RevisionCollections[collection] {
+		errRecord = PutRevision(stub, collection, patientid, identity.ID, record)
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+	}
+
+	//anchor the hash of a record of an anchored collection to world state, so a disclosed copy can be verified
+	if AnchorCollections[collection] {
+		errRecord = PutAnchor(stub, collection, record)
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+	}
+
+	//notify listeners, the event carries no health information
+	errRecord = SetRecordEvent(stub, EventRecordCreated, objectType, patientid, identity.ID)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	return shim.Success(nil)
+}
+
+/**
+ * query a record of a patient and log the access
+ * @param: patientid
+ * @param: location
+ * ouput: the record, its current version is returned for a later modification
+ */
+func QueryRecord(stub shim.ChaincodeStubInterface, args []string, resource string, collection string, objectType string, record interface{}) pb.Response {
+	errArgs := CheckArgs(args, 2)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	patientid := args[0]
+	location := args[1]
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckAccess(stub, resource, PurposeQuery, patientid, location)
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	//get data and check it is a valid record
+	errRecord := GetRecord(stub, collection, patientid, objectType, record)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	recordAsByte, errRecordAsByte := json.Marshal(record)
+	if errRecordAsByte != nil {
+		return ErrorResponse(errRecordAsByte)
+	}
+
+	//notify listeners, the event carries no health information
+	errEvent := SetRecordEvent(stub, EventRecordQueried, objectType, patientid, identity.ID)
+	if errEvent != nil {
+		return ErrorResponse(errEvent)
+	}
+
+	return shim.Success(recordAsByte)
+}
+
+/**
+ * modify a record of a patient, every field of the schema but id, location and version takes its new value
+ * @param: id, location, version, as positional arguments or a json object
+ * transient document: json document of schema, new values may be empty
+ * transient salt: salt of the hash anchor, for records of AnchorCollections
+ * @param: update, pointer to the update struct of the record the document is decoded into
+ */
+func ModifyRecord(stub shim.ChaincodeStubInterface, args []string, schema *Schema, resource string, collection string, objectType string, record VersionedRecord, update interface{}) pb.Response {
+	//decode json document, or positional arguments of older clients
+	errArgs := schema.Decode(stub, args, update)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+	fields, errFields := RecordFields(update)
+	if errFields != nil {
+		return ErrorResponse(errFields)
+	}
+	patientid, _ := fields[schema.Routing[0]].(string)
+	location, _ := fields[schema.Routing[1]].(string)
+	expectedVersion, _ := fields[schema.Routing[2]].(string)
+
+	changes := map[string]interface{}{}
+	for _, field := range schema.Fields {
+		if !schema.IsRouting(field) {
+			changes[field] = fields[field]
+		}
+	}
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckAccess(stub, resource, PurposeModify, patientid, location)
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	//get data and check it is a valid record
+	errRecord := GetRecord(stub, collection, patientid, objectType, record)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	//check the record was not modified since the invoker read it
+	errRecord = record.CheckVersion(collection, patientid, expectedVersion)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	//keep the stored version of a record written before revisions were added
+	if RevisionCollections[collection] {
+		errRecord = PutStoredRevision(stub, collection, patientid, record)
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+	}
+
+	//check a new drug against the medications and allergies of the patient
+	if drug, isDrug := record.(*DrugInformation); isDrug {
+		drugName, _ := changes["drug_name"].(string)
+		if NormalizeDrug(drugName) != NormalizeDrug(drug.DrugName) {
+			_, errRecord = CheckDrugSafety(stub, identity, patientid, location, nil, drugName, false)
+			if errRecord != nil {
+				return ErrorResponse(errRecord)
+			}
+		}
+	}
+
+	//change data
+	errRecord = ApplyPatch(record, changes)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+	record.NextVersion()
+
+	//update the secondary indexes, before the record replaces the stored one
+	errRecord = PutIndexes(stub, collection, patientid, record)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	//store new data
+	errRecord = PutRecord(stub, collection, patientid, record)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	//keep every revision of a record of a collection keeping revisions
+	if RevisionCollections[collection] {
+		errRecord = PutRevision(stub, collection, patientid, identity.ID, record)
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+	}
+
+	//anchor the hash of a record of an anchored collection to world state
+	if AnchorCollections[collection] {
+		errRecord = PutAnchor(stub, collection, record)
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+	}
+
+	//notify listeners, the event carries no health information
+	errRecord = SetRecordEvent(stub, EventRecordModified, objectType, patientid, identity.ID)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	return shim.Success(nil)
+}
+
+func CreatePatientInformation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return CreateRecord(stub, args, PatientInformationSchema, ResourcePatientInformation, PatientInformationCollection, ObjectTypePatientInformation, &PatientInformation{ObjectType: ObjectTypePatientInformation})
+}
+
+func CreateMedicalRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return CreateRecord(stub, args, MedicalRecordSchema, ResourceMedicalRecord, MedicalRecordCollection, ObjectTypeMedicalRecord, &MedicalRecord{ObjectType: ObjectTypeMedicalRecord})
+}
+
+func CreateDrugInformation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return CreateRecord(stub, args, DrugInformationSchema, ResourceDrugInformation, DrugInformationCollection, ObjectTypeDrugInformation, &DrugInformation{ObjectType: ObjectTypeDrugInformation})
+}
+
+func CreateHospitalFees(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return CreateRecord(stub, args, HospitalFeesSchema, ResourceHospitalFees, HospitalFeesCollection, ObjectTypeHospitalFees, &HospitalFees{ObjectType: ObjectTypeHospitalFees})
+}
+
+func QueryPatientInformation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return QueryRecord(stub, args, ResourcePatientInformation, PatientInformationCollection, ObjectTypePatientInformation, &PatientInformation{})
+}
+
+func QueryMedicalRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return QueryRecord(stub, args, ResourceMedicalRecord, MedicalRecordCollection, ObjectTypeMedicalRecord, &MedicalRecord{})
+}
+
+func QueryDrugInformation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return QueryRecord(stub, args, ResourceDrugInformation, DrugInformationCollection, ObjectTypeDrugInformation, &DrugInformation{})
+}
+
+func QueryHospitalFees(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return QueryRecord(stub, args, ResourceHospitalFees, HospitalFeesCollection, ObjectTypeHospitalFees, &HospitalFees{})
+}
+
+func ModifyPatientInformation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return ModifyRecord(stub, args, PatientInformationUpdateSchema, ResourcePatientInformation, PatientInformationCollection, ObjectTypePatientInformation, &PatientInformation{}, &PatientInformationUpdate{})
+}
+
+func ModifyMedicalRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return ModifyRecord(stub, args, MedicalRecordUpdateSchema, ResourceMedicalRecord, MedicalRecordCollection, ObjectTypeMedicalRecord, &MedicalRecord{}, &MedicalRecordUpdate{})
+}
+
+func ModifyDrugInformation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return ModifyRecord(stub, args, DrugInformationUpdateSchema, ResourceDrugInformation, DrugInformationCollection, ObjectTypeDrugInformation, &DrugInformation{}, &DrugInformationUpdate{})
+}
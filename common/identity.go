@@ -0,0 +1,49 @@
+package common
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+)
+
+// Identity of the user who submitted the transaction, resolved from the
+// creator certificate of the proposal instead of a caller-supplied userid
+type Identity struct {
+	ID           string
+	MSPID        string
+	EnrollmentID string
+	Role         string
+}
+
+/**
+ * get identity of the invoker from the creator certificate
+ * ID is "<mspid>::<enrollment id>" so the same enrollment id in two orgs
+ * does not map to the same user
+ */
+func GetIdentity(stub shim.ChaincodeStubInterface) (*Identity, error) {
+	clientIdentity, errClientIdentity := cid.New(stub)
+	if errClientIdentity != nil {
+		return nil, NewError(CodeUnauthenticated, "cannot get user identity: "+errClientIdentity.Error())
+	}
+
+	mspid, errMSPID := clientIdentity.GetMSPID()
+	if errMSPID != nil {
+		return nil, NewError(CodeUnauthenticated, "cannot get msp id of user: "+errMSPID.Error())
+	}
+
+	cert, errCert := clientIdentity.GetX509Certificate()
+	if errCert != nil {
+		return nil, NewError(CodeUnauthenticated, "cannot get certificate of user: "+errCert.Error())
+	}
+	enrollmentID := cert.Subject.CommonName
+	if len(enrollmentID) == 0 {
+		return nil, NewError(CodeUnauthenticated, "certificate of user does not have an enrollment id")
+	}
+
+	//role attribute of the certificate is optional, access is granted by the role registry
+	role, _, errRole := clientIdentity.GetAttributeValue("role")
+	if errRole != nil {
+		return nil, NewError(CodeUnauthenticated, "cannot get role of user: "+errRole.Error())
+	}
+
+	return &Identity{mspid + "::" + enrollmentID, mspid, enrollmentID, role}, nil
+}
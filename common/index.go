@@ -0,0 +1,311 @@
+package common
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Index is a secondary index of a collection, its entries are composite keys of the values of Fields
+type Index struct {
+	Name string
+	//json names of the fields of the record, in the order of the attributes of the key
+	Fields []string
+}
+
+// secondary indexes of each collection, the free text of medical records is never indexed
+var Indexes = map[string][]*Index{
+	PatientInformationCollection: {{"insuranceCard~patient", []string{"insurance_card", "photo_id"}}},
+	DrugInformationCollection:    {{"patient~drug", []string{"id", "drug_name"}}, {"prescriber~patient", []string{"prescribed_by", "id"}}, {ExpirationIndex, []string{"expiration_date", "id"}}},
+	HospitalFeesCollection:       {{"patient~serviceDate", []string{"id", "date_of_service"}}},
+}
+
+/**
+ * indexes written by older releases, their keys hold every field of the record
+ * the id of the record is their first attribute, so the entries of a record are found without its old values
+ */
+var LegacyIndexes = map[string][]string{
+	PatientInformationCollection: {"id~insurance_card"},
+	MedicalRecordCollection:      {"id"},
+	DrugInformationCollection:    {"id~patient_name"},
+	HospitalFeesCollection:       {"id~patient_name"},
+}
+
+//value of an index entry, the entry is all in its key
+var indexValue = []byte{0x00}
+
+/**
+ * composite keys of the index entries of a record
+ * a record with an empty indexed field has no entry in that index
+ * @param: recordAsByte, json of the record
+ */
+func IndexKeys(stub shim.ChaincodeStubInterface, collection string, recordAsByte []byte) ([]string, error) {
+	fields := map[string]interface{}{}
+	errFields := json.Unmarshal(recordAsByte, &fields)
+	if errFields != nil {
+		return nil, errFields
+	}
+
+	keys := []string{}
+	for _, index := range Indexes[collection] {
+		attributes := make([]string, 0, len(index.Fields))
+		for _, field := range index.Fields {
+			value, _ := fields[field].(string)
+			if len(value) == 0 {
+				break
+			}
+			attributes = append(attributes, value)
+		}
+		if len(attributes) != len(index.Fields) {
+			continue
+		}
+
+		key, errKey := stub.CreateCompositeKey(index.Name, attributes)
+		if errKey != nil {
+			return nil, NewError(CodeInvalidArgument, "cannot create key of index "+index.Name+": "+errKey.Error())
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+/**
+ * update the index entries of a record to its new values, and remove the entries of the legacy indexes
+ * must be called before the record is stored, the entries to remove are read from the stored record
+ * @param: key, id of the record
+ */
+func PutIndexes(stub shim.ChaincodeStubInterface, collection string, key string, record interface{}) error {
+	recordAsByte, errRecordAsByte := json.Marshal(record)
+	if errRecordAsByte != nil {
+		return errRecordAsByte
+	}
+	newKeys, errNewKeys := IndexKeys(stub, collection, recordAsByte)
+	if errNewKeys != nil {
+		return errNewKeys
+	}
+
+	storedAsByte, errStoredAsByte := stub.GetPrivateData(collection, key)
+	if errStoredAsByte != nil {
+		return &StorageError{collection, key, errStoredAsByte}
+	}
+	oldKeys := []string{}
+	if storedAsByte != nil {
+		oldKeys, errStoredAsByte = IndexKeys(stub, collection, storedAsByte)
+		if errStoredAsByte != nil {
+			return &CorruptRecordError{collection, key, errStoredAsByte}
+		}
+	}
+
+	for _, legacyIndex := range LegacyIndexes[collection] {
+		errLegacy := deleteByPartialCompositeKey(stub, collection, legacyIndex, []string{key})
+		if errLegacy != nil {
+			return errLegacy
+		}
+	}
+
+	for _, oldKey := range oldKeys {
+		if containsKey(newKeys, oldKey) {
+			continue
+		}
+		errOldKey := stub.DelPrivateData(collection, oldKey)
+		if errOldKey != nil {
+			return &StorageError{collection, key, errOldKey}
+		}
+	}
+	for _, newKey := range newKeys {
+		if containsKey(oldKeys, newKey) {
+			continue
+		}
+		errNewKey := stub.PutPrivateData(collection, newKey, indexValue)
+		if errNewKey != nil {
+			return &StorageError{collection, key, errNewKey}
+		}
+	}
+	return nil
+}
+
+/**
+ * write the missing index entries of a stored record, like the entries of an index added after the record was written
+ * @param: recordAsByte, json of the stored record
+ */
+func RepairIndexes(stub shim.ChaincodeStubInterface, collection string, key string, recordAsByte []byte) error {
+	keys, errKeys := IndexKeys(stub, collection, recordAsByte)
+	if errKeys != nil {
+		return &CorruptRecordError{collection, key, errKeys}
+	}
+
+	for _, indexKey := range keys {
+		entry, errEntry := stub.GetPrivateData(collection, indexKey)
+		if errEntry != nil {
+			return &StorageError{collection, key, errEntry}
+		} else if entry != nil {
+			continue
+		}
+		errEntry = stub.PutPrivateData(collection, indexKey, indexValue)
+		if errEntry != nil {
+			return &StorageError{collection, key, errEntry}
+		}
+	}
+	return nil
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, candidate := range keys {
+		if candidate == key {
+			return true
+		}
+	}
+	return false
+}
+
+func deleteByPartialCompositeKey(stub shim.ChaincodeStubInterface, collection string, objectType string, attributes []string) error {
+	entryIterator, errEntryIterator := stub.GetPrivateDataByPartialCompositeKey(collection, objectType, attributes)
+	if errEntryIterator != nil {
+		return &StorageError{collection, objectType, errEntryIterator}
+	}
+	defer entryIterator.Close()
+
+	for entryIterator.HasNext() {
+		entryKV, errEntryKV := entryIterator.Next()
+		if errEntryKV != nil {
+			return &StorageError{collection, objectType, errEntryKV}
+		}
+		errEntry := stub.DelPrivateData(collection, entryKV.Key)
+		if errEntry != nil {
+			return &StorageError{collection, objectType, errEntry}
+		}
+	}
+	return nil
+}
+
+//get the last attribute of every entry of an index starting with value, ordered by key
+func LookupIndex(stub shim.ChaincodeStubInterface, collection string, indexName string, value string) ([]string, error) {
+	entryIterator, errEntryIterator := stub.GetPrivateDataByPartialCompositeKey(collection, indexName, []string{value})
+	if errEntryIterator != nil {
+		return nil, &StorageError{collection, indexName, errEntryIterator}
+	}
+	defer entryIterator.Close()
+
+	values := []string{}
+	for entryIterator.HasNext() {
+		entryKV, errEntryKV := entryIterator.Next()
+		if errEntryKV != nil {
+			return nil, &StorageError{collection, indexName, errEntryKV}
+		}
+
+		_, attributes, errAttributes := stub.SplitCompositeKey(entryKV.Key)
+		if errAttributes != nil || len(attributes) != 2 {
+			return nil, NewError(CodeCorruptRecord, "invalid entry of index "+indexName+" in "+collection)
+		}
+		values = append(values, attributes[1])
+	}
+	return values, nil
+}
+
+/**
+ * get the ids of the patients of an index keyed by a value of their records, like insuranceCard~patient
+ * only patients whose consent covers the invoker are returned, each of them is logged to the access log
+ * @param: value of the indexed field
+ */
+func GetPatientsByIndex(stub shim.ChaincodeStubInterface, args []string, collection string, indexName string) pb.Response {
+	errArgs := CheckArgs(args, 1)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	//a lookup spans patients, so a patient cannot look up and reads its own record with query
+	resource := RecordCollections[collection].Resource
+	identity, errPermission := AuthorizeInvoker(stub, resource, ActionRead, "")
+	if errPermission != nil {
+		return ErrorResponse(errPermission)
+	}
+
+	patientids, errPatientids := LookupIndex(stub, collection, indexName, args[0])
+	if errPatientids != nil {
+		return ErrorResponse(errPatientids)
+	}
+
+	timeQuery, errTimeQuery := GetTxTimestamp(stub)
+	if errTimeQuery != nil {
+		return ErrorResponse(errTimeQuery)
+	}
+
+	//patients who did not consent are left out
+	consentedPatientids := []string{}
+	for _, patientid := range patientids {
+		errConsent := CheckConsent(stub, identity, resource, PurposeQuery, patientid)
+		if errConsent != nil && ToError(errConsent).Code == CodeForbidden {
+			continue
+		} else if errConsent != nil {
+			return ErrorResponse(errConsent)
+		}
+
+		//the link of a patient to the looked up value is health information, so it is logged like a read of the record
+		query := &Query{ObjectTypeQuery, identity.ID, identity.MSPID, identity.Role, patientid, "", timeQuery, PurposeQuery, stub.GetTxID(), nil, nil}
+		errLogAccess := LogAccess(stub, QueryCollection, query)
+		if errLogAccess != nil {
+			return ErrorResponse(errLogAccess)
+		}
+		consentedPatientids = append(consentedPatientids, patientid)
+	}
+
+	patientidsAsByte, errPatientidsAsByte := json.Marshal(consentedPatientids)
+	if errPatientidsAsByte != nil {
+		return ErrorResponse(errPatientidsAsByte)
+	}
+	return shim.Success(patientidsAsByte)
+}
+
+/**
+ * get the values of the records of a patient from an index keyed by patient, like patient~drug
+ * @param: patientid
+ */
+func GetIndexByPatient(stub shim.ChaincodeStubInterface, args []string, collection string, indexName string) pb.Response {
+	errArgs := CheckArgs(args, 1)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	patientid := args[0]
+
+	//check permission and consent, then append the access to the log
+	recordCollection := RecordCollections[collection]
+	identity, errAccess := CheckAccess(stub, recordCollection.Resource, PurposeQuery, patientid, "")
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	values, errValues := LookupIndex(stub, collection, indexName, patientid)
+	if errValues != nil {
+		return ErrorResponse(errValues)
+	}
+
+	//notify listeners, the event carries no health information
+	errEvent := SetRecordEvent(stub, EventRecordQueried, recordCollection.ObjectType, patientid, identity.ID)
+	if errEvent != nil {
+		return ErrorResponse(errEvent)
+	}
+
+	valuesAsByte, errValuesAsByte := json.Marshal(values)
+	if errValuesAsByte != nil {
+		return ErrorResponse(errValuesAsByte)
+	}
+	return shim.Success(valuesAsByte)
+}
+
+func GetPatientsByInsuranceCard(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return GetPatientsByIndex(stub, args, PatientInformationCollection, "insuranceCard~patient")
+}
+
+func GetPatientsByPrescriber(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return GetPatientsByIndex(stub, args, DrugInformationCollection, "prescriber~patient")
+}
+
+func GetDrugsByPatient(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return GetIndexByPatient(stub, args, DrugInformationCollection, "patient~drug")
+}
+
+func GetServiceDatesByPatient(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return GetIndexByPatient(stub, args, HospitalFeesCollection, "patient~serviceDate")
+}
@@ -0,0 +1,601 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// kinds of stock movement, a receipt adds to a lot, a dispense takes from it and an adjustment corrects it either way
+const (
+	MovementReceipt    = "receipt"
+	MovementDispense   = "dispense"
+	MovementAdjustment = "adjustment"
+)
+
+/**
+ * CatalogItem is a drug the pharmacies stock, saved in world state under catalogItem~id
+ * the inventory holds no health information of patients, so every org can read it like the drug reference table
+ */
+type CatalogItem struct {
+	ObjectType  string `json:"docType"`
+	ID          string `json:"id"`
+	DrugName    string `json:"drug_name"`
+	Unit        string `json:"unit"`
+	Description string `json:"description"`
+}
+
+/**
+ * Lot of a catalog item, saved in world state under lot~itemid~lotnumber
+ * Quantity is the stock on hand in units of the item, Movements the number of movements of the lot
+ */
+type Lot struct {
+	ObjectType     string `json:"docType"`
+	ItemID         string `json:"item_id"`
+	LotNumber      string `json:"lot_number"`
+	Manufacturer   string `json:"manufacturer"`
+	ExpirationDate string `json:"expiration_date"`
+	Quantity       int64  `json:"quantity"`
+	Movements      int64  `json:"movements"`
+}
+
+/**
+ * StockMovement of a lot, saved in world state under stockMovement~itemid~lotnumber~sequence
+ * Quantity is negative when stock is taken, Balance the stock of the lot after the movement;
+ * the movement of a dispense names neither the patient nor the prescription, the dispense of the prescription names the lots
+ */
+type StockMovement struct {
+	ObjectType string `json:"docType"`
+	Kind       string `json:"kind"`
+	ItemID     string `json:"item_id"`
+	LotNumber  string `json:"lot_number"`
+	Quantity   int64  `json:"quantity"`
+	Balance    int64  `json:"balance"`
+	Reason     string `json:"reason"`
+	By         string `json:"by"`
+	Time       string `json:"time"`
+	TxID       string `json:"txid"`
+}
+
+// StockLevel is the output of getStock, Available counts the lots not expired
+type StockLevel struct {
+	Item      *CatalogItem `json:"item"`
+	Lots      []*Lot       `json:"lots"`
+	Available int64        `json:"available"`
+	Expired   int64        `json:"expired"`
+}
+
+func catalogItemKey(stub shim.ChaincodeStubInterface, itemid string) (string, error) {
+	return stub.CreateCompositeKey("catalogItem", []string{itemid})
+}
+
+//key of the entry of the catalog index by drug, dispensing finds the items of a prescribed drug with it
+func catalogDrugKey(stub shim.ChaincodeStubInterface, drugName string, itemid string) (string, error) {
+	return stub.CreateCompositeKey("catalogDrug~item", []string{NormalizeDrug(drugName), itemid})
+}
+
+func lotKey(stub shim.ChaincodeStubInterface, itemid string, lotNumber string) (string, error) {
+	return stub.CreateCompositeKey("lot", []string{itemid, lotNumber})
+}
+
+//movements of a lot sort by their sequence, in the order they were written
+func stockMovementKey(stub shim.ChaincodeStubInterface, itemid string, lotNumber string, sequence int64) (string, error) {
+	return stub.CreateCompositeKey("stockMovement", []string{itemid, lotNumber, fmt.Sprintf("%020d", sequence)})
+}
+
+//get an entry of the inventory from world state, false when it does not exist
+func getInventory(stub shim.ChaincodeStubInterface, key string, objectType string, entry interface{}) (bool, error) {
+	entryAsByte, errEntryAsByte := stub.GetState(key)
+	if errEntryAsByte != nil {
+		return false, NewError(CodeStorage, "cannot get "+objectType+": "+errEntryAsByte.Error())
+	} else if entryAsByte == nil {
+		return false, nil
+	}
+
+	errEntry := json.Unmarshal(entryAsByte, entry)
+	if errEntry != nil {
+		return false, NewError(CodeCorruptRecord, "cannot read "+objectType+": "+errEntry.Error())
+	}
+	return true, nil
+}
+
+func putInventory(stub shim.ChaincodeStubInterface, key string, objectType string, entry interface{}) error {
+	entryAsByte, errEntryAsByte := json.Marshal(entry)
+	if errEntryAsByte != nil {
+		return errEntryAsByte
+	}
+
+	errEntryAsByte = stub.PutState(key, entryAsByte)
+	if errEntryAsByte != nil {
+		return NewError(CodeStorage, "cannot save "+objectType+": "+errEntryAsByte.Error())
+	}
+	return nil
+}
+
+func GetCatalogItem(stub shim.ChaincodeStubInterface, itemid string) (*CatalogItem, error) {
+	key, errKey := catalogItemKey(stub, itemid)
+	if errKey != nil {
+		return nil, errKey
+	}
+
+	item := &CatalogItem{}
+	found, errItem := getInventory(stub, key, ObjectTypeCatalogItem, item)
+	if errItem != nil {
+		return nil, errItem
+	} else if !found {
+		return nil, NewFieldError(CodeNotFound, "item_id", "catalog item "+itemid+" does not exist")
+	}
+	return item, nil
+}
+
+func GetLot(stub shim.ChaincodeStubInterface, itemid string, lotNumber string) (*Lot, error) {
+	key, errKey := lotKey(stub, itemid, lotNumber)
+	if errKey != nil {
+		return nil, errKey
+	}
+
+	lot := &Lot{}
+	found, errLot := getInventory(stub, key, ObjectTypeLot, lot)
+	if errLot != nil {
+		return nil, errLot
+	} else if !found {
+		return nil, NewFieldError(CodeNotFound, "lot_number", "lot "+lotNumber+" of catalog item "+itemid+" does not exist")
+	}
+	return lot, nil
+}
+
+//get the lots of a catalog item, in order of lot number
+func GetLots(stub shim.ChaincodeStubInterface, itemid string) ([]*Lot, error) {
+	lotIterator, errLotIterator := stub.GetStateByPartialCompositeKey("lot", []string{itemid})
+	if errLotIterator != nil {
+		return nil, NewError(CodeStorage, "cannot get lots of "+itemid+": "+errLotIterator.Error())
+	}
+	defer lotIterator.Close()
+
+	lots := []*Lot{}
+	for lotIterator.HasNext() {
+		lotKV, errLotKV := lotIterator.Next()
+		if errLotKV != nil {
+			return nil, NewError(CodeStorage, "cannot get lots of "+itemid+": "+errLotKV.Error())
+		}
+
+		lot := &Lot{}
+		errLot := json.Unmarshal(lotKV.Value, lot)
+		if errLot != nil {
+			return nil, NewError(CodeCorruptRecord, "cannot read "+ObjectTypeLot+": "+errLot.Error())
+		}
+		lots = append(lots, lot)
+	}
+	return lots, nil
+}
+
+//a lot is expired the day after its expiration date
+func (lot *Lot) IsExpired(today string) bool {
+	return lot.ExpirationDate < today
+}
+
+/**
+ * change the stock of a lot and write the movement, the stock cannot go below zero
+ * the lot is written once, so it is not read again in the same transaction
+ */
+func (lot *Lot) Move(stub shim.ChaincodeStubInterface, kind string, quantity int64, reason string, by string) (*StockMovement, error) {
+	if lot.Quantity+quantity < 0 {
+		return nil, NewFieldError(CodeConflict, "quantity", "insufficient stock of lot "+lot.LotNumber+" of catalog item "+lot.ItemID+", "+strconv.FormatInt(lot.Quantity, 10)+" remaining")
+	}
+
+	timeMovement, errTimeMovement := GetTxTimestamp(stub)
+	if errTimeMovement != nil {
+		return nil, errTimeMovement
+	}
+
+	lot.Quantity += quantity
+	lot.Movements++
+	movement := &StockMovement{ObjectTypeStockMovement, kind, lot.ItemID, lot.LotNumber, quantity, lot.Quantity, reason, by, timeMovement, stub.GetTxID()}
+	movementKey, errMovementKey := stockMovementKey(stub, lot.ItemID, lot.LotNumber, lot.Movements)
+	if errMovementKey != nil {
+		return nil, errMovementKey
+	}
+	errMovement := putInventory(stub, movementKey, ObjectTypeStockMovement, movement)
+	if errMovement != nil {
+		return nil, errMovement
+	}
+
+	key, errKey := lotKey(stub, lot.ItemID, lot.LotNumber)
+	if errKey != nil {
+		return nil, errKey
+	}
+	errLot := putInventory(stub, key, ObjectTypeLot, lot)
+	if errLot != nil {
+		return nil, errLot
+	}
+	return movement, nil
+}
+
+//get identity of the invoker and check it is a pharmacist of the pharmacies, who alone change the inventory
+func authorizeInventory(stub shim.ChaincodeStubInterface) (*Identity, error) {
+	identity, errPermission := AuthorizeInvoker(stub, ResourceInventory, ActionModify, "")
+	if errPermission != nil {
+		return nil, errPermission
+	}
+	errPharmacy := checkPharmacy(identity)
+	if errPharmacy != nil {
+		return nil, errPharmacy
+	}
+	return identity, nil
+}
+
+//date of the transaction, lots expiring before it are expired
+func txDate(stub shim.ChaincodeStubInterface) (string, error) {
+	txTime, errTxTime := GetTxTime(stub)
+	if errTxTime != nil {
+		return "", errTxTime
+	}
+	return txTime.Format(DateLayout), nil
+}
+
+/**
+ * add or replace an item of the drug catalog, only pharmacists of PharmacyMSPID may
+ * @param: itemId
+ * @param: drugName, prescriptions of the drug are dispensed from the lots of the item
+ * @param: unit, of the stock of the item, like tablet
+ * @param: description, may be empty
+ */
+func PutCatalogItem(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgCount(args, 4)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+	errArgs = CheckNotEmpty(args[:3])
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	itemid := args[0]
+	drugName := NormalizeDrug(args[1])
+	unit := strings.ToLower(strings.TrimSpace(args[2]))
+	if strings.ContainsAny(unit, " \t") {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "unit", "unit must be a single word, like tablet"))
+	}
+
+	_, errInventory := authorizeInventory(stub)
+	if errInventory != nil {
+		return ErrorResponse(errInventory)
+	}
+
+	key, errKey := catalogItemKey(stub, itemid)
+	if errKey != nil {
+		return ErrorResponse(errKey)
+	}
+	existingItem := &CatalogItem{}
+	found, errItem := getInventory(stub, key, ObjectTypeCatalogItem, existingItem)
+	if errItem != nil {
+		return ErrorResponse(errItem)
+	}
+
+	//move the item in the index by drug when its drug changes
+	if found && existingItem.DrugName != drugName {
+		existingDrugKey, errExistingDrugKey := catalogDrugKey(stub, existingItem.DrugName, itemid)
+		if errExistingDrugKey != nil {
+			return ErrorResponse(errExistingDrugKey)
+		}
+		errItem = stub.DelState(existingDrugKey)
+		if errItem != nil {
+			return ErrorResponse(NewError(CodeStorage, "cannot delete "+ObjectTypeCatalogItem+": "+errItem.Error()))
+		}
+	}
+	drugKey, errDrugKey := catalogDrugKey(stub, drugName, itemid)
+	if errDrugKey != nil {
+		return ErrorResponse(errDrugKey)
+	}
+	errItem = stub.PutState(drugKey, indexValue)
+	if errItem != nil {
+		return ErrorResponse(NewError(CodeStorage, "cannot save "+ObjectTypeCatalogItem+": "+errItem.Error()))
+	}
+
+	errItem = putInventory(stub, key, ObjectTypeCatalogItem, &CatalogItem{ObjectTypeCatalogItem, itemid, drugName, unit, args[3]})
+	if errItem != nil {
+		return ErrorResponse(errItem)
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * receive stock of a lot of a catalog item, the lot is created by its first receipt; only pharmacists of PharmacyMSPID may
+ * an expired lot cannot be received, and a lot is always of the same manufacturer and expiration date
+ * @param: itemId
+ * @param: lotNumber
+ * @param: manufacturer
+ * @param: expirationDate, ISO 8601 date
+ * @param: quantity, in units of the item
+ */
+func ReceiveStock(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 5)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	itemid := args[0]
+	lotNumber := args[1]
+	manufacturer := args[2]
+	expirationDate := args[3]
+	errArgs = CheckDate("expiration_date", expirationDate)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+	quantity, errQuantity := parseCount("quantity", args[4], 1)
+	if errQuantity != nil {
+		return ErrorResponse(errQuantity)
+	}
+
+	identity, errInventory := authorizeInventory(stub)
+	if errInventory != nil {
+		return ErrorResponse(errInventory)
+	}
+
+	_, errItem := GetCatalogItem(stub, itemid)
+	if errItem != nil {
+		return ErrorResponse(errItem)
+	}
+
+	today, errToday := txDate(stub)
+	if errToday != nil {
+		return ErrorResponse(errToday)
+	}
+
+	lot, errLot := GetLot(stub, itemid, lotNumber)
+	if errLot != nil && ToError(errLot).Code == CodeNotFound {
+		lot = &Lot{ObjectTypeLot, itemid, lotNumber, manufacturer, expirationDate, 0, 0}
+	} else if errLot != nil {
+		return ErrorResponse(errLot)
+	} else if lot.Manufacturer != manufacturer || lot.ExpirationDate != expirationDate {
+		return ErrorResponse(NewFieldError(CodeConflict, "lot_number", "lot "+lotNumber+" of catalog item "+itemid+" is of "+lot.Manufacturer+" and expires on "+lot.ExpirationDate))
+	}
+	if lot.IsExpired(today) {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "expiration_date", "lot "+lotNumber+" expired on "+expirationDate))
+	}
+
+	_, errLot = lot.Move(stub, MovementReceipt, quantity, "", identity.ID)
+	if errLot != nil {
+		return ErrorResponse(errLot)
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * correct the stock of a lot after a count, a loss or the disposal of expired stock; only pharmacists of PharmacyMSPID may
+ * @param: itemId
+ * @param: lotNumber
+ * @param: quantity, added to the stock, negative to take from it
+ * @param: reason
+ */
+func AdjustStock(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 4)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	itemid := args[0]
+	lotNumber := args[1]
+	reason := args[3]
+	quantity, errQuantity := strconv.ParseInt(args[2], 10, 64)
+	if errQuantity != nil || quantity == 0 {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "quantity", "quantity must be a whole number other than 0"))
+	}
+
+	identity, errInventory := authorizeInventory(stub)
+	if errInventory != nil {
+		return ErrorResponse(errInventory)
+	}
+
+	lot, errLot := GetLot(stub, itemid, lotNumber)
+	if errLot != nil {
+		return ErrorResponse(errLot)
+	}
+
+	_, errLot = lot.Move(stub, MovementAdjustment, quantity, reason, identity.ID)
+	if errLot != nil {
+		return ErrorResponse(errLot)
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * get a catalog item with the stock of its lots
+ * @param: itemId
+ * ouput: StockLevel
+ */
+func GetStock(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 1)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	_, errPermission := AuthorizeInvoker(stub, ResourceInventory, ActionRead, "")
+	if errPermission != nil {
+		return ErrorResponse(errPermission)
+	}
+
+	item, errItem := GetCatalogItem(stub, args[0])
+	if errItem != nil {
+		return ErrorResponse(errItem)
+	}
+	lots, errLots := GetLots(stub, item.ID)
+	if errLots != nil {
+		return ErrorResponse(errLots)
+	}
+
+	today, errToday := txDate(stub)
+	if errToday != nil {
+		return ErrorResponse(errToday)
+	}
+
+	stock := &StockLevel{Item: item, Lots: lots}
+	for _, lot := range lots {
+		if lot.IsExpired(today) {
+			stock.Expired += lot.Quantity
+		} else {
+			stock.Available += lot.Quantity
+		}
+	}
+
+	stockAsByte, errStockAsByte := json.Marshal(stock)
+	if errStockAsByte != nil {
+		return ErrorResponse(errStockAsByte)
+	}
+	return shim.Success(stockAsByte)
+}
+
+/**
+ * list the movements of a lot in the order they were written, a page of DefaultPageSize at a time
+ * @param: itemId
+ * @param: lotNumber
+ * @param: bookmark, optional, returned by the previous page
+ */
+func ListStockMovements(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return ErrorResponse(NewError(CodeInvalidArgument, "expecting 2 or 3 argument"))
+	}
+
+	errArgs := CheckNotEmpty(args[:2])
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	itemid := args[0]
+	lotNumber := args[1]
+	bookmark := ""
+	if len(args) == 3 {
+		bookmark = args[2]
+	}
+
+	_, errPermission := AuthorizeInvoker(stub, ResourceInventory, ActionRead, "")
+	if errPermission != nil {
+		return ErrorResponse(errPermission)
+	}
+
+	movementIterator, errMovementIterator := stub.GetStateByPartialCompositeKey("stockMovement", []string{itemid, lotNumber})
+	if errMovementIterator != nil {
+		return ErrorResponse(NewError(CodeStorage, "cannot get movements of lot "+lotNumber+": "+errMovementIterator.Error()))
+	}
+	defer movementIterator.Close()
+
+	page := &Page{Records: []interface{}{}}
+	lastKey := ""
+	for movementIterator.HasNext() {
+		movementKV, errMovementKV := movementIterator.Next()
+		if errMovementKV != nil {
+			return ErrorResponse(NewError(CodeStorage, "cannot get movements of lot "+lotNumber+": "+errMovementKV.Error()))
+		}
+		//the bookmark is the last movement of the previous page
+		if movementKV.Key <= bookmark {
+			continue
+		}
+		//a movement past a full page only tells there is a next page
+		if len(page.Records) == DefaultPageSize {
+			page.Bookmark = lastKey
+			break
+		}
+
+		movement := &StockMovement{}
+		errMovement := json.Unmarshal(movementKV.Value, movement)
+		if errMovement != nil {
+			return ErrorResponse(NewError(CodeCorruptRecord, "cannot read "+ObjectTypeStockMovement+": "+errMovement.Error()))
+		}
+		page.Records = append(page.Records, movement)
+		lastKey = movementKV.Key
+	}
+
+	pageAsByte, errPageAsByte := json.Marshal(page)
+	if errPageAsByte != nil {
+		return ErrorResponse(errPageAsByte)
+	}
+	return shim.Success(pageAsByte)
+}
+
+/**
+ * find the lots a dispense of a drug is taken from
+ * a lot named by the pharmacist must be of the drug, not expired and hold the quantity;
+ * otherwise the quantity is taken from the lots of the items of the drug that are not expired, first expiry first out,
+ * so a dispense spans as many lots as it takes to hold the quantity
+ * @param: itemid, lotNumber, empty to let the lots be found
+ */
+func FindDispenseLots(stub shim.ChaincodeStubInterface, drugName string, itemid string, lotNumber string, quantity int64) ([]*Lot, error) {
+	today, errToday := txDate(stub)
+	if errToday != nil {
+		return nil, errToday
+	}
+
+	if len(itemid) > 0 {
+		item, errItem := GetCatalogItem(stub, itemid)
+		if errItem != nil {
+			return nil, errItem
+		} else if item.DrugName != NormalizeDrug(drugName) {
+			return nil, NewFieldError(CodeInvalidArgument, "item_id", "catalog item "+itemid+" is not "+drugName)
+		}
+
+		lot, errLot := GetLot(stub, itemid, lotNumber)
+		if errLot != nil {
+			return nil, errLot
+		} else if lot.IsExpired(today) {
+			return nil, NewFieldError(CodeConflict, "lot_number", "lot "+lotNumber+" of catalog item "+itemid+" expired on "+lot.ExpirationDate)
+		} else if lot.Quantity < quantity {
+			return nil, NewFieldError(CodeConflict, "quantity", "insufficient stock of lot "+lotNumber+" of catalog item "+itemid+", "+strconv.FormatInt(lot.Quantity, 10)+" remaining")
+		}
+		return []*Lot{lot}, nil
+	}
+
+	itemIterator, errItemIterator := stub.GetStateByPartialCompositeKey("catalogDrug~item", []string{NormalizeDrug(drugName)})
+	if errItemIterator != nil {
+		return nil, NewError(CodeStorage, "cannot get catalog items of "+drugName+": "+errItemIterator.Error())
+	}
+	defer itemIterator.Close()
+
+	lots := []*Lot{}
+	expired := false
+	for itemIterator.HasNext() {
+		itemKV, errItemKV := itemIterator.Next()
+		if errItemKV != nil {
+			return nil, NewError(CodeStorage, "cannot get catalog items of "+drugName+": "+errItemKV.Error())
+		}
+		_, attributes, errAttributes := stub.SplitCompositeKey(itemKV.Key)
+		if errAttributes != nil || len(attributes) != 2 {
+			return nil, NewError(CodeCorruptRecord, "invalid entry of the catalog index by drug")
+		}
+
+		itemLots, errLots := GetLots(stub, attributes[1])
+		if errLots != nil {
+			return nil, errLots
+		}
+		for _, lot := range itemLots {
+			if lot.Quantity == 0 {
+				continue
+			} else if lot.IsExpired(today) {
+				expired = true
+				continue
+			}
+			lots = append(lots, lot)
+		}
+	}
+
+	//lots are in order of item and lot number, so lots expiring the same day keep this order
+	sort.SliceStable(lots, func(i int, j int) bool {
+		return lots[i].ExpirationDate < lots[j].ExpirationDate
+	})
+	available := int64(0)
+	for index, lot := range lots {
+		available += lot.Quantity
+		if available >= quantity {
+			return lots[:index+1], nil
+		}
+	}
+
+	if available == 0 && expired {
+		return nil, NewFieldError(CodeConflict, "lot_number", "every lot of "+drugName+" in stock is expired")
+	}
+	return nil, NewFieldError(CodeConflict, "quantity", "insufficient stock of "+drugName+", "+strconv.FormatInt(available, 10)+" remaining in lots not expired")
+}
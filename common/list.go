@@ -0,0 +1,173 @@
+package common
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// bounds of the page size of a list function
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// RecordCollection is a private data collection of patient records, keyed by patient id
+type RecordCollection struct {
+	Resource   string
+	ObjectType string
+	//allocates the struct a record of the collection is decoded into
+	NewRecord func() interface{}
+}
+
+// collections of patient records that can be listed and searched
+var RecordCollections = map[string]*RecordCollection{
+	PatientInformationCollection: {ResourcePatientInformation, ObjectTypePatientInformation, func() interface{} { return &PatientInformation{} }},
+	MedicalRecordCollection:      {ResourceMedicalRecord, ObjectTypeMedicalRecord, func() interface{} { return &MedicalRecord{} }},
+	DrugInformationCollection:    {ResourceDrugInformation, ObjectTypeDrugInformation, func() interface{} { return &DrugInformation{} }},
+	HospitalFeesCollection:       {ResourceHospitalFees, ObjectTypeHospitalFees, func() interface{} { return &HospitalFees{} }},
+}
+
+/**
+ * Page of records returned by a list function
+ * Bookmark is the continuation token of the next page, empty on the last page
+ */
+type Page struct {
+	Records  []interface{} `json:"records"`
+	Bookmark string        `json:"bookmark"`
+}
+
+//get page size and bookmark from the arguments of a list function, both are optional
+func DecodePagination(args []string) (int, string, error) {
+	if len(args) > 2 {
+		return 0, "", NewError(CodeInvalidArgument, "expecting at most 2 argument")
+	}
+
+	pageSize := DefaultPageSize
+	if len(args) > 0 && len(args[0]) > 0 {
+		size, errSize := strconv.Atoi(args[0])
+		if errSize != nil || size < 1 || size > MaxPageSize {
+			return 0, "", NewFieldError(CodeInvalidArgument, "pageSize", "pageSize must be a number between 1 and "+strconv.Itoa(MaxPageSize))
+		}
+		pageSize = size
+	}
+
+	bookmark := ""
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+	return pageSize, bookmark, nil
+}
+
+/**
+ * list the records of a collection a page at a time, in order of id
+ * private data has no paginated queries, so the page is read from a range scan starting at the bookmark;
+ * the scan skips composite keys, so indexes and revisions kept in the collection are not listed
+ * @param: pageSize, optional, DefaultPageSize when empty
+ * @param: bookmark, optional, returned by the previous page
+ */
+func ListRecords(stub shim.ChaincodeStubInterface, args []string, collection string) pb.Response {
+	pageSize, bookmark, errArgs := DecodePagination(args)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	//a listing spans patients, so a patient cannot list and reads its own record with query
+	recordCollection := RecordCollections[collection]
+	identity, errPermission := AuthorizeInvoker(stub, recordCollection.Resource, ActionRead, "")
+	if errPermission != nil {
+		return ErrorResponse(errPermission)
+	}
+
+	recordIterator, errRecordIterator := stub.GetPrivateDataByRange(collection, bookmark, "")
+	if errRecordIterator != nil {
+		return ErrorResponse(&StorageError{collection, bookmark, errRecordIterator})
+	}
+	defer recordIterator.Close()
+
+	page, errPage := ReadPage(stub, identity, collection, recordIterator, pageSize, bookmark)
+	if errPage != nil {
+		return ErrorResponse(errPage)
+	}
+
+	pageAsByte, errPageAsByte := json.Marshal(page)
+	if errPageAsByte != nil {
+		return ErrorResponse(errPageAsByte)
+	}
+	return shim.Success(pageAsByte)
+}
+
+/**
+ * read a page of records from an iterator, the records up to the bookmark are skipped when it is in order of id
+ * only records of patients whose consent covers the invoker are read, each of them is logged to the access log
+ * at most MaxPageSize records are scanned, so records left out of the page cannot make a page read the whole collection
+ * @param: identity already authorized to read the records of the collection
+ */
+func ReadPage(stub shim.ChaincodeStubInterface, identity *Identity, collection string, recordIterator shim.StateQueryIteratorInterface, pageSize int, bookmark string) (*Page, error) {
+	recordCollection := RecordCollections[collection]
+	timeQuery, errTimeQuery := GetTxTimestamp(stub)
+	if errTimeQuery != nil {
+		return nil, errTimeQuery
+	}
+
+	page := &Page{Records: []interface{}{}}
+	read := 0
+	lastKey := ""
+	for recordIterator.HasNext() {
+		recordKV, errRecordKV := recordIterator.Next()
+		if errRecordKV != nil {
+			return nil, &StorageError{collection, bookmark, errRecordKV}
+		}
+		//the bookmark is the last record of the previous page
+		if recordKV.Key <= bookmark {
+			continue
+		}
+		//a record past a full page or past the scanned records only tells there is a next page
+		if len(page.Records) == pageSize || read == MaxPageSize {
+			page.Bookmark = lastKey
+			break
+		}
+		read++
+		lastKey = recordKV.Key
+
+		//records of patients who did not consent are left out of the page
+		errConsent := CheckConsent(stub, identity, recordCollection.Resource, PurposeQuery, recordKV.Key)
+		if errConsent != nil && ToError(errConsent).Code == CodeForbidden {
+			continue
+		} else if errConsent != nil {
+			return nil, errConsent
+		}
+
+		record := recordCollection.NewRecord()
+		errRecord := DecodeRecord(collection, recordKV.Key, recordCollection.ObjectType, recordKV.Value, record)
+		if errRecord != nil {
+			return nil, errRecord
+		}
+
+		query := &Query{ObjectTypeQuery, identity.ID, identity.MSPID, identity.Role, recordKV.Key, "", timeQuery, PurposeQuery, stub.GetTxID(), nil, nil}
+		errLogAccess := LogAccess(stub, QueryCollection, query)
+		if errLogAccess != nil {
+			return nil, errLogAccess
+		}
+		page.Records = append(page.Records, record)
+	}
+	return page, nil
+}
+
+func ListPatients(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return ListRecords(stub, args, PatientInformationCollection)
+}
+
+func ListMedicalRecords(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return ListRecords(stub, args, MedicalRecordCollection)
+}
+
+func ListDrugInformation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return ListRecords(stub, args, DrugInformationCollection)
+}
+
+func ListHospitalFees(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return ListRecords(stub, args, HospitalFeesCollection)
+}
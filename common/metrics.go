@@ -0,0 +1,123 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// level of the per-function timing entries, set with TIMING_LOG_LEVEL (DEBUG, INFO, NOTICE, WARNING, ERROR, CRITICAL)
+var timingLevel = getTimingLevel()
+
+// functions tracked separately by GetMetrics, calls to any other function name are counted as "other"
+const maxTrackedFunctions = 64
+
+// FunctionMetrics of a chaincode function, collected since the chaincode container started
+type FunctionMetrics struct {
+	Invocations      uint64  `json:"invocations"`
+	Errors           uint64  `json:"errors"`
+	TotalLatencyMs   float64 `json:"total_latency_ms"`
+	MinLatencyMs     float64 `json:"min_latency_ms"`
+	MaxLatencyMs     float64 `json:"max_latency_ms"`
+	AverageLatencyMs float64 `json:"average_latency_ms"`
+}
+
+// Metrics returned by GetMetrics
+type Metrics struct {
+	StartedAt string                      `json:"started_at"`
+	Functions map[string]*FunctionMetrics `json:"functions"`
+}
+
+var metrics = struct {
+	sync.Mutex
+	startedAt time.Time
+	functions map[string]*FunctionMetrics
+}{startedAt: time.Now(), functions: map[string]*FunctionMetrics{}}
+
+func getTimingLevel() shim.LoggingLevel {
+	level, errLevel := shim.LogLevel(os.Getenv("TIMING_LOG_LEVEL"))
+	if errLevel != nil {
+		return shim.LogInfo
+	}
+	return level
+}
+
+func logTiming(logger *shim.ChaincodeLogger, format string, args ...interface{}) {
+	switch timingLevel {
+	case shim.LogDebug:
+		logger.Debugf(format, args...)
+	case shim.LogInfo:
+		logger.Infof(format, args...)
+	case shim.LogNotice:
+		logger.Noticef(format, args...)
+	case shim.LogWarning:
+		logger.Warningf(format, args...)
+	case shim.LogError:
+		logger.Errorf(format, args...)
+	default:
+		logger.Criticalf(format, args...)
+	}
+}
+
+//log timing of a chaincode function with the logger of the chaincode and add it to the metrics
+func RecordInvocation(logger *shim.ChaincodeLogger, stub shim.ChaincodeStubInterface, function string, start time.Time, response pb.Response) {
+	elapsed := time.Since(start)
+	logTiming(logger, "function=%s txid=%s status=%d duration=%s", function, stub.GetTxID(), response.Status, elapsed)
+
+	latencyMs := float64(elapsed) / float64(time.Millisecond)
+
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	functionMetrics, found := metrics.functions[function]
+	if !found && len(metrics.functions) >= maxTrackedFunctions {
+		function = "other"
+		functionMetrics, found = metrics.functions[function]
+	}
+	if !found {
+		functionMetrics = &FunctionMetrics{MinLatencyMs: latencyMs}
+		metrics.functions[function] = functionMetrics
+	}
+
+	functionMetrics.Invocations++
+	if response.Status >= shim.ERRORTHRESHOLD {
+		functionMetrics.Errors++
+	}
+	functionMetrics.TotalLatencyMs += latencyMs
+	if latencyMs < functionMetrics.MinLatencyMs {
+		functionMetrics.MinLatencyMs = latencyMs
+	}
+	if latencyMs > functionMetrics.MaxLatencyMs {
+		functionMetrics.MaxLatencyMs = latencyMs
+	}
+	functionMetrics.AverageLatencyMs = functionMetrics.TotalLatencyMs / float64(functionMetrics.Invocations)
+}
+
+/**
+ * get invocation counts and latencies of every function since the chaincode container started
+ * values are local to the peer answering the query
+ */
+func GetMetrics(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgCount(args, 0)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	metrics.Lock()
+	snapshot := &Metrics{metrics.startedAt.UTC().Format(time.RFC3339Nano), map[string]*FunctionMetrics{}}
+	for function, functionMetrics := range metrics.functions {
+		functionMetricsCopy := *functionMetrics
+		snapshot.Functions[function] = &functionMetricsCopy
+	}
+	metrics.Unlock()
+
+	metricsAsByte, errMetricsAsByte := json.Marshal(snapshot)
+	if errMetricsAsByte != nil {
+		return ErrorResponse(errMetricsAsByte)
+	}
+	return shim.Success(metricsAsByte)
+}
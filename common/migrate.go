@@ -0,0 +1,292 @@
+package common
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/**
+ * layouts of the free-form dates written by older releases that read the same in every locale
+ * numeric day and month dates, like 01/02/2030, are read by migrateDate
+ */
+var legacyDateLayouts = []string{
+	"2006/01/02",
+	"2006.01.02",
+	"20060102",
+	"2006-1-2",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+// MigrationReport is the result of a page of migrateRecords, it holds no value of the records
+type MigrationReport struct {
+	Migrated int                 `json:"migrated"`
+	Failures []*MigrationFailure `json:"failures"`
+	Bookmark string              `json:"bookmark"`
+}
+
+// MigrationFailure is a field of a record whose value could not be parsed, it is kept until the record is corrected
+type MigrationFailure struct {
+	ID    string `json:"id"`
+	Field string `json:"field"`
+}
+
+// MigratedRecord is a record with typed fields that migrateRecords converts
+type MigratedRecord interface {
+	VersionedRecord
+	//convert the typed fields, returns the fields that were converted and the fields that could not be parsed
+	Migrate(unit string, currency string) ([]string, []string)
+}
+
+/**
+ * convert a free-form date to an ISO 8601 date
+ * a date of numbers only is read as day/month/year or month/day/year when one of them is over 12,
+ * otherwise it is ambiguous and not converted
+ */
+func migrateDate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 || CheckDate("", value) == nil {
+		return value, nil
+	}
+	if dateTime, errDateTime := time.Parse(time.RFC3339, value); errDateTime == nil {
+		return dateTime.Format(DateLayout), nil
+	}
+	for _, layout := range legacyDateLayouts {
+		date, errDate := time.Parse(layout, value)
+		if errDate == nil {
+			return date.Format(DateLayout), nil
+		}
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool { return r == '/' || r == '-' || r == '.' })
+	if len(parts) != 3 || len(parts[2]) != 4 {
+		return "", NewError(CodeInvalidArgument, "unknown date format")
+	}
+	first, errFirst := strconv.Atoi(parts[0])
+	second, errSecond := strconv.Atoi(parts[1])
+	year, errYear := strconv.Atoi(parts[2])
+	if errFirst != nil || errSecond != nil || errYear != nil {
+		return "", NewError(CodeInvalidArgument, "unknown date format")
+	}
+
+	day, month := first, second
+	if first <= 12 && second > 12 {
+		day, month = second, first
+	} else if first <= 12 && second <= 12 && first != second {
+		return "", NewError(CodeInvalidArgument, "ambiguous date")
+	}
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if date.Day() != day || int(date.Month()) != month {
+		return "", NewError(CodeInvalidArgument, "invalid date")
+	}
+	return date.Format(DateLayout), nil
+}
+
+func (patient *PatientInformation) Migrate(unit string, currency string) ([]string, []string) {
+	if CheckDateTime("", patient.MakeNoteOfAppointmentDate) == nil {
+		return nil, nil
+	}
+	date, errDate := migrateDate(patient.MakeNoteOfAppointmentDate)
+	if errDate != nil {
+		return nil, []string{"make_note_of_appointment_date"}
+	} else if date == patient.MakeNoteOfAppointmentDate {
+		return nil, nil
+	}
+	patient.MakeNoteOfAppointmentDate = date
+	return []string{"make_note_of_appointment_date"}, nil
+}
+
+func (drug *DrugInformation) Migrate(unit string, currency string) ([]string, []string) {
+	migrated := []string{}
+	failures := []string{}
+	date, errDate := migrateDate(drug.ExpirationDate)
+	if errDate != nil {
+		failures = append(failures, "expiration_date")
+	} else if date != drug.ExpirationDate {
+		drug.ExpirationDate = date
+		migrated = append(migrated, "expiration_date")
+	}
+
+	if len(drug.Quantity.Text) > 0 {
+		quantity, errQuantity := ParseQuantity(drug.Quantity.Text, unit)
+		if errQuantity != nil {
+			failures = append(failures, "quantity")
+		} else {
+			drug.Quantity = quantity
+			migrated = append(migrated, "quantity")
+		}
+	}
+	return migrated, failures
+}
+
+func (hospitalFees *HospitalFees) Migrate(unit string, currency string) ([]string, []string) {
+	migrated := []string{}
+	failures := []string{}
+	date, errDate := migrateDate(hospitalFees.DateOfService)
+	if errDate != nil {
+		failures = append(failures, "date_of_service")
+	} else if date != hospitalFees.DateOfService {
+		hospitalFees.DateOfService = date
+		migrated = append(migrated, "date_of_service")
+	}
+
+	if len(hospitalFees.AmountDue.Text) > 0 {
+		amount, errAmount := ParseMoney(hospitalFees.AmountDue.Text, currency)
+		if errAmount != nil {
+			failures = append(failures, "amount_due")
+		} else {
+			hospitalFees.AmountDue = amount
+			migrated = append(migrated, "amount_due")
+		}
+	}
+	return migrated, failures
+}
+
+/**
+ * convert the quantities, dates and amounts of the records of a collection written as free-form text
+ * by older releases, MaxPageSize records per transaction in order of id; only admins may
+ * values that cannot be parsed are kept and reported by id and field, so the report discloses no value;
+ * a converted record gets a new version, its indexes and hash anchor are updated and the change is logged;
+ * the missing index entries of the other records, like those of an index added after they were written, are written
+ * @param: collection, PatientInformationCollection, DrugInformationCollection or HospitalFeesCollection
+ * @param: unit, of quantities written without one, may be empty to report them
+ * @param: currency, ISO 4217 code of amounts written without one, may be empty to report them
+ * @param: bookmark, optional, returned by the previous page
+ * transient salt: salt of the hash anchors of records of AnchorCollections
+ * ouput: MigrationReport, call again with its bookmark until it is empty
+ */
+func MigrateRecords(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 && len(args) != 4 {
+		return ErrorResponse(NewError(CodeInvalidArgument, "expecting 3 or 4 argument"))
+	}
+
+	errArgs := CheckNotEmpty(args[:1])
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	collection := args[0]
+	unit := strings.TrimSpace(args[1])
+	currency := strings.ToUpper(strings.TrimSpace(args[2]))
+	bookmark := ""
+	if len(args) == 4 {
+		bookmark = args[3]
+	}
+
+	recordCollection, found := RecordCollections[collection]
+	if found {
+		_, found = recordCollection.NewRecord().(MigratedRecord)
+	}
+	if !found {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "collection", "records of "+collection+" have no typed fields"))
+	} else if len(currency) > 0 && !isCurrency(currency) {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "currency", "currency must be an ISO 4217 code, like USD"))
+	} else if strings.ContainsAny(unit, " \t") {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "unit", "unit must be a single word, like tablet"))
+	}
+
+	errAdmin := AuthorizeAdmin(stub)
+	if errAdmin != nil {
+		return ErrorResponse(errAdmin)
+	}
+	identity, errIdentity := GetIdentity(stub)
+	if errIdentity != nil {
+		return ErrorResponse(errIdentity)
+	}
+	identity.Role = RoleAdmin
+
+	timeQuery, errTimeQuery := GetTxTimestamp(stub)
+	if errTimeQuery != nil {
+		return ErrorResponse(errTimeQuery)
+	}
+
+	recordIterator, errRecordIterator := stub.GetPrivateDataByRange(collection, bookmark, "")
+	if errRecordIterator != nil {
+		return ErrorResponse(&StorageError{collection, bookmark, errRecordIterator})
+	}
+	defer recordIterator.Close()
+
+	report := &MigrationReport{Failures: []*MigrationFailure{}}
+	read := 0
+	lastKey := ""
+	for recordIterator.HasNext() {
+		recordKV, errRecordKV := recordIterator.Next()
+		if errRecordKV != nil {
+			return ErrorResponse(&StorageError{collection, bookmark, errRecordKV})
+		}
+		//the bookmark is the last record of the previous page
+		if recordKV.Key <= bookmark {
+			continue
+		}
+		//a record past a full page only tells there is a next page
+		if read == MaxPageSize {
+			report.Bookmark = lastKey
+			break
+		}
+		read++
+		lastKey = recordKV.Key
+
+		record := recordCollection.NewRecord().(MigratedRecord)
+		errRecord := DecodeRecord(collection, recordKV.Key, recordCollection.ObjectType, recordKV.Value, record)
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+		migrated, failures := record.Migrate(unit, currency)
+		for _, field := range failures {
+			report.Failures = append(report.Failures, &MigrationFailure{recordKV.Key, field})
+		}
+
+		//a record with no field converted, already typed or not parsed, is left as it is, but for the entries of indexes added since
+		if len(migrated) == 0 {
+			errIndexes := RepairIndexes(stub, collection, recordKV.Key, recordKV.Value)
+			if errIndexes != nil {
+				return ErrorResponse(errIndexes)
+			}
+			continue
+		}
+		record.NextVersion()
+
+		errRecord = migrateRecord(stub, collection, recordKV.Key, record)
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+
+		query := &Query{ObjectTypeQuery, identity.ID, identity.MSPID, identity.Role, recordKV.Key, "", timeQuery, PurposeModify, stub.GetTxID(), migrated, nil}
+		errLogAccess := LogAccess(stub, ModifyCollection, query)
+		if errLogAccess != nil {
+			return ErrorResponse(errLogAccess)
+		}
+		report.Migrated++
+	}
+
+	reportAsByte, errReportAsByte := json.Marshal(report)
+	if errReportAsByte != nil {
+		return ErrorResponse(errReportAsByte)
+	}
+	return shim.Success(reportAsByte)
+}
+
+//store a converted record with its indexes and hash anchor
+func migrateRecord(stub shim.ChaincodeStubInterface, collection string, key string, record MigratedRecord) error {
+	errRecord := PutIndexes(stub, collection, key, record)
+	if errRecord != nil {
+		return errRecord
+	}
+
+	errRecord = PutRecord(stub, collection, key, record)
+	if errRecord != nil {
+		return errRecord
+	}
+
+	if AnchorCollections[collection] {
+		return PutAnchor(stub, collection, record)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package mockstub
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+)
+
+// users of the test network, all enrolled in MSPID but the pharmacist, enrolled in the pharmacy msp, and the foreign admin
+const (
+	MSPID      = "Org1MSP"
+	Admin      = "admin"
+	Clinician  = "doctor"
+	Nurse      = "nurse"
+	Pharmacist = "pharmacist"
+	Billing    = "billing"
+	Patient    = "patient"
+	Stranger   = "stranger"
+	PatientID  = "P1"
+	//certificates with the admin role attribute that are not admins of the registry
+	ForeignAdmin = "foreignadmin"
+	LateAdmin    = "lateadmin"
+)
+
+// msp of the test users of another org
+const ForeignMSPID = "Org2MSP"
+
+// consent expiry far enough in the future for every test run
+const Expiry = "2100-01-01T00:00:00Z"
+
+// roles of the users registered by RegisterUsers, the stranger is never registered
+var roles = map[string]string{
+	Admin:      common.RoleAdmin,
+	Clinician:  common.RoleClinician,
+	Nurse:      common.RoleNurse,
+	Pharmacist: common.RolePharmacist,
+	Billing:    common.RoleBilling,
+	Patient:    common.RolePatient,
+}
+
+//msp a test user is enrolled in
+func MSPIDOf(enrollmentID string) string {
+	if enrollmentID == Pharmacist {
+		return common.PharmacyMSPID
+	} else if enrollmentID == ForeignAdmin {
+		return ForeignMSPID
+	}
+	return MSPID
+}
+
+//user id of a test user, as written to the access log and consents
+func UserID(enrollmentID string) string {
+	return MSPIDOf(enrollmentID) + "::" + enrollmentID
+}
+
+//submit the next transactions as a test user, the admins hold the admin role attribute
+func (stub *MockStub) As(enrollmentID string) error {
+	var attrs map[string]string
+	if enrollmentID == Admin || enrollmentID == ForeignAdmin || enrollmentID == LateAdmin {
+		attrs = map[string]string{"role": common.RoleAdmin}
+	}
+	return stub.SetCreator(MSPIDOf(enrollmentID), enrollmentID, attrs)
+}
+
+//register every test user but the stranger in the role registry
+func (stub *MockStub) RegisterUsers() error {
+	errAdmin := stub.As(Admin)
+	if errAdmin != nil {
+		return errAdmin
+	}
+
+	for enrollmentID, role := range roles {
+		args := []string{MSPIDOf(enrollmentID), enrollmentID, role}
+		if role == common.RolePatient {
+			args = append(args, PatientID)
+		}
+		response := stub.Invoke("registerUser", args...)
+		if response.Status != shim.OK {
+			return errors.New("cannot register " + enrollmentID + ": " + response.Message)
+		}
+	}
+	return nil
+}
+
+//grant consent of the test patient to a test user, return id of consent
+func (stub *MockStub) GrantConsent(enrollmentID string, resource string, purpose string) (string, error) {
+	errPatient := stub.As(Patient)
+	if errPatient != nil {
+		return "", errPatient
+	}
+
+	response := stub.Invoke("grantConsent", UserID(enrollmentID), resource, purpose, Expiry)
+	if response.Status != shim.OK {
+		return "", errors.New("cannot grant consent: " + response.Message)
+	}
+	return string(response.Payload), nil
+}
+
+/**
+ * Case of a table-driven test: Caller invokes Function with Args and the Transient map
+ * an empty Error expects success, otherwise the error message must contain Error
+ * and, when Code is set, the error must have this code
+ */
+type Case struct {
+	Name      string
+	Caller    string
+	Function  string
+	Args      []string
+	Error     string
+	Code      string
+	Transient map[string][]byte
+}
+
+// salt of the hash anchors written by the test transactions
+const Salt = "0123456789abcdef"
+
+//transient map passing a json document and the salt of its hash anchor to a create or modify function
+func Document(document string) map[string][]byte {
+	return map[string][]byte{common.TransientDocumentKey: []byte(document), common.TransientSaltKey: []byte(Salt)}
+}
+
+//run cases in order on the same stub, so a case sees the writes of the previous ones
+func (stub *MockStub) Run(t *testing.T, cases []Case) {
+	for _, testCase := range cases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			errCaller := stub.As(testCase.Caller)
+			if errCaller != nil {
+				t.Fatal(errCaller)
+			}
+
+			stub.Transient = testCase.Transient
+			response := stub.Invoke(testCase.Function, testCase.Args...)
+			if len(testCase.Error) == 0 && response.Status != shim.OK {
+				t.Fatalf("%s: expecting success, got %d %s", testCase.Function, response.Status, response.Message)
+			} else if len(testCase.Error) != 0 && response.Status == shim.OK {
+				t.Fatalf("%s: expecting error %q, got success", testCase.Function, testCase.Error)
+			} else if len(testCase.Error) == 0 {
+				return
+			}
+
+			//every error response is a json error with a code
+			errResponse, errParse := common.ParseError(response.Message)
+			if errParse != nil {
+				t.Fatalf("%s: expecting json error, got %q", testCase.Function, response.Message)
+			} else if len(errResponse.Code) == 0 {
+				t.Fatalf("%s: expecting error code, got %q", testCase.Function, response.Message)
+			} else if len(testCase.Code) != 0 && errResponse.Code != testCase.Code {
+				t.Fatalf("%s: expecting error code %s, got %s", testCase.Function, testCase.Code, errResponse.Code)
+			} else if !strings.Contains(errResponse.Message, testCase.Error) {
+				t.Fatalf("%s: expecting error %q, got %q", testCase.Function, testCase.Error, errResponse.Message)
+			}
+		})
+	}
+}
+
+/**
+ * cases of the registry, consent and metrics functions every chaincode routes to common
+ * expects a stub with the test users registered
+ */
+func SharedCases() []Case {
+	return []Case{
+		{"registerUser", Admin, "registerUser", []string{MSPID, "newdoctor", common.RoleClinician}, "", "", nil},
+		{"registerUser wrong arity", Admin, "registerUser", []string{MSPID, "newnurse"}, "expecting 3 or 4 argument", common.CodeInvalidArgument, nil},
+		{"registerUser empty argument", Admin, "registerUser", []string{MSPID, "", common.RoleNurse}, "argument 2 must be declare", common.CodeInvalidArgument, nil},
+		{"registerUser unknown role", Admin, "registerUser", []string{MSPID, "newnurse", "surgeon"}, "role surgeon does not exist", common.CodeInvalidArgument, nil},
+		{"registerUser patient without patient id", Admin, "registerUser", []string{MSPID, "newpatient", common.RolePatient}, "patient must be registered with a patient id", common.CodeInvalidArgument, nil},
+		{"registerUser already registered", Admin, "registerUser", []string{MSPID, Clinician, common.RoleClinician}, "is already registered", common.CodeConflict, nil},
+		{"registerUser unauthorized", Clinician, "registerUser", []string{MSPID, "newnurse", common.RoleNurse}, "is not an admin", common.CodeForbidden, nil},
+		{"registerUser by admin attribute of another msp", ForeignAdmin, "registerUser", []string{ForeignMSPID, "newnurse", common.RoleNurse}, "is not an admin", common.CodeForbidden, nil},
+		{"registerUser by admin attribute once an admin is registered", LateAdmin, "registerUser", []string{MSPID, "newnurse", common.RoleNurse}, "is not an admin", common.CodeForbidden, nil},
+
+		{"assignRole", Admin, "assignRole", []string{MSPID, Clinician, common.RoleNurse}, "", "", nil},
+		{"assignRole wrong arity", Admin, "assignRole", []string{MSPID, Clinician}, "expecting 3 argument", common.CodeInvalidArgument, nil},
+		{"assignRole empty argument", Admin, "assignRole", []string{MSPID, Clinician, ""}, "argument 3 must be declare", common.CodeInvalidArgument, nil},
+		{"assignRole missing user", Admin, "assignRole", []string{MSPID, Stranger, common.RoleNurse}, "is not registered", common.CodeNotFound, nil},
+		{"assignRole unauthorized", Nurse, "assignRole", []string{MSPID, Nurse, common.RoleClinician}, "is not an admin", common.CodeForbidden, nil},
+
+		{"revokeRole", Admin, "revokeRole", []string{MSPID, Clinician, common.RoleNurse}, "", "", nil},
+		{"revokeRole wrong arity", Admin, "revokeRole", []string{MSPID}, "expecting 3 argument", common.CodeInvalidArgument, nil},
+		{"revokeRole empty argument", Admin, "revokeRole", []string{"", Clinician, common.RoleNurse}, "argument 1 must be declare", common.CodeInvalidArgument, nil},
+		{"revokeRole missing user", Admin, "revokeRole", []string{MSPID, Stranger, common.RoleNurse}, "is not registered", common.CodeNotFound, nil},
+		{"revokeRole missing role", Admin, "revokeRole", []string{MSPID, Clinician, common.RoleNurse}, "does not have role", common.CodeNotFound, nil},
+		{"revokeRole unauthorized", Patient, "revokeRole", []string{MSPID, Clinician, common.RoleClinician}, "is not an admin", common.CodeForbidden, nil},
+
+		{"grantConsent", Patient, "grantConsent", []string{UserID(Clinician), common.ResourcePatientInformation, common.PurposeQuery, Expiry}, "", "", nil},
+		{"grantConsent wrong arity", Patient, "grantConsent", []string{UserID(Clinician), common.ResourcePatientInformation}, "expecting 4 argument", common.CodeInvalidArgument, nil},
+		{"grantConsent empty argument", Patient, "grantConsent", []string{"", common.ResourcePatientInformation, common.PurposeQuery, Expiry}, "argument 1 must be declare", common.CodeInvalidArgument, nil},
+		{"grantConsent unknown resource", Patient, "grantConsent", []string{UserID(Clinician), "Surgery", common.PurposeQuery, Expiry}, "resource Surgery does not exist", common.CodeInvalidArgument, nil},
+		{"grantConsent expired", Patient, "grantConsent", []string{UserID(Clinician), common.ResourcePatientInformation, common.PurposeQuery, "2000-01-01T00:00:00Z"}, "expiry must be in the future", common.CodeInvalidArgument, nil},
+		{"grantConsent unauthorized", Clinician, "grantConsent", []string{UserID(Nurse), common.ResourcePatientInformation, common.PurposeQuery, Expiry}, "is not a patient", common.CodeForbidden, nil},
+
+		{"revokeConsent wrong arity", Patient, "revokeConsent", []string{}, "expecting 1 argument", common.CodeInvalidArgument, nil},
+		{"revokeConsent empty argument", Patient, "revokeConsent", []string{""}, "argument 1 must be declare", common.CodeInvalidArgument, nil},
+		{"revokeConsent missing consent", Patient, "revokeConsent", []string{"unknown"}, "consent unknown does not exist", common.CodeNotFound, nil},
+		{"revokeConsent unauthorized", Clinician, "revokeConsent", []string{"unknown"}, "is not a patient", common.CodeForbidden, nil},
+
+		{"listConsents", Patient, "listConsents", []string{}, "", "", nil},
+		{"listConsents of patient by admin", Admin, "listConsents", []string{PatientID}, "", "", nil},
+		{"listConsents wrong arity", Patient, "listConsents", []string{PatientID, PatientID}, "expecting at most 1 argument", common.CodeInvalidArgument, nil},
+		{"listConsents unauthorized", Clinician, "listConsents", []string{PatientID}, "is not an admin", common.CodeForbidden, nil},
+
+		{"getMetrics", Stranger, "getMetrics", []string{}, "", "", nil},
+		{"getMetrics wrong arity", Stranger, "getMetrics", []string{"all"}, "expecting 0 argument", common.CodeInvalidArgument, nil},
+
+		{"unknown function", Admin, "deleteEverything", []string{}, "Received unknown function invocation", common.CodeInvalidArgument, nil},
+	}
+}
@@ -0,0 +1,358 @@
+// Package mockstub extends shim.MockStub with the private data and identity
+// support the heathcare chaincodes need to be tested without a peer.
+package mockstub
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// object identifier of the attributes extension written by the fabric CA
+var attributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+/**
+ * MockStub is a shim.MockStub that implements the private data queries,
+ * DelPrivateData and GetTransient, which the fabric mock does not support
+ * reads and writes of a collection in Errors fail with its error, to test storage failures
+ * transactions are timestamped with TxTime, or the current time when it is zero
+ * Event is the chaincode event of the last transaction, nil when it did not set one
+ * the writes of a failed transaction are discarded, as the peer does not commit them
+ * each transaction is committed in its own block, the private data of a collection
+ * in BlockToLive is purged once it was not written for that number of blocks
+ */
+type MockStub struct {
+	*shim.MockStub
+	cc          shim.Chaincode
+	args        [][]byte
+	txCount     int
+	height      uint64
+	written     map[string]map[string]uint64
+	pending     map[string]map[string]uint64
+	Transient   map[string][]byte
+	Errors      map[string]error
+	TxTime      time.Time
+	Event       *pb.ChaincodeEvent
+	BlockToLive map[string]uint64
+}
+
+func NewMockStub(name string, cc shim.Chaincode) *MockStub {
+	return &MockStub{MockStub: shim.NewMockStub(name, cc), cc: cc, Errors: map[string]error{}, written: map[string]map[string]uint64{}, BlockToLive: map[string]uint64{}}
+}
+
+//read the blockToLive of the collections of a collection config, like the peer does when the chaincode is instantiated
+func (stub *MockStub) LoadCollectionConfig(path string) error {
+	configAsByte, errRead := ioutil.ReadFile(path)
+	if errRead != nil {
+		return errRead
+	}
+	collections := []struct {
+		Name        string `json:"name"`
+		BlockToLive uint64 `json:"blockToLive"`
+	}{}
+	errConfig := json.Unmarshal(configAsByte, &collections)
+	if errConfig != nil {
+		return errConfig
+	}
+	for _, collection := range collections {
+		stub.BlockToLive[collection.Name] = collection.BlockToLive
+	}
+	return nil
+}
+
+//commit empty blocks, the private data that expires meanwhile is purged
+func (stub *MockStub) AdvanceBlocks(blocks uint64) {
+	stub.height += blocks
+	stub.purge()
+}
+
+//delete the private data written more than blockToLive blocks ago, 0 keeps it forever
+func (stub *MockStub) purge() {
+	for collection, keys := range stub.written {
+		blockToLive := stub.BlockToLive[collection]
+		if blockToLive == 0 {
+			continue
+		}
+		for key, block := range keys {
+			if stub.height > block+blockToLive {
+				delete(stub.PvtState[collection], key)
+				delete(keys, key)
+			}
+		}
+	}
+}
+
+//remember the block a key of a collection was last written in, to purge it
+func (stub *MockStub) commit(written map[string]map[string]uint64) {
+	for collection, keys := range written {
+		if stub.written[collection] == nil {
+			stub.written[collection] = map[string]uint64{}
+		}
+		for key := range keys {
+			stub.written[collection][key] = stub.height
+		}
+	}
+}
+
+/**
+ * invoke a function of the chaincode in a new transaction
+ * the transient map is cleared after the transaction
+ */
+func (stub *MockStub) Invoke(function string, args ...string) pb.Response {
+	stub.args = [][]byte{[]byte(function)}
+	for _, arg := range args {
+		stub.args = append(stub.args, []byte(arg))
+	}
+
+	stub.Event = nil
+	stub.txCount++
+	stub.height++
+	stub.purge()
+	stub.pending = map[string]map[string]uint64{}
+	txid := "tx" + strconv.Itoa(stub.txCount)
+	stub.MockTransactionStart(txid)
+	if !stub.TxTime.IsZero() {
+		stub.TxTimestamp = &timestamp.Timestamp{Seconds: stub.TxTime.Unix(), Nanos: int32(stub.TxTime.Nanosecond())}
+	}
+	state, pvtState := stub.snapshot()
+	response := stub.cc.Invoke(stub)
+	//the peer does not commit the writes of a failed transaction
+	if response.Status >= shim.ERRORTHRESHOLD {
+		stub.restore(state, pvtState)
+	} else {
+		stub.commit(stub.pending)
+	}
+	stub.pending = nil
+	stub.MockTransactionEnd(txid)
+	stub.Transient = nil
+	return response
+}
+
+//copy of world state and private data, to restore them when a transaction fails
+func (stub *MockStub) snapshot() (map[string][]byte, map[string]map[string][]byte) {
+	state := make(map[string][]byte, len(stub.State))
+	for key, value := range stub.State {
+		state[key] = value
+	}
+	pvtState := make(map[string]map[string][]byte, len(stub.PvtState))
+	for collection, values := range stub.PvtState {
+		pvtState[collection] = make(map[string][]byte, len(values))
+		for key, value := range values {
+			pvtState[collection][key] = value
+		}
+	}
+	return state, pvtState
+}
+
+//restore world state and private data, within the transaction so the keys of range queries are restored too
+func (stub *MockStub) restore(state map[string][]byte, pvtState map[string]map[string][]byte) {
+	for key := range stub.State {
+		if _, found := state[key]; !found {
+			stub.MockStub.DelState(key)
+		}
+	}
+	for key, value := range state {
+		stub.MockStub.PutState(key, value)
+	}
+	stub.PvtState = pvtState
+}
+
+//set creator of the next transactions to a certificate issued by mspid for enrollmentID
+func (stub *MockStub) SetCreator(mspid string, enrollmentID string, attrs map[string]string) error {
+	creator, errCreator := NewCreator(mspid, enrollmentID, attrs)
+	if errCreator != nil {
+		return errCreator
+	}
+	stub.Creator = creator
+	return nil
+}
+
+func (stub *MockStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *MockStub) GetStringArgs() []string {
+	args := make([]string, 0, len(stub.args))
+	for _, arg := range stub.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (stub *MockStub) GetFunctionAndParameters() (string, []string) {
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (stub *MockStub) GetTransient() (map[string][]byte, error) {
+	return stub.Transient, nil
+}
+
+func (stub *MockStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	if stub.Errors[collection] != nil {
+		return nil, stub.Errors[collection]
+	}
+	return stub.MockStub.GetPrivateData(collection, key)
+}
+
+func (stub *MockStub) PutPrivateData(collection string, key string, value []byte) error {
+	if stub.Errors[collection] != nil {
+		return stub.Errors[collection]
+	}
+	errPut := stub.MockStub.PutPrivateData(collection, key, value)
+	if errPut == nil && stub.pending != nil {
+		if stub.pending[collection] == nil {
+			stub.pending[collection] = map[string]uint64{}
+		}
+		stub.pending[collection][key] = stub.height
+	}
+	return errPut
+}
+
+//write a value to a collection outside of a transaction, to set up records the chaincode would not write
+func (stub *MockStub) SetPrivateData(collection string, key string, value []byte) {
+	if stub.PvtState[collection] == nil {
+		stub.PvtState[collection] = map[string][]byte{}
+	}
+	stub.PvtState[collection][key] = value
+	stub.commit(map[string]map[string]uint64{collection: {key: stub.height}})
+}
+
+func (stub *MockStub) DelPrivateData(collection string, key string) error {
+	if stub.Errors[collection] != nil {
+		return stub.Errors[collection]
+	} else if len(stub.TxID) == 0 {
+		return errors.New("cannot DelPrivateData without a transaction")
+	}
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+//keep the event of the transaction, the fabric mock sends it to a channel nobody reads
+func (stub *MockStub) SetEvent(name string, payload []byte) error {
+	stub.Event = &pb.ChaincodeEvent{EventName: name, Payload: payload}
+	return nil
+}
+
+/**
+ * keys of the collection in [startKey, endKey), an empty endKey has no upper bound
+ * an empty startKey starts after the composite keys, like the range queries of the peer
+ */
+func (stub *MockStub) GetPrivateDataByRange(collection string, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if stub.Errors[collection] != nil {
+		return nil, stub.Errors[collection]
+	} else if len(startKey) == 0 {
+		startKey = "\x01"
+	}
+
+	keys := []string{}
+	for key := range stub.PvtState[collection] {
+		if key >= startKey && (len(endKey) == 0 || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	return newIterator(stub.PvtState[collection], keys), nil
+}
+
+func (stub *MockStub) GetPrivateDataByPartialCompositeKey(collection string, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	if stub.Errors[collection] != nil {
+		return nil, stub.Errors[collection]
+	}
+	prefix, errPrefix := stub.CreateCompositeKey(objectType, attributes)
+	if errPrefix != nil {
+		return nil, errPrefix
+	}
+
+	keys := []string{}
+	for key := range stub.PvtState[collection] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return newIterator(stub.PvtState[collection], keys), nil
+}
+
+// Iterator over a snapshot of keys, in key order like the ledger
+type Iterator struct {
+	state map[string][]byte
+	keys  []string
+	index int
+}
+
+func newIterator(state map[string][]byte, keys []string) *Iterator {
+	sort.Strings(keys)
+	return &Iterator{state: state, keys: keys}
+}
+
+func (iterator *Iterator) HasNext() bool {
+	return iterator.index < len(iterator.keys)
+}
+
+func (iterator *Iterator) Next() (*queryresult.KV, error) {
+	if !iterator.HasNext() {
+		return nil, errors.New("iterator has no more elements")
+	}
+	key := iterator.keys[iterator.index]
+	iterator.index++
+	return &queryresult.KV{Key: key, Value: iterator.state[key]}, nil
+}
+
+func (iterator *Iterator) Close() error {
+	return nil
+}
+
+/**
+ * build a serialized identity like the one a peer puts in the proposal
+ * the certificate is self-signed, enrollmentID is the common name and
+ * attrs are written to the attributes extension of the fabric CA
+ */
+func NewCreator(mspid string, enrollmentID string, attrs map[string]string) ([]byte, error) {
+	key, errKey := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if errKey != nil {
+		return nil, errKey
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: enrollmentID, Organization: []string{mspid}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if len(attrs) != 0 {
+		attrsAsByte, errAttrs := json.Marshal(map[string]map[string]string{"attrs": attrs})
+		if errAttrs != nil {
+			return nil, errAttrs
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attributesOID, Value: attrsAsByte}}
+	}
+
+	cert, errCert := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if errCert != nil {
+		return nil, errCert
+	}
+
+	certAsPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	return proto.Marshal(&msp.SerializedIdentity{Mspid: mspid, IdBytes: certAsPEM})
+}
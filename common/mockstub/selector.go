@@ -0,0 +1,178 @@
+package mockstub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/**
+ * run a couchdb query on a collection, the selector, sort and limit of the query are evaluated
+ * the selector supports the operators common.SearchRecords allows, _id is the key of the record
+ * records are sorted in ascending order of the sort fields then of id, like an index of couchdb
+ * values that are not json objects, like the values of composite keys, never match
+ */
+func (stub *MockStub) GetPrivateDataQueryResult(collection string, query string) (shim.StateQueryIteratorInterface, error) {
+	if stub.Errors[collection] != nil {
+		return nil, stub.Errors[collection]
+	}
+
+	request := &struct {
+		Selector map[string]interface{} `json:"selector"`
+		Sort     []map[string]string    `json:"sort"`
+		Limit    int                    `json:"limit"`
+	}{}
+	errRequest := json.Unmarshal([]byte(query), request)
+	if errRequest != nil {
+		return nil, errRequest
+	} else if request.Selector == nil {
+		return nil, errors.New("query must have a selector")
+	}
+
+	keys := []string{}
+	documents := map[string]map[string]interface{}{}
+	for key, value := range stub.PvtState[collection] {
+		document := map[string]interface{}{}
+		if json.Unmarshal(value, &document) != nil {
+			continue
+		}
+		document["_id"] = key
+
+		matched, errMatch := matchSelector(request.Selector, document)
+		if errMatch != nil {
+			return nil, errMatch
+		} else if matched {
+			keys = append(keys, key)
+			documents[key] = document
+		}
+	}
+
+	sort.Slice(keys, func(i int, j int) bool {
+		for _, sortField := range request.Sort {
+			for field := range sortField {
+				compared := compare(documents[keys[i]][field], documents[keys[j]][field])
+				if compared != 0 {
+					return compared < 0
+				}
+			}
+		}
+		return keys[i] < keys[j]
+	})
+	if request.Limit > 0 && len(keys) > request.Limit {
+		keys = keys[:request.Limit]
+	}
+	return &Iterator{state: stub.PvtState[collection], keys: keys}, nil
+}
+
+func matchSelector(selector map[string]interface{}, document map[string]interface{}) (bool, error) {
+	for field, condition := range selector {
+		matched, errMatch := matchField(field, condition, document)
+		if errMatch != nil || !matched {
+			return false, errMatch
+		}
+	}
+	return true, nil
+}
+
+func matchField(field string, condition interface{}, document map[string]interface{}) (bool, error) {
+	switch field {
+	case "$and", "$or", "$nor":
+		selectors, _ := condition.([]interface{})
+		matches := 0
+		for _, item := range selectors {
+			selector, _ := item.(map[string]interface{})
+			matched, errMatch := matchSelector(selector, document)
+			if errMatch != nil {
+				return false, errMatch
+			} else if matched {
+				matches++
+			}
+		}
+		switch field {
+		case "$and":
+			return matches == len(selectors), nil
+		case "$or":
+			return matches > 0, nil
+		default:
+			return matches == 0, nil
+		}
+	case "$not":
+		selector, _ := condition.(map[string]interface{})
+		matched, errMatch := matchSelector(selector, document)
+		return !matched, errMatch
+	}
+
+	value, found := document[field]
+	operators, isOperators := condition.(map[string]interface{})
+	if !isOperators {
+		operators = map[string]interface{}{"$eq": condition}
+	}
+	for operator, operand := range operators {
+		matched, errMatch := matchOperator(operator, operand, value, found)
+		if errMatch != nil || !matched {
+			return false, errMatch
+		}
+	}
+	return true, nil
+}
+
+func matchOperator(operator string, operand interface{}, value interface{}, found bool) (bool, error) {
+	switch operator {
+	case "$exists":
+		return found == operand, nil
+	case "$in", "$nin":
+		operands, _ := operand.([]interface{})
+		in := false
+		for _, item := range operands {
+			in = in || (found && item == value)
+		}
+		return in == (operator == "$in"), nil
+	case "$ne":
+		return !found || value != operand, nil
+	}
+
+	if !found {
+		return false, nil
+	}
+	compared := compare(value, operand)
+	switch operator {
+	case "$eq":
+		return value == operand, nil
+	case "$gt":
+		return compared > 0, nil
+	case "$gte":
+		return compared >= 0, nil
+	case "$lt":
+		return compared < 0, nil
+	case "$lte":
+		return compared <= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %s", operator)
+}
+
+//compare strings and numbers, values of different types compare by their json text
+func compare(value interface{}, operand interface{}) int {
+	if number, isNumber := value.(float64); isNumber {
+		if operandNumber, isOperandNumber := operand.(float64); isOperandNumber {
+			switch {
+			case number < operandNumber:
+				return -1
+			case number > operandNumber:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	valueText, operandText := fmt.Sprint(value), fmt.Sprint(operand)
+	switch {
+	case valueText < operandText:
+		return -1
+	case valueText > operandText:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+// Package common holds the domain model, collection names and handler logic
+// shared by every heathcare chaincode, so a fix lands once.
+package common
+
+// private data collections, see collection.json
+const (
+	PatientInformationCollection = "PatientInformationCollection"
+	MedicalRecordCollection      = "MedicalRecordCollection"
+	DrugInformationCollection    = "DrugInformationCollection"
+	HospitalFeesCollection       = "HospitalFeesCollection"
+	QueryCollection              = "queryCollection"
+	ModifyCollection             = "modifyCollection"
+	RegistryCollection           = "registryCollection"
+	ConsentCollection            = "consentCollection"
+	PrescriptionCollection       = "prescriptionCollection"
+	AllergyCollection            = "allergyCollection"
+)
+
+// object types stored in the docType field of every record
+const (
+	ObjectTypePatientInformation = "PatientInformation"
+	ObjectTypeMedicalRecord      = "MedicalRecord"
+	ObjectTypeDrugInformation    = "DrugInformation"
+	ObjectTypeHospitalFees       = "HospitalFees"
+	ObjectTypeQuery              = "Query"
+	ObjectTypeUser               = "User"
+	ObjectTypeConsent            = "Consent"
+	ObjectTypeRevision           = "Revision"
+	ObjectTypeAnchor             = "Anchor"
+	ObjectTypePrescription       = "Prescription"
+	ObjectTypeInteraction        = "Interaction"
+	ObjectTypeContraindication   = "Contraindication"
+	ObjectTypeAllergy            = "Allergy"
+	ObjectTypeCatalogItem        = "CatalogItem"
+	ObjectTypeLot                = "Lot"
+	ObjectTypeStockMovement      = "StockMovement"
+)
+
+type PatientInformation struct {
+	Versioned
+	ObjectType                   string `json:"docType"`
+	ID                           string `json:"photo_id"`
+	InsuranceCard                string `json:"insurance_card"`
+	CurrentMedicationInformation string `json:"current_medication_information"`
+	RelatedMedicalRecords        string `json:"related_medical_records"`
+	MakeNoteOfAppointmentDate    string `json:"make_note_of_appointment_date"`
+}
+
+type MedicalRecord struct {
+	Versioned
+	ObjectType                        string `json:"docType"`
+	ID                                string `json:"id"`
+	PersonalIdentificationInformation string `json:"personal_identification"`
+	MedicalHistory                    string `json:"medical_history"`
+	FamilyMedicalHistory              string `json:"family_medical_history"`
+	MedicationHistory                 string `json:"medication_history"`
+	TreatmentHistory                  string `json:"treatment_history"`
+	MedicalDirectives                 string `json:"medical_directives"`
+}
+
+type DrugInformation struct {
+	Versioned
+	ObjectType     string   `json:"docType"`
+	ID             string   `json:"id"`
+	PatientName    string   `json:"patient_name"`
+	DrugName       string   `json:"drug_name"`
+	ExpirationDate string   `json:"expiration_date"`
+	Quantity       Quantity `json:"quantity"`
+	PrescribedBy   string   `json:"prescribed_by"`
+}
+
+type HospitalFees struct {
+	Versioned
+	ObjectType               string `json:"docType"`
+	ID                       string `json:"id"`
+	PatientName              string `json:"patient_name"`
+	Account                  string `json:"account"`
+	DateOfService            string `json:"date_of_service"`
+	PatientService           string `json:"patient_service"`
+	PrimaryInsuranceBilled   string `json:"primary_insurance_billed"`
+	SecondaryInsuranceBilled string `json:"secondary_insurance_billed"`
+	Pharmacy                 string `json:"pharmacy"`
+	Room                     string `json:"room"`
+	AmountDue                Money  `json:"amount_due"`
+}
+
+// Query is an entry of the access log, a patch lists the fields it changed and an override the conflicts it accepted
+type Query struct {
+	ObjectType string          `json:"docType"`
+	UserID     string          `json:"userid"`
+	MSPID      string          `json:"mspid"`
+	Role       string          `json:"role"`
+	PatientID  string          `json:"patientid"`
+	Location   string          `json:"location"`
+	Time       string          `json:"time"`
+	Purpose    string          `json:"purpose"`
+	TxID       string          `json:"txid"`
+	Fields     []string        `json:"fields,omitempty"`
+	Override   *SafetyOverride `json:"override,omitempty"`
+}
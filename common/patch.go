@@ -0,0 +1,235 @@
+package common
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/**
+ * get the json merge patch (RFC 7396) of a patch function from its transient map
+ * a field set to a string, or an object for TypedFields, changes the field, a field set to null clears it,
+ * the routing fields and fields missing from the schema cannot be patched
+ */
+func (schema *Schema) DecodePatch(stub shim.ChaincodeStubInterface) (map[string]interface{}, error) {
+	transient, errTransient := stub.GetTransient()
+	if errTransient != nil {
+		return nil, NewError(CodeInvalidArgument, "cannot get transient map: "+errTransient.Error())
+	}
+	patchAsByte, found := transient[TransientDocumentKey]
+	if !found || len(patchAsByte) == 0 {
+		return nil, NewFieldError(CodeInvalidArgument, TransientDocumentKey, "document must be passed in the transient map")
+	}
+
+	patch := map[string]interface{}{}
+	errPatch := json.Unmarshal(patchAsByte, &patch)
+	if errPatch != nil {
+		return nil, NewFieldError(CodeInvalidArgument, TransientDocumentKey, "invalid json document: "+errPatch.Error())
+	} else if len(patch) == 0 {
+		return nil, NewFieldError(CodeInvalidArgument, TransientDocumentKey, "patch must change at least one field")
+	}
+
+	for field, value := range patch {
+		if !schema.HasField(field) {
+			return nil, NewFieldError(CodeInvalidArgument, field, "unknown field "+field)
+		} else if schema.IsRouting(field) {
+			return nil, NewFieldError(CodeInvalidArgument, field, field+" cannot be patched")
+		}
+		if _, isObject := value.(map[string]interface{}); isObject && TypedFields[field] {
+			continue
+		} else if _, isString := value.(string); !isString && value != nil {
+			return nil, NewFieldError(CodeInvalidArgument, field, field+" must be a string or null")
+		}
+	}
+	return patch, nil
+}
+
+//names of the fields a patch changes, sorted
+func PatchFields(patch map[string]interface{}) []string {
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+/**
+ * apply a json merge patch to a record, fields missing from the patch are kept
+ * a field set to null is cleared to an empty string, which typed fields decode to their zero value,
+ * and an object is merged into the object of the field, so the members it does not name are kept
+ * @param: record, pointer to the struct of the record
+ */
+func ApplyPatch(record interface{}, patch map[string]interface{}) error {
+	recordAsByte, errRecordAsByte := json.Marshal(record)
+	if errRecordAsByte != nil {
+		return errRecordAsByte
+	}
+	fields := map[string]interface{}{}
+	errFields := json.Unmarshal(recordAsByte, &fields)
+	if errFields != nil {
+		return errFields
+	}
+
+	for field, value := range patch {
+		if value == nil {
+			value = ""
+		}
+		fields[field] = mergePatch(fields[field], value)
+	}
+
+	recordAsByte, errRecordAsByte = json.Marshal(fields)
+	if errRecordAsByte != nil {
+		return errRecordAsByte
+	}
+	return json.Unmarshal(recordAsByte, record)
+}
+
+//merge a patch into a json value as RFC 7396 does, a member of an object set to null is removed
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, isObject := patch.(map[string]interface{})
+	if !isObject {
+		return patch
+	}
+	targetObject, isTargetObject := target.(map[string]interface{})
+	if !isTargetObject {
+		targetObject = map[string]interface{}{}
+	}
+	for member, value := range patchObject {
+		if value == nil {
+			delete(targetObject, member)
+			continue
+		}
+		targetObject[member] = mergePatch(targetObject[member], value)
+	}
+	return targetObject
+}
+
+/**
+ * patch a record of a patient, only the fields of the patch are changed
+ * the entry of the modify log lists the patched fields
+ * @param: id, location, version, as positional arguments or a json object
+ * transient document: json merge patch of the fields of schema but id, location and version
+ * transient salt: salt of the hash anchor, for records of AnchorCollections
+ */
+func PatchRecord(stub shim.ChaincodeStubInterface, args []string, schema *Schema, resource string, collection string, objectType string, record VersionedRecord) pb.Response {
+	routing, errArgs := schema.DecodeRouting(args)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+	for _, field := range schema.Routing {
+		if len(routing[field]) == 0 {
+			return ErrorResponse(NewFieldError(CodeInvalidArgument, field, field+" must be declare"))
+		}
+	}
+	patientid := routing[schema.Routing[0]]
+	location := routing[schema.Routing[1]]
+	expectedVersion := routing[schema.Routing[2]]
+
+	patch, errPatch := schema.DecodePatch(stub)
+	if errPatch != nil {
+		return ErrorResponse(errPatch)
+	}
+
+	//check permission and consent, then append the access and patched fields to the log
+	identity, errAccess := CheckPatchAccess(stub, resource, patientid, location, PatchFields(patch))
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	//get data and check it is a valid record
+	errRecord := GetRecord(stub, collection, patientid, objectType, record)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	//check the record was not modified since the invoker read it
+	errRecord = record.CheckVersion(collection, patientid, expectedVersion)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	//keep the stored version of a record written before revisions were added
+	if RevisionCollections[collection] {
+		errRecord = PutStoredRevision(stub, collection, patientid, record)
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+	}
+
+	//check a new drug against the medications and allergies of the patient
+	if drug, isDrug := record.(*DrugInformation); isDrug {
+		if drugName, isDrugName := patch["drug_name"].(string); isDrugName && NormalizeDrug(drugName) != NormalizeDrug(drug.DrugName) {
+			_, errRecord = CheckDrugSafety(stub, identity, patientid, location, PatchFields(patch), drugName, false)
+			if errRecord != nil {
+				return ErrorResponse(errRecord)
+			}
+		}
+	}
+
+	errRecord = ApplyPatch(record, patch)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+	if validator, isValidator := record.(Validator); isValidator {
+		errRecord = validator.Validate()
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+	}
+	record.NextVersion()
+
+	//update the secondary indexes, before the record replaces the stored one
+	errRecord = PutIndexes(stub, collection, patientid, record)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	//store new data
+	errRecord = PutRecord(stub, collection, patientid, record)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	//keep every revision of a record of a collection keeping revisions
+	if RevisionCollections[collection] {
+		errRecord = PutRevision(stub, collection, patientid, identity.ID, record)
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+	}
+
+	//anchor the hash of a record of an anchored collection to world state
+	if AnchorCollections[collection] {
+		errRecord = PutAnchor(stub, collection, record)
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+	}
+
+	//notify listeners, the event carries no health information
+	errRecord = SetRecordEvent(stub, EventRecordModified, objectType, patientid, identity.ID)
+	if errRecord != nil {
+		return ErrorResponse(errRecord)
+	}
+
+	return shim.Success(nil)
+}
+
+func PatchPatientInformation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return PatchRecord(stub, args, PatientInformationUpdateSchema, ResourcePatientInformation, PatientInformationCollection, ObjectTypePatientInformation, &PatientInformation{})
+}
+
+func PatchMedicalRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return PatchRecord(stub, args, MedicalRecordUpdateSchema, ResourceMedicalRecord, MedicalRecordCollection, ObjectTypeMedicalRecord, &MedicalRecord{})
+}
+
+func PatchDrugInformation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return PatchRecord(stub, args, DrugInformationUpdateSchema, ResourceDrugInformation, DrugInformationCollection, ObjectTypeDrugInformation, &DrugInformation{})
+}
+
+func PatchHospitalFees(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return PatchRecord(stub, args, HospitalFeesUpdateSchema, ResourceHospitalFees, HospitalFeesCollection, ObjectTypeHospitalFees, &HospitalFees{})
+}
@@ -0,0 +1,86 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+	"github.com/xuansonha17031991/heathcare-chaincode/common/mockstub"
+)
+
+func TestApplyPatch(t *testing.T) {
+	stub := mockstub.NewMockStub("common", nil)
+	stub.Transient = mockstub.Document(`{"make_note_of_appointment_date":"2030-02-01","related_medical_records":null}`)
+
+	patch, errPatch := common.PatientInformationUpdateSchema.DecodePatch(stub)
+	if errPatch != nil {
+		t.Fatal(errPatch)
+	}
+	fields := common.PatchFields(patch)
+	if len(fields) != 2 || fields[0] != "make_note_of_appointment_date" || fields[1] != "related_medical_records" {
+		t.Fatalf("expecting sorted patched fields, got %v", fields)
+	}
+
+	patient := &common.PatientInformation{ObjectType: common.ObjectTypePatientInformation, ID: "P1", InsuranceCard: "INS-001", RelatedMedicalRecords: "MR-001", MakeNoteOfAppointmentDate: "2030-01-01"}
+	errPatch = common.ApplyPatch(patient, patch)
+	if errPatch != nil {
+		t.Fatal(errPatch)
+	}
+	expected := common.PatientInformation{ObjectType: common.ObjectTypePatientInformation, ID: "P1", InsuranceCard: "INS-001", MakeNoteOfAppointmentDate: "2030-02-01"}
+	if *patient != expected {
+		t.Fatalf("expecting %+v, got %+v", expected, patient)
+	}
+
+	cases := []struct {
+		document string
+		field    string
+	}{
+		{`{"insurance_card":7}`, "insurance_card"},
+		{`{"photo_id":"P2"}`, "photo_id"},
+		{`{"location":"ward"}`, "location"},
+		{`{"docType":"MedicalRecord"}`, "docType"},
+		{`{}`, common.TransientDocumentKey},
+		{`[]`, common.TransientDocumentKey},
+		{"", common.TransientDocumentKey},
+	}
+	for _, testCase := range cases {
+		stub.Transient = mockstub.Document(testCase.document)
+		_, errPatch = common.PatientInformationUpdateSchema.DecodePatch(stub)
+		errResponse, isError := errPatch.(*common.Error)
+		if !isError || errResponse.Code != common.CodeInvalidArgument || errResponse.Field != testCase.field {
+			t.Fatalf("%s: expecting invalid argument %q, got %v", testCase.document, testCase.field, errPatch)
+		}
+	}
+}
+
+func TestApplyNestedPatch(t *testing.T) {
+	stub := mockstub.NewMockStub("common", nil)
+	stub.Transient = mockstub.Document(`{"quantity":{"unit":"box"}}`)
+
+	patch, errPatch := common.DrugInformationUpdateSchema.DecodePatch(stub)
+	if errPatch != nil {
+		t.Fatal(errPatch)
+	}
+
+	//the members of the object missing from the patch are kept
+	drug := &common.DrugInformation{ObjectType: common.ObjectTypeDrugInformation, ID: "P1", DrugName: "aspirin", Quantity: common.Quantity{Value: 10, Unit: "tablet"}}
+	errPatch = common.ApplyPatch(drug, patch)
+	if errPatch != nil {
+		t.Fatal(errPatch)
+	} else if drug.Quantity != (common.Quantity{Value: 10, Unit: "box"}) || drug.DrugName != "aspirin" {
+		t.Fatalf("expecting 10 box of aspirin, got %+v", drug)
+	}
+
+	//a member set to null is removed, so it decodes to its zero value
+	stub.Transient = mockstub.Document(`{"quantity":{"value":5,"text":null}}`)
+	patch, errPatch = common.DrugInformationUpdateSchema.DecodePatch(stub)
+	if errPatch != nil {
+		t.Fatal(errPatch)
+	}
+	drug.Quantity = common.Quantity{Text: "a few"}
+	errPatch = common.ApplyPatch(drug, patch)
+	if errPatch != nil {
+		t.Fatal(errPatch)
+	} else if drug.Quantity != (common.Quantity{Value: 5}) {
+		t.Fatalf("expecting value 5 without text, got %+v", drug.Quantity)
+	}
+}
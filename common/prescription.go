@@ -0,0 +1,510 @@
+package common
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// msp of the pharmacies, only its pharmacists dispense and refill prescriptions
+const PharmacyMSPID = "Org4MSP"
+
+/**
+ * states of a prescription
+ * an active prescription is dispensed a fill at a time, a fill may take several partial dispenses;
+ * it is completed when the last fill allowed is fully dispensed, and completed or cancelled is final
+ */
+const (
+	PrescriptionActive    = "active"
+	PrescriptionCompleted = "completed"
+	PrescriptionCancelled = "cancelled"
+)
+
+// Dispense of a quantity of a prescription by a pharmacist, taken from one or more lots of the inventory
+type Dispense struct {
+	Fill       int64           `json:"fill"`
+	Quantity   int64           `json:"quantity"`
+	Pharmacist string          `json:"pharmacist"`
+	Location   string          `json:"location"`
+	Time       string          `json:"time"`
+	TxID       string          `json:"txid"`
+	Lots       []*DispensedLot `json:"lots"`
+}
+
+// DispensedLot is the quantity of a dispense taken from a lot
+type DispensedLot struct {
+	ItemID    string `json:"item_id"`
+	LotNumber string `json:"lot_number"`
+	Quantity  int64  `json:"quantity"`
+}
+
+/**
+ * Prescription of a drug to a patient, saved under prescription~patientid~id in PrescriptionCollection,
+ * which is never purged so the dispense history is kept
+ * Quantity is the quantity of a fill, Dispensed the quantity dispensed of the current fill
+ */
+type Prescription struct {
+	Versioned
+	ObjectType       string      `json:"docType"`
+	ID               string      `json:"id"`
+	PatientID        string      `json:"patientid"`
+	Prescriber       string      `json:"prescriber"`
+	DrugName         string      `json:"drug_name"`
+	Dosage           string      `json:"dosage"`
+	Frequency        string      `json:"frequency"`
+	Quantity         int64       `json:"quantity"`
+	RefillsAllowed   int64       `json:"refills_allowed"`
+	RefillsRemaining int64       `json:"refills_remaining"`
+	Fill             int64       `json:"fill"`
+	Dispensed        int64       `json:"dispensed"`
+	Status           string      `json:"status"`
+	Time             string      `json:"time"`
+	Dispenses        []*Dispense `json:"dispenses"`
+	//conflicts the prescriber accepted, nil when the drug had none
+	Override *SafetyOverride `json:"override,omitempty"`
+}
+
+// PrescriptionDocument is the json document of prescribe, passed in the transient map
+type PrescriptionDocument struct {
+	ID        string `json:"id"`
+	Location  string `json:"location"`
+	DrugName  string `json:"drug_name"`
+	Dosage    string `json:"dosage"`
+	Frequency string `json:"frequency"`
+	Quantity  string `json:"quantity"`
+	Refills   string `json:"refills"`
+}
+
+// schema of prescribe, the patient id and location route the call
+var PrescriptionSchema = &Schema{
+	Fields:   []string{"id", "location", "drug_name", "dosage", "frequency", "quantity", "refills"},
+	Required: []string{"id", "location", "drug_name", "dosage", "frequency", "quantity", "refills"},
+	Routing:  []string{"id", "location"},
+}
+
+// DispenseDocument is the json document of dispensePrescription, the patient and prescription are passed in the transient map
+type DispenseDocument struct {
+	Location       string `json:"location"`
+	Quantity       string `json:"quantity"`
+	ID             string `json:"id"`
+	PrescriptionID string `json:"prescription_id"`
+	ItemID         string `json:"item_id"`
+	LotNumber      string `json:"lot_number"`
+}
+
+/**
+ * schema of dispensePrescription, the location and quantity route the call
+ * a dispense writes the public stock movement of its lot, so the patient and prescription are kept out of the block
+ */
+var DispenseSchema = &Schema{
+	Fields:   []string{"location", "quantity", "id", "prescription_id", "item_id", "lot_number"},
+	Required: []string{"location", "quantity", "id", "prescription_id"},
+	Routing:  []string{"location", "quantity"},
+}
+
+func prescriptionKey(stub shim.ChaincodeStubInterface, patientid string, prescriptionid string) (string, error) {
+	return stub.CreateCompositeKey("prescription", []string{patientid, prescriptionid})
+}
+
+//parse a count of a prescription, at least min
+func parseCount(field string, value string, min int64) (int64, error) {
+	count, errCount := strconv.ParseInt(value, 10, 64)
+	if errCount != nil || count < min {
+		return 0, NewFieldError(CodeInvalidArgument, field, field+" must be a whole number of at least "+strconv.FormatInt(min, 10))
+	}
+	return count, nil
+}
+
+func GetPrescription(stub shim.ChaincodeStubInterface, patientid string, prescriptionid string) (*Prescription, string, error) {
+	key, errKey := prescriptionKey(stub, patientid, prescriptionid)
+	if errKey != nil {
+		return nil, "", errKey
+	}
+
+	prescription := &Prescription{}
+	errPrescription := GetRecord(stub, PrescriptionCollection, key, ObjectTypePrescription, prescription)
+	if _, isNotFound := errPrescription.(*NotFoundError); isNotFound {
+		return nil, "", NewError(CodeNotFound, "prescription "+prescriptionid+" of patient "+patientid+" does not exist")
+	} else if errPrescription != nil {
+		return nil, "", errPrescription
+	}
+	return prescription, key, nil
+}
+
+//check a prescription can still change, completed and cancelled prescriptions are final
+func (prescription *Prescription) CheckActive() error {
+	if prescription.Status != PrescriptionActive {
+		return NewFieldError(CodeConflict, "status", "prescription "+prescription.ID+" is "+prescription.Status)
+	}
+	return nil
+}
+
+/**
+ * record a dispense of quantity against the current fill
+ * the prescription is completed when the last fill allowed is fully dispensed
+ */
+func (prescription *Prescription) Dispense(dispense *Dispense) error {
+	errActive := prescription.CheckActive()
+	if errActive != nil {
+		return errActive
+	}
+
+	remaining := prescription.Quantity - prescription.Dispensed
+	if remaining == 0 {
+		return NewFieldError(CodeConflict, "quantity", "fill "+strconv.FormatInt(prescription.Fill, 10)+" of prescription "+prescription.ID+" is fully dispensed, it must be refilled")
+	} else if dispense.Quantity > remaining {
+		return NewFieldError(CodeInvalidArgument, "quantity", "quantity exceeds the remaining quantity "+strconv.FormatInt(remaining, 10)+" of the fill")
+	}
+
+	dispense.Fill = prescription.Fill
+	prescription.Dispensed += dispense.Quantity
+	prescription.Dispenses = append(prescription.Dispenses, dispense)
+	if prescription.Dispensed == prescription.Quantity && prescription.RefillsRemaining == 0 {
+		prescription.Status = PrescriptionCompleted
+	}
+	return nil
+}
+
+//start the next fill, the current fill must be fully dispensed and a refill must remain
+func (prescription *Prescription) Refill() error {
+	errActive := prescription.CheckActive()
+	if errActive != nil {
+		return errActive
+	}
+
+	if prescription.Dispensed < prescription.Quantity {
+		return NewFieldError(CodeConflict, "fill", "fill "+strconv.FormatInt(prescription.Fill, 10)+" of prescription "+prescription.ID+" is not fully dispensed")
+	} else if prescription.RefillsRemaining == 0 {
+		return NewFieldError(CodeConflict, "refills", "prescription "+prescription.ID+" has no refill remaining")
+	}
+
+	prescription.RefillsRemaining--
+	prescription.Fill++
+	prescription.Dispensed = 0
+	return nil
+}
+
+func (prescription *Prescription) Cancel() error {
+	errActive := prescription.CheckActive()
+	if errActive != nil {
+		return errActive
+	}
+	prescription.Status = PrescriptionCancelled
+	return nil
+}
+
+//check the invoker is a pharmacist of the pharmacies before it dispenses
+func checkPharmacy(identity *Identity) error {
+	if identity.MSPID != PharmacyMSPID {
+		return NewError(CodeForbidden, "user "+identity.ID+" is not a pharmacist of "+PharmacyMSPID)
+	}
+	return nil
+}
+
+//save a prescription and emit its event
+func putPrescription(stub shim.ChaincodeStubInterface, key string, prescription *Prescription, eventType string, actor string) error {
+	errPrescription := PutRecord(stub, PrescriptionCollection, key, prescription)
+	if errPrescription != nil {
+		return errPrescription
+	}
+
+	//notify listeners, the event carries no health information
+	return SetRecordEvent(stub, eventType, ObjectTypePrescription, prescription.ID, actor)
+}
+
+/**
+ * issue a prescription to a patient, only prescribers may
+ * @param: id, location, as positional arguments or a json object
+ * transient document: json document of PrescriptionSchema, fields
+ *   id, location, drug_name, dosage, frequency, quantity of a fill, refills allowed
+ * transient override: reason of the prescriber to prescribe a drug conflicting with the medications or allergies of the patient
+ * ouput: id of prescription
+ */
+func Prescribe(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	document := &PrescriptionDocument{}
+	errArgs := PrescriptionSchema.Decode(stub, args, document)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	quantity, errQuantity := parseCount("quantity", document.Quantity, 1)
+	if errQuantity != nil {
+		return ErrorResponse(errQuantity)
+	}
+	refills, errRefills := parseCount("refills", document.Refills, 0)
+	if errRefills != nil {
+		return ErrorResponse(errRefills)
+	}
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckActionAccess(stub, ResourceDrugInformation, ActionPrescribe, document.ID, document.Location)
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	//check the drug against the medications and allergies of the patient
+	override, errSafety := CheckDrugSafety(stub, identity, document.ID, document.Location, nil, document.DrugName, true)
+	if errSafety != nil {
+		return ErrorResponse(errSafety)
+	}
+
+	timePrescription, errTimePrescription := GetTxTimestamp(stub)
+	if errTimePrescription != nil {
+		return ErrorResponse(errTimePrescription)
+	}
+
+	prescription := &Prescription{
+		Versioned:        Versioned{Version: 1},
+		ObjectType:       ObjectTypePrescription,
+		ID:               stub.GetTxID(),
+		PatientID:        document.ID,
+		Prescriber:       identity.ID,
+		DrugName:         document.DrugName,
+		Dosage:           document.Dosage,
+		Frequency:        document.Frequency,
+		Quantity:         quantity,
+		RefillsAllowed:   refills,
+		RefillsRemaining: refills,
+		Fill:             1,
+		Status:           PrescriptionActive,
+		Time:             timePrescription,
+		Dispenses:        []*Dispense{},
+		Override:         override,
+	}
+	key, errKey := prescriptionKey(stub, prescription.PatientID, prescription.ID)
+	if errKey != nil {
+		return ErrorResponse(errKey)
+	}
+
+	errPrescription := putPrescription(stub, key, prescription, EventRecordCreated, identity.ID)
+	if errPrescription != nil {
+		return ErrorResponse(errPrescription)
+	}
+	return shim.Success([]byte(prescription.ID))
+}
+
+/**
+ * record a partial or full dispense of the current fill of a prescription, only pharmacists of PharmacyMSPID may
+ * the quantity is taken from the lots of the drug that are not expired, first expiry first out, refused when they do not hold it
+ * @param: location, quantity, as positional arguments or a json object
+ * transient document: json document of DispenseSchema, fields
+ *   id of the patient, prescription_id,
+ *   item_id and lot_number, optional, the lot to take the whole quantity from, by default the lots expiring first
+ */
+func DispensePrescription(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	document := &DispenseDocument{}
+	errArgs := DispenseSchema.Decode(stub, args, document)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	patientid := document.ID
+	prescriptionid := document.PrescriptionID
+	location := document.Location
+	itemid, lotNumber := document.ItemID, document.LotNumber
+	if (len(itemid) == 0) != (len(lotNumber) == 0) {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "lot_number", "item_id and lot_number must be declare together"))
+	}
+	quantity, errQuantity := parseCount("quantity", document.Quantity, 1)
+	if errQuantity != nil {
+		return ErrorResponse(errQuantity)
+	}
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckActionAccess(stub, ResourceDrugInformation, ActionDispense, patientid, location)
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+	errPharmacy := checkPharmacy(identity)
+	if errPharmacy != nil {
+		return ErrorResponse(errPharmacy)
+	}
+
+	prescription, key, errPrescription := GetPrescription(stub, patientid, prescriptionid)
+	if errPrescription != nil {
+		return ErrorResponse(errPrescription)
+	}
+
+	timeDispense, errTimeDispense := GetTxTimestamp(stub)
+	if errTimeDispense != nil {
+		return ErrorResponse(errTimeDispense)
+	}
+
+	dispense := &Dispense{Quantity: quantity, Pharmacist: identity.ID, Location: location, Time: timeDispense, TxID: stub.GetTxID(), Lots: []*DispensedLot{}}
+	errPrescription = prescription.Dispense(dispense)
+	if errPrescription != nil {
+		return ErrorResponse(errPrescription)
+	}
+	prescription.NextVersion()
+
+	//take the quantity from the stock of the drug, a stock movement per lot
+	lots, errLots := FindDispenseLots(stub, prescription.DrugName, itemid, lotNumber, quantity)
+	if errLots != nil {
+		return ErrorResponse(errLots)
+	}
+	remaining := quantity
+	for _, lot := range lots {
+		taken := lot.Quantity
+		if taken > remaining {
+			taken = remaining
+		}
+		_, errLot := lot.Move(stub, MovementDispense, -taken, "", identity.ID)
+		if errLot != nil {
+			return ErrorResponse(errLot)
+		}
+		dispense.Lots = append(dispense.Lots, &DispensedLot{lot.ItemID, lot.LotNumber, taken})
+		remaining -= taken
+	}
+
+	errPrescription = PutRecord(stub, PrescriptionCollection, key, prescription)
+	if errPrescription != nil {
+		return ErrorResponse(errPrescription)
+	}
+
+	//notify listeners, the event of a dispense carries no prescription id as the transaction writes a public stock movement
+	errEvent := SetRecordEvent(stub, EventRecordModified, ObjectTypePrescription, "", identity.ID)
+	if errEvent != nil {
+		return ErrorResponse(errEvent)
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * start the next fill of a prescription, decrementing the refills remaining; only pharmacists of PharmacyMSPID may
+ * @param: patientid
+ * @param: prescriptionId
+ * @param: location
+ */
+func RefillPrescription(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 3)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	patientid := args[0]
+	prescriptionid := args[1]
+	location := args[2]
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckActionAccess(stub, ResourceDrugInformation, ActionDispense, patientid, location)
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+	errPharmacy := checkPharmacy(identity)
+	if errPharmacy != nil {
+		return ErrorResponse(errPharmacy)
+	}
+
+	prescription, key, errPrescription := GetPrescription(stub, patientid, prescriptionid)
+	if errPrescription != nil {
+		return ErrorResponse(errPrescription)
+	}
+
+	errPrescription = prescription.Refill()
+	if errPrescription != nil {
+		return ErrorResponse(errPrescription)
+	}
+	prescription.NextVersion()
+
+	errPrescription = putPrescription(stub, key, prescription, EventRecordModified, identity.ID)
+	if errPrescription != nil {
+		return ErrorResponse(errPrescription)
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * cancel an active prescription, only prescribers may; cancellation is final
+ * @param: patientid
+ * @param: prescriptionId
+ * @param: location
+ */
+func CancelPrescription(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 3)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	patientid := args[0]
+	prescriptionid := args[1]
+	location := args[2]
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckActionAccess(stub, ResourceDrugInformation, ActionPrescribe, patientid, location)
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	prescription, key, errPrescription := GetPrescription(stub, patientid, prescriptionid)
+	if errPrescription != nil {
+		return ErrorResponse(errPrescription)
+	}
+
+	errPrescription = prescription.Cancel()
+	if errPrescription != nil {
+		return ErrorResponse(errPrescription)
+	}
+	prescription.NextVersion()
+
+	errPrescription = putPrescription(stub, key, prescription, EventRecordModified, identity.ID)
+	if errPrescription != nil {
+		return ErrorResponse(errPrescription)
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * get the prescriptions of a patient with their dispense history, ordered by id
+ * @param: patientid
+ * @param: location
+ */
+func ListPrescriptions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 2)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	patientid := args[0]
+	location := args[1]
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckAccess(stub, ResourceDrugInformation, PurposeQuery, patientid, location)
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	prescriptionIterator, errPrescriptionIterator := stub.GetPrivateDataByPartialCompositeKey(PrescriptionCollection, "prescription", []string{patientid})
+	if errPrescriptionIterator != nil {
+		return ErrorResponse(&StorageError{PrescriptionCollection, patientid, errPrescriptionIterator})
+	}
+	defer prescriptionIterator.Close()
+
+	prescriptions := []*Prescription{}
+	for prescriptionIterator.HasNext() {
+		prescriptionKV, errPrescriptionKV := prescriptionIterator.Next()
+		if errPrescriptionKV != nil {
+			return ErrorResponse(&StorageError{PrescriptionCollection, patientid, errPrescriptionKV})
+		}
+
+		prescription := &Prescription{}
+		errPrescription := DecodeRecord(PrescriptionCollection, prescriptionKV.Key, ObjectTypePrescription, prescriptionKV.Value, prescription)
+		if errPrescription != nil {
+			return ErrorResponse(errPrescription)
+		}
+		prescriptions = append(prescriptions, prescription)
+	}
+
+	//notify listeners, the event carries no health information
+	errEvent := SetRecordEvent(stub, EventRecordQueried, ObjectTypePrescription, patientid, identity.ID)
+	if errEvent != nil {
+		return ErrorResponse(errEvent)
+	}
+
+	prescriptionsAsByte, errPrescriptionsAsByte := json.Marshal(prescriptions)
+	if errPrescriptionsAsByte != nil {
+		return ErrorResponse(errPrescriptionsAsByte)
+	}
+	return shim.Success(prescriptionsAsByte)
+}
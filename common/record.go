@@ -0,0 +1,88 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// NotFoundError is returned when a record does not exist in its collection
+type NotFoundError struct {
+	Collection string
+	Key        string
+}
+
+func (err *NotFoundError) Error() string {
+	return "record " + err.Key + " does not exist in " + err.Collection
+}
+
+// CorruptRecordError is returned when a stored record is not a valid document of its type
+type CorruptRecordError struct {
+	Collection string
+	Key        string
+	Err        error
+}
+
+func (err *CorruptRecordError) Error() string {
+	return "record " + err.Key + " of " + err.Collection + " is corrupt: " + err.Err.Error()
+}
+
+// StorageError is returned when the ledger cannot be read or written
+type StorageError struct {
+	Collection string
+	Key        string
+	Err        error
+}
+
+func (err *StorageError) Error() string {
+	return "cannot access record " + err.Key + " of " + err.Collection + ": " + err.Err.Error()
+}
+
+/**
+ * get a record from a private data collection and check it is a document of objectType
+ * records written before the docType tag was unified are read too, json keys are case insensitive
+ * @param: record, pointer to the struct the record is decoded into
+ */
+func GetRecord(stub shim.ChaincodeStubInterface, collection string, key string, objectType string, record interface{}) error {
+	recordAsBytes, errRecordAsByte := stub.GetPrivateData(collection, key)
+	if errRecordAsByte != nil {
+		return &StorageError{collection, key, errRecordAsByte}
+	} else if recordAsBytes == nil {
+		return &NotFoundError{collection, key}
+	}
+	return DecodeRecord(collection, key, objectType, recordAsBytes, record)
+}
+
+//decode a record read from a collection and check it is a document of objectType
+func DecodeRecord(collection string, key string, objectType string, recordAsBytes []byte, record interface{}) error {
+	document := &struct {
+		ObjectType string `json:"docType"`
+	}{}
+	errRecordAsByte := json.Unmarshal(recordAsBytes, document)
+	if errRecordAsByte != nil {
+		return &CorruptRecordError{collection, key, errRecordAsByte}
+	} else if document.ObjectType != objectType {
+		return &CorruptRecordError{collection, key, errors.New("expecting docType " + objectType + ", got " + document.ObjectType)}
+	}
+
+	errRecordAsByte = json.Unmarshal(recordAsBytes, record)
+	if errRecordAsByte != nil {
+		return &CorruptRecordError{collection, key, errRecordAsByte}
+	}
+	return nil
+}
+
+//save a record to a private data collection
+func PutRecord(stub shim.ChaincodeStubInterface, collection string, key string, record interface{}) error {
+	recordAsByte, errRecordAsByte := json.Marshal(record)
+	if errRecordAsByte != nil {
+		return errRecordAsByte
+	}
+
+	errRecordAsByte = stub.PutPrivateData(collection, key, recordAsByte)
+	if errRecordAsByte != nil {
+		return &StorageError{collection, key, errRecordAsByte}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package common_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+	"github.com/xuansonha17031991/heathcare-chaincode/common/mockstub"
+)
+
+func TestGetRecord(t *testing.T) {
+	stub := mockstub.NewMockStub("common", nil)
+	stub.SetPrivateData(common.DrugInformationCollection, "valid", []byte(`{"docType":"DrugInformation","id":"valid","drug_name":"aspirin"}`))
+	stub.SetPrivateData(common.DrugInformationCollection, "legacy", []byte(`{"doctype":"DrugInformation","id":"legacy","drug_name":"aspirin"}`))
+	stub.SetPrivateData(common.DrugInformationCollection, "truncated", []byte(`{"docType":"DrugInformation","id":`))
+	stub.SetPrivateData(common.DrugInformationCollection, "other", []byte(`{"docType":"MedicalRecord","id":"other"}`))
+	stub.SetPrivateData(common.DrugInformationCollection, "mistyped", []byte(`{"docType":"DrugInformation","id":7}`))
+
+	drug := &common.DrugInformation{}
+	errDrug := common.GetRecord(stub, common.DrugInformationCollection, "valid", common.ObjectTypeDrugInformation, drug)
+	if errDrug != nil {
+		t.Fatal(errDrug)
+	} else if drug.DrugName != "aspirin" {
+		t.Fatalf("expecting drug aspirin, got %q", drug.DrugName)
+	}
+
+	errDrug = common.GetRecord(stub, common.DrugInformationCollection, "legacy", common.ObjectTypeDrugInformation, &common.DrugInformation{})
+	if errDrug != nil {
+		t.Fatalf("expecting record with doctype tag to be read, got %s", errDrug)
+	}
+
+	errDrug = common.GetRecord(stub, common.DrugInformationCollection, "missing", common.ObjectTypeDrugInformation, &common.DrugInformation{})
+	if _, isNotFound := errDrug.(*common.NotFoundError); !isNotFound {
+		t.Fatalf("expecting not found error, got %v", errDrug)
+	}
+
+	for _, key := range []string{"truncated", "other", "mistyped"} {
+		errDrug = common.GetRecord(stub, common.DrugInformationCollection, key, common.ObjectTypeDrugInformation, &common.DrugInformation{})
+		if _, isCorrupt := errDrug.(*common.CorruptRecordError); !isCorrupt {
+			t.Fatalf("%s: expecting corrupt record error, got %v", key, errDrug)
+		}
+	}
+
+	stub.Errors[common.DrugInformationCollection] = errors.New("disk failure")
+	errDrug = common.GetRecord(stub, common.DrugInformationCollection, "valid", common.ObjectTypeDrugInformation, &common.DrugInformation{})
+	if _, isStorage := errDrug.(*common.StorageError); !isStorage {
+		t.Fatalf("expecting storage error, got %v", errDrug)
+	}
+}
+
+func TestPutRecord(t *testing.T) {
+	stub := mockstub.NewMockStub("common", nil)
+	drug := &common.DrugInformation{ObjectType: common.ObjectTypeDrugInformation, ID: "P1", DrugName: "aspirin"}
+
+	errDrug := common.PutRecord(stub, common.DrugInformationCollection, "P1", drug)
+	if errDrug != nil {
+		t.Fatal(errDrug)
+	}
+	errDrug = common.GetRecord(stub, common.DrugInformationCollection, "P1", common.ObjectTypeDrugInformation, &common.DrugInformation{})
+	if errDrug != nil {
+		t.Fatal(errDrug)
+	}
+
+	stub.Errors[common.DrugInformationCollection] = errors.New("disk failure")
+	errDrug = common.PutRecord(stub, common.DrugInformationCollection, "P1", drug)
+	if _, isStorage := errDrug.(*common.StorageError); !isStorage {
+		t.Fatalf("expecting storage error, got %v", errDrug)
+	}
+}
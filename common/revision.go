@@ -0,0 +1,215 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// collections that keep every revision of their records, for clinical and legal reasons
+var RevisionCollections = map[string]bool{
+	MedicalRecordCollection: true,
+}
+
+/**
+ * Revision of a record, saved under revision~id~version in the collection of the record
+ * its time is empty when unknown, for the stored version of a record written before revisions were added
+ */
+type Revision struct {
+	ObjectType string          `json:"docType"`
+	Version    int64           `json:"version"`
+	Author     string          `json:"author"`
+	TxID       string          `json:"txid"`
+	Time       string          `json:"time"`
+	Record     json.RawMessage `json:"record"`
+}
+
+/**
+ * save the current version of a record as a revision written by author
+ * the version is zero padded in the key so revisions are listed in order of version
+ */
+func PutRevision(stub shim.ChaincodeStubInterface, collection string, key string, author string, record VersionedRecord) error {
+	timeRevision, errTimeRevision := GetTxTimestamp(stub)
+	if errTimeRevision != nil {
+		return errTimeRevision
+	}
+	return putRevision(stub, collection, key, author, timeRevision, record)
+}
+
+//save a version of a record as a revision written by author at timeRevision
+func putRevision(stub shim.ChaincodeStubInterface, collection string, key string, author string, timeRevision string, record VersionedRecord) error {
+	recordAsByte, errRecordAsByte := json.Marshal(record)
+	if errRecordAsByte != nil {
+		return errRecordAsByte
+	}
+
+	revision := &Revision{ObjectTypeRevision, record.GetVersion(), author, stub.GetTxID(), timeRevision, recordAsByte}
+	revisionKey, errRevisionKey := stub.CreateCompositeKey("revision~id", []string{key, fmt.Sprintf("%020d", revision.Version)})
+	if errRevisionKey != nil {
+		return errRevisionKey
+	}
+	return PutRecord(stub, collection, revisionKey, revision)
+}
+
+/**
+ * save the stored version of a record as a revision before it is overwritten, when it has none
+ * so the values of a record written before revisions were added are not lost
+ * records do not store the time they were written, so the author and the time of such a revision
+ * are unknown and left empty, it is the version current before any time
+ */
+func PutStoredRevision(stub shim.ChaincodeStubInterface, collection string, key string, record VersionedRecord) error {
+	revisionKey, errRevisionKey := stub.CreateCompositeKey("revision~id", []string{key, fmt.Sprintf("%020d", record.GetVersion())})
+	if errRevisionKey != nil {
+		return errRevisionKey
+	}
+	revisionAsBytes, errRevisionAsByte := stub.GetPrivateData(collection, revisionKey)
+	if errRevisionAsByte != nil {
+		return &StorageError{collection, revisionKey, errRevisionAsByte}
+	} else if revisionAsBytes != nil {
+		return nil
+	}
+	return putRevision(stub, collection, key, "", "", record)
+}
+
+//get every revision of a record, ordered by version
+func GetRevisions(stub shim.ChaincodeStubInterface, collection string, key string) ([]*Revision, error) {
+	revisionIterator, errRevisionIterator := stub.GetPrivateDataByPartialCompositeKey(collection, "revision~id", []string{key})
+	if errRevisionIterator != nil {
+		return nil, &StorageError{collection, key, errRevisionIterator}
+	}
+	defer revisionIterator.Close()
+
+	revisions := []*Revision{}
+	for revisionIterator.HasNext() {
+		revisionKV, errRevisionKV := revisionIterator.Next()
+		if errRevisionKV != nil {
+			return nil, &StorageError{collection, key, errRevisionKV}
+		}
+
+		revision := &Revision{}
+		errRevision := json.Unmarshal(revisionKV.Value, revision)
+		if errRevision != nil {
+			return nil, &CorruptRecordError{collection, revisionKV.Key, errRevision}
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+/**
+ * get the revision of a record current at a time, the last one saved at or before it
+ * a revision of unknown time was saved before any time
+ * returns a NotFoundError when the record did not exist yet
+ */
+func GetRevisionAsOf(stub shim.ChaincodeStubInterface, collection string, key string, asOf time.Time) (*Revision, error) {
+	revisions, errRevisions := GetRevisions(stub, collection, key)
+	if errRevisions != nil {
+		return nil, errRevisions
+	}
+
+	var current *Revision
+	for _, revision := range revisions {
+		if len(revision.Time) == 0 {
+			current = revision
+			continue
+		}
+		timeRevision, errTimeRevision := ParseTimestamp(revision.Time)
+		if errTimeRevision != nil {
+			return nil, &CorruptRecordError{collection, key, errTimeRevision}
+		} else if timeRevision.After(asOf) {
+			break
+		}
+		current = revision
+	}
+
+	if current == nil {
+		return nil, &NotFoundError{collection, key}
+	}
+	return current, nil
+}
+
+/**
+ * get every revision of the medical record of a patient with its author, tx id and time
+ * a record written before revisions were added has its stored version kept on its first modification
+ * @param: patientid
+ */
+func GetMedicalRecordHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 1)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	patientid := args[0]
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckAccess(stub, ResourceMedicalRecord, PurposeQuery, patientid, "")
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	revisions, errRevisions := GetRevisions(stub, MedicalRecordCollection, patientid)
+	if errRevisions != nil {
+		return ErrorResponse(errRevisions)
+	}
+
+	//a record not modified since revisions were added has none, a missing record is not found
+	if len(revisions) == 0 {
+		errRecord := GetRecord(stub, MedicalRecordCollection, patientid, ObjectTypeMedicalRecord, &MedicalRecord{})
+		if errRecord != nil {
+			return ErrorResponse(errRecord)
+		}
+	}
+
+	//notify listeners, the event carries no health information
+	errEvent := SetRecordEvent(stub, EventRecordQueried, ObjectTypeMedicalRecord, patientid, identity.ID)
+	if errEvent != nil {
+		return ErrorResponse(errEvent)
+	}
+
+	revisionsAsByte, errRevisionsAsByte := json.Marshal(revisions)
+	if errRevisionsAsByte != nil {
+		return ErrorResponse(errRevisionsAsByte)
+	}
+	return shim.Success(revisionsAsByte)
+}
+
+/**
+ * get the medical record of a patient as it stood at a time
+ * @param: patientid, timestamp as RFC 3339
+ */
+func GetMedicalRecordAsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 2)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	patientid := args[0]
+	asOf, errAsOf := ParseTimestamp(args[1])
+	if errAsOf != nil {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "timestamp", errAsOf.Error()))
+	}
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckAccess(stub, ResourceMedicalRecord, PurposeQuery, patientid, "")
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	revision, errRevision := GetRevisionAsOf(stub, MedicalRecordCollection, patientid, asOf)
+	if _, isNotFound := errRevision.(*NotFoundError); isNotFound {
+		return ErrorResponse(NewError(CodeNotFound, "record "+patientid+" did not exist in "+MedicalRecordCollection+" at "+FormatTimestamp(asOf)))
+	} else if errRevision != nil {
+		return ErrorResponse(errRevision)
+	}
+
+	//notify listeners, the event carries no health information
+	errEvent := SetRecordEvent(stub, EventRecordQueried, ObjectTypeMedicalRecord, patientid, identity.ID)
+	if errEvent != nil {
+		return ErrorResponse(errEvent)
+	}
+
+	return shim.Success(revision.Record)
+}
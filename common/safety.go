@@ -0,0 +1,541 @@
+package common
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// key of the transient map holding the reason of a prescriber to record a drug despite its conflicts
+const TransientOverrideKey = "override"
+
+// severities of a drug interaction
+const (
+	SeverityMinor    = "minor"
+	SeverityModerate = "moderate"
+	SeverityMajor    = "major"
+)
+
+/**
+ * Interaction of a pair of drugs, saved in world state under interaction~drug~drug
+ * the reference table holds no health information of patients, so every org can read it
+ */
+type Interaction struct {
+	ObjectType  string   `json:"docType"`
+	Drugs       []string `json:"drugs"`
+	Severity    string   `json:"severity"`
+	Description string   `json:"description"`
+}
+
+// Contraindication of a drug for patients allergic to an allergen, saved in world state under contraindication~allergen~drug
+type Contraindication struct {
+	ObjectType  string `json:"docType"`
+	Allergen    string `json:"allergen"`
+	Drug        string `json:"drug"`
+	Description string `json:"description"`
+}
+
+// Allergy of a patient, saved under allergy~patientid~allergen in AllergyCollection,
+// which is never purged so the safety checks do not pass once an allergy expired
+type Allergy struct {
+	ObjectType string `json:"docType"`
+	PatientID  string `json:"patientid"`
+	Allergen   string `json:"allergen"`
+	Reaction   string `json:"reaction"`
+	RecordedBy string `json:"recorded_by"`
+	Time       string `json:"time"`
+}
+
+// AllergyDocument is the json document of addAllergy and removeAllergy, passed in the transient map
+type AllergyDocument struct {
+	ID       string `json:"id"`
+	Location string `json:"location"`
+	Allergen string `json:"allergen"`
+	Reaction string `json:"reaction"`
+}
+
+// schemas of addAllergy and removeAllergy, the patient id and location route the call
+var (
+	AllergySchema = &Schema{
+		Fields:   []string{"id", "location", "allergen", "reaction"},
+		Required: []string{"id", "location", "allergen"},
+		Routing:  []string{"id", "location"},
+	}
+	AllergyRemovalSchema = &Schema{
+		Fields:   []string{"id", "location", "allergen"},
+		Required: []string{"id", "location", "allergen"},
+		Routing:  []string{"id", "location"},
+	}
+)
+
+// SafetyOverride records the conflicts of a drug a prescriber recorded anyway, and why
+type SafetyOverride struct {
+	Conflicts []string `json:"conflicts"`
+	Reason    string   `json:"reason"`
+	By        string   `json:"by"`
+}
+
+//names of drugs and allergens are compared case insensitively
+func NormalizeDrug(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func interactionKey(stub shim.ChaincodeStubInterface, drug string, otherDrug string) (string, error) {
+	drugs := []string{NormalizeDrug(drug), NormalizeDrug(otherDrug)}
+	sort.Strings(drugs)
+	return stub.CreateCompositeKey("interaction", drugs)
+}
+
+func contraindicationKey(stub shim.ChaincodeStubInterface, allergen string, drug string) (string, error) {
+	return stub.CreateCompositeKey("contraindication", []string{NormalizeDrug(allergen), NormalizeDrug(drug)})
+}
+
+//get a reference entry from world state, nil when it does not exist
+func getReference(stub shim.ChaincodeStubInterface, key string, reference interface{}) (bool, error) {
+	referenceAsByte, errReferenceAsByte := stub.GetState(key)
+	if errReferenceAsByte != nil {
+		return false, NewError(CodeStorage, "cannot get drug reference: "+errReferenceAsByte.Error())
+	} else if referenceAsByte == nil {
+		return false, nil
+	}
+
+	errReference := json.Unmarshal(referenceAsByte, reference)
+	if errReference != nil {
+		return false, NewError(CodeCorruptRecord, "cannot read drug reference: "+errReference.Error())
+	}
+	return true, nil
+}
+
+func putReference(stub shim.ChaincodeStubInterface, key string, reference interface{}) error {
+	referenceAsByte, errReferenceAsByte := json.Marshal(reference)
+	if errReferenceAsByte != nil {
+		return errReferenceAsByte
+	}
+
+	errReferenceAsByte = stub.PutState(key, referenceAsByte)
+	if errReferenceAsByte != nil {
+		return NewError(CodeStorage, "cannot save drug reference: "+errReferenceAsByte.Error())
+	}
+	return nil
+}
+
+func deleteReference(stub shim.ChaincodeStubInterface, key string, name string) error {
+	referenceAsByte, errReferenceAsByte := stub.GetState(key)
+	if errReferenceAsByte != nil {
+		return NewError(CodeStorage, "cannot get drug reference: "+errReferenceAsByte.Error())
+	} else if referenceAsByte == nil {
+		return NewError(CodeNotFound, name+" does not exist")
+	}
+
+	errReferenceAsByte = stub.DelState(key)
+	if errReferenceAsByte != nil {
+		return NewError(CodeStorage, "cannot delete drug reference: "+errReferenceAsByte.Error())
+	}
+	return nil
+}
+
+/**
+ * add or replace an interaction of the reference table, only admins may
+ * @param: drug
+ * @param: otherDrug
+ * @param: severity, minor, moderate or major
+ * @param: description
+ */
+func PutInteraction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 4)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	severity := args[2]
+	if severity != SeverityMinor && severity != SeverityModerate && severity != SeverityMajor {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "severity", "severity must be "+SeverityMinor+", "+SeverityModerate+" or "+SeverityMajor))
+	} else if NormalizeDrug(args[0]) == NormalizeDrug(args[1]) {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "drug", "a drug cannot interact with itself"))
+	}
+
+	errAdmin := AuthorizeAdmin(stub)
+	if errAdmin != nil {
+		return ErrorResponse(errAdmin)
+	}
+
+	key, errKey := interactionKey(stub, args[0], args[1])
+	if errKey != nil {
+		return ErrorResponse(errKey)
+	}
+	drugs := []string{NormalizeDrug(args[0]), NormalizeDrug(args[1])}
+	sort.Strings(drugs)
+
+	errInteraction := putReference(stub, key, &Interaction{ObjectTypeInteraction, drugs, severity, args[3]})
+	if errInteraction != nil {
+		return ErrorResponse(errInteraction)
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * remove an interaction of the reference table, only admins may
+ * @param: drug
+ * @param: otherDrug
+ */
+func RemoveInteraction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 2)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	errAdmin := AuthorizeAdmin(stub)
+	if errAdmin != nil {
+		return ErrorResponse(errAdmin)
+	}
+
+	key, errKey := interactionKey(stub, args[0], args[1])
+	if errKey != nil {
+		return ErrorResponse(errKey)
+	}
+	errInteraction := deleteReference(stub, key, "interaction of "+args[0]+" and "+args[1])
+	if errInteraction != nil {
+		return ErrorResponse(errInteraction)
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * add or replace a contraindication of the reference table, only admins may
+ * @param: allergen
+ * @param: drug
+ * @param: description
+ */
+func PutContraindication(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 3)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	errAdmin := AuthorizeAdmin(stub)
+	if errAdmin != nil {
+		return ErrorResponse(errAdmin)
+	}
+
+	key, errKey := contraindicationKey(stub, args[0], args[1])
+	if errKey != nil {
+		return ErrorResponse(errKey)
+	}
+	errContraindication := putReference(stub, key, &Contraindication{ObjectTypeContraindication, NormalizeDrug(args[0]), NormalizeDrug(args[1]), args[2]})
+	if errContraindication != nil {
+		return ErrorResponse(errContraindication)
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * remove a contraindication of the reference table, only admins may
+ * @param: allergen
+ * @param: drug
+ */
+func RemoveContraindication(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 2)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	errAdmin := AuthorizeAdmin(stub)
+	if errAdmin != nil {
+		return ErrorResponse(errAdmin)
+	}
+
+	key, errKey := contraindicationKey(stub, args[0], args[1])
+	if errKey != nil {
+		return ErrorResponse(errKey)
+	}
+	errContraindication := deleteReference(stub, key, "contraindication of "+args[1]+" for "+args[0])
+	if errContraindication != nil {
+		return ErrorResponse(errContraindication)
+	}
+	return shim.Success(nil)
+}
+
+func allergyKey(stub shim.ChaincodeStubInterface, patientid string, allergen string) (string, error) {
+	return stub.CreateCompositeKey("allergy", []string{patientid, NormalizeDrug(allergen)})
+}
+
+func GetAllergies(stub shim.ChaincodeStubInterface, patientid string) ([]*Allergy, error) {
+	allergyIterator, errAllergyIterator := stub.GetPrivateDataByPartialCompositeKey(AllergyCollection, "allergy", []string{patientid})
+	if errAllergyIterator != nil {
+		return nil, &StorageError{AllergyCollection, patientid, errAllergyIterator}
+	}
+	defer allergyIterator.Close()
+
+	allergies := []*Allergy{}
+	for allergyIterator.HasNext() {
+		allergyKV, errAllergyKV := allergyIterator.Next()
+		if errAllergyKV != nil {
+			return nil, &StorageError{AllergyCollection, patientid, errAllergyKV}
+		}
+
+		allergy := &Allergy{}
+		errAllergy := DecodeRecord(AllergyCollection, allergyKV.Key, ObjectTypeAllergy, allergyKV.Value, allergy)
+		if errAllergy != nil {
+			return nil, errAllergy
+		}
+		allergies = append(allergies, allergy)
+	}
+	return allergies, nil
+}
+
+/**
+ * record an allergy of a patient
+ * @param: id, location, as positional arguments or a json object
+ * transient document: json document of AllergySchema, fields id, location, allergen, reaction
+ */
+func AddAllergy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	document := &AllergyDocument{}
+	errArgs := AllergySchema.Decode(stub, args, document)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckAccess(stub, ResourceDrugInformation, PurposeModify, document.ID, document.Location)
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	timeAllergy, errTimeAllergy := GetTxTimestamp(stub)
+	if errTimeAllergy != nil {
+		return ErrorResponse(errTimeAllergy)
+	}
+
+	key, errKey := allergyKey(stub, document.ID, document.Allergen)
+	if errKey != nil {
+		return ErrorResponse(errKey)
+	}
+	allergy := &Allergy{ObjectTypeAllergy, document.ID, NormalizeDrug(document.Allergen), document.Reaction, identity.ID, timeAllergy}
+	errAllergy := PutRecord(stub, AllergyCollection, key, allergy)
+	if errAllergy != nil {
+		return ErrorResponse(errAllergy)
+	}
+
+	//notify listeners, the event carries no health information
+	errEvent := SetRecordEvent(stub, EventRecordModified, ObjectTypeAllergy, document.ID, identity.ID)
+	if errEvent != nil {
+		return ErrorResponse(errEvent)
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * remove an allergy of a patient recorded in error
+ * @param: id, location, as positional arguments or a json object
+ * transient document: json document of AllergyRemovalSchema, fields id, location, allergen
+ */
+func RemoveAllergy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	document := &AllergyDocument{}
+	errArgs := AllergyRemovalSchema.Decode(stub, args, document)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckAccess(stub, ResourceDrugInformation, PurposeModify, document.ID, document.Location)
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	key, errKey := allergyKey(stub, document.ID, document.Allergen)
+	if errKey != nil {
+		return ErrorResponse(errKey)
+	}
+	errAllergy := GetRecord(stub, AllergyCollection, key, ObjectTypeAllergy, &Allergy{})
+	if _, isNotFound := errAllergy.(*NotFoundError); isNotFound {
+		return ErrorResponse(NewError(CodeNotFound, "allergy of patient "+document.ID+" does not exist"))
+	} else if errAllergy != nil {
+		return ErrorResponse(errAllergy)
+	}
+
+	errAllergy = stub.DelPrivateData(AllergyCollection, key)
+	if errAllergy != nil {
+		return ErrorResponse(&StorageError{AllergyCollection, document.ID, errAllergy})
+	}
+
+	//notify listeners, the event carries no health information
+	errEvent := SetRecordEvent(stub, EventRecordModified, ObjectTypeAllergy, document.ID, identity.ID)
+	if errEvent != nil {
+		return ErrorResponse(errEvent)
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * get the allergies of a patient
+ * @param: patientid
+ * @param: location
+ */
+func ListAllergies(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	errArgs := CheckArgs(args, 2)
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	patientid := args[0]
+	location := args[1]
+
+	//check permission and consent, then append the access to the log
+	identity, errAccess := CheckAccess(stub, ResourceDrugInformation, PurposeQuery, patientid, location)
+	if errAccess != nil {
+		return ErrorResponse(errAccess)
+	}
+
+	allergies, errAllergies := GetAllergies(stub, patientid)
+	if errAllergies != nil {
+		return ErrorResponse(errAllergies)
+	}
+
+	//notify listeners, the event carries no health information
+	errEvent := SetRecordEvent(stub, EventRecordQueried, ObjectTypeAllergy, patientid, identity.ID)
+	if errEvent != nil {
+		return ErrorResponse(errEvent)
+	}
+
+	allergiesAsByte, errAllergiesAsByte := json.Marshal(allergies)
+	if errAllergiesAsByte != nil {
+		return ErrorResponse(errAllergiesAsByte)
+	}
+	return shim.Success(allergiesAsByte)
+}
+
+/**
+ * get the drugs a patient is taking: the drugs of its active prescriptions,
+ * and the drug of its drug information unless the drug information is being replaced
+ */
+func ActiveMedications(stub shim.ChaincodeStubInterface, patientid string, withDrugInformation bool) ([]string, error) {
+	medications := []string{}
+	if withDrugInformation {
+		drug := &DrugInformation{}
+		errDrug := GetRecord(stub, DrugInformationCollection, patientid, ObjectTypeDrugInformation, drug)
+		if _, isNotFound := errDrug.(*NotFoundError); errDrug != nil && !isNotFound {
+			return nil, errDrug
+		} else if errDrug == nil && len(drug.DrugName) > 0 {
+			medications = append(medications, drug.DrugName)
+		}
+	}
+
+	prescriptionIterator, errPrescriptionIterator := stub.GetPrivateDataByPartialCompositeKey(PrescriptionCollection, "prescription", []string{patientid})
+	if errPrescriptionIterator != nil {
+		return nil, &StorageError{PrescriptionCollection, patientid, errPrescriptionIterator}
+	}
+	defer prescriptionIterator.Close()
+
+	for prescriptionIterator.HasNext() {
+		prescriptionKV, errPrescriptionKV := prescriptionIterator.Next()
+		if errPrescriptionKV != nil {
+			return nil, &StorageError{PrescriptionCollection, patientid, errPrescriptionKV}
+		}
+
+		prescription := &Prescription{}
+		errPrescription := DecodeRecord(PrescriptionCollection, prescriptionKV.Key, ObjectTypePrescription, prescriptionKV.Value, prescription)
+		if errPrescription != nil {
+			return nil, errPrescription
+		} else if prescription.Status == PrescriptionActive {
+			medications = append(medications, prescription.DrugName)
+		}
+	}
+	return medications, nil
+}
+
+//get the interactions of a drug with the active medications and its contraindications for the allergies of a patient
+func DrugConflicts(stub shim.ChaincodeStubInterface, patientid string, drugName string, withDrugInformation bool) ([]string, error) {
+	drug := NormalizeDrug(drugName)
+	conflicts := []string{}
+
+	medications, errMedications := ActiveMedications(stub, patientid, withDrugInformation)
+	if errMedications != nil {
+		return nil, errMedications
+	}
+	for _, medication := range medications {
+		if NormalizeDrug(medication) == drug {
+			continue
+		}
+		key, errKey := interactionKey(stub, drug, medication)
+		if errKey != nil {
+			return nil, errKey
+		}
+		interaction := &Interaction{}
+		found, errInteraction := getReference(stub, key, interaction)
+		if errInteraction != nil {
+			return nil, errInteraction
+		} else if found {
+			conflicts = append(conflicts, drug+" interacts with "+NormalizeDrug(medication)+" ("+interaction.Severity+"): "+interaction.Description)
+		}
+	}
+
+	allergies, errAllergies := GetAllergies(stub, patientid)
+	if errAllergies != nil {
+		return nil, errAllergies
+	}
+	for _, allergy := range allergies {
+		if allergy.Allergen == drug {
+			conflicts = append(conflicts, "patient is allergic to "+drug)
+			continue
+		}
+		key, errKey := contraindicationKey(stub, allergy.Allergen, drug)
+		if errKey != nil {
+			return nil, errKey
+		}
+		contraindication := &Contraindication{}
+		found, errContraindication := getReference(stub, key, contraindication)
+		if errContraindication != nil {
+			return nil, errContraindication
+		} else if found {
+			conflicts = append(conflicts, drug+" is contraindicated by allergy to "+allergy.Allergen+": "+contraindication.Description)
+		}
+	}
+	return conflicts, nil
+}
+
+/**
+ * check a drug about to be recorded for a patient against its active medications and allergies
+ * a drug with conflicts is rejected, unless a prescriber passes the reason of an override in the transient map;
+ * the override is recorded in the modify log and returned so the record can keep it
+ * @param: identity already authorized to modify the drug information of the patient, the modification already logged
+ * @param: fields, the fields of a patch, nil for other modifications
+ * @param: withDrugInformation, false when the drug information of the patient is being replaced
+ */
+func CheckDrugSafety(stub shim.ChaincodeStubInterface, identity *Identity, patientid string, location string, fields []string, drugName string, withDrugInformation bool) (*SafetyOverride, error) {
+	if len(NormalizeDrug(drugName)) == 0 {
+		return nil, nil
+	}
+
+	conflicts, errConflicts := DrugConflicts(stub, patientid, drugName, withDrugInformation)
+	if errConflicts != nil {
+		return nil, errConflicts
+	} else if len(conflicts) == 0 {
+		return nil, nil
+	}
+
+	transient, errTransient := stub.GetTransient()
+	if errTransient != nil {
+		return nil, NewError(CodeInvalidArgument, "cannot get transient map: "+errTransient.Error())
+	}
+	reason := strings.TrimSpace(string(transient[TransientOverrideKey]))
+	if len(reason) == 0 {
+		return nil, NewFieldError(CodeConflict, "drug_name", "drug "+NormalizeDrug(drugName)+" conflicts with the medications or allergies of patient "+patientid+": "+strings.Join(conflicts, "; "))
+	}
+
+	//only a prescriber may take the responsibility of an override
+	prescriber := &Identity{ID: identity.ID, MSPID: identity.MSPID, EnrollmentID: identity.EnrollmentID}
+	errPrescriber := Authorize(stub, prescriber, ResourceDrugInformation, ActionPrescribe, patientid)
+	if errPrescriber != nil {
+		return nil, NewError(CodeForbidden, "user "+identity.ID+" is not a prescriber and cannot override the conflicts of drug "+NormalizeDrug(drugName))
+	}
+
+	override := &SafetyOverride{conflicts, reason, identity.ID}
+	errLog := LogOverride(stub, identity, patientid, location, fields, override)
+	if errLog != nil {
+		return nil, errLog
+	}
+	return override, nil
+}
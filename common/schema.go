@@ -0,0 +1,249 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// key of the transient map holding the document of a create or modify function
+const TransientDocumentKey = "document"
+
+/**
+ * Schema of the json document a create or modify function accepts
+ * only the routing fields are passed in the arguments, which are recorded in the block;
+ * every other field is protected health information and is passed in the transient map
+ */
+type Schema struct {
+	//json names of the fields, in the order of the positional arguments of older clients
+	Fields []string
+	//fields that must be declared and not empty
+	Required []string
+	//fields passed in the arguments, the first fields of Fields
+	Routing []string
+}
+
+/**
+ * update of a record by a modify function, location is written to the access log
+ * ExpectedVersion is the version of the record the invoker read, it shadows the version of the record
+ */
+type PatientInformationUpdate struct {
+	PatientInformation
+	Location        string `json:"location"`
+	ExpectedVersion string `json:"version"`
+}
+
+type MedicalRecordUpdate struct {
+	MedicalRecord
+	Location        string `json:"location"`
+	ExpectedVersion string `json:"version"`
+}
+
+type DrugInformationUpdate struct {
+	DrugInformation
+	Location        string `json:"location"`
+	ExpectedVersion string `json:"version"`
+}
+
+var (
+	patientInformationFields = []string{"photo_id", "insurance_card", "current_medication_information", "related_medical_records", "make_note_of_appointment_date"}
+	medicalRecordFields      = []string{"id", "personal_identification", "medical_history", "family_medical_history", "medication_history", "treatment_history", "medical_directives"}
+	drugInformationFields    = []string{"id", "patient_name", "drug_name", "expiration_date", "quantity", "prescribed_by"}
+	hospitalFeesFields       = []string{"id", "patient_name", "account", "date_of_service", "patient_service", "primary_insurance_billed", "secondary_insurance_billed", "pharmacy", "room", "amount_due"}
+)
+
+// schemas of the create functions, every field is required and the id of the record routes the call
+var (
+	PatientInformationSchema = createSchema(patientInformationFields)
+	MedicalRecordSchema      = createSchema(medicalRecordFields)
+	DrugInformationSchema    = createSchema(drugInformationFields)
+	HospitalFeesSchema       = createSchema(hospitalFeesFields)
+)
+
+// schemas of the modify and patch functions, the id, location and expected version of the record are required and new values may be empty
+var (
+	PatientInformationUpdateSchema = updateSchema(patientInformationFields)
+	MedicalRecordUpdateSchema      = updateSchema(medicalRecordFields)
+	DrugInformationUpdateSchema    = updateSchema(drugInformationFields)
+	HospitalFeesUpdateSchema       = updateSchema(hospitalFeesFields)
+)
+
+// Validator is a document with typed fields, like quantities, dates and amounts, checked once decoded
+type Validator interface {
+	Validate() error
+}
+
+func createSchema(fields []string) *Schema {
+	return &Schema{fields, fields, fields[:1]}
+}
+
+//schema of a modify function: id, location, version, then the other fields of the record
+func updateSchema(fields []string) *Schema {
+	updateFields := append([]string{fields[0], "location", "version"}, fields[1:]...)
+	return &Schema{updateFields, updateFields[:3], updateFields[:3]}
+}
+
+func (schema *Schema) IsRequired(field string) bool {
+	for _, requiredField := range schema.Required {
+		if requiredField == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (schema *Schema) HasField(field string) bool {
+	for _, schemaField := range schema.Fields {
+		if schemaField == field {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * decode a create or modify function from its arguments and the document of its transient map
+ * arguments are the routing fields, positional or as a json object, any other field is rejected
+ * so protected health information is never recorded in the block
+ * @param: document, pointer to the struct the json document is decoded into
+ */
+func (schema *Schema) Decode(stub shim.ChaincodeStubInterface, args []string, document interface{}) error {
+	routing, errRouting := schema.DecodeRouting(args)
+	if errRouting != nil {
+		return errRouting
+	}
+
+	transient, errTransient := stub.GetTransient()
+	if errTransient != nil {
+		return NewError(CodeInvalidArgument, "cannot get transient map: "+errTransient.Error())
+	}
+	documentAsByte, found := transient[TransientDocumentKey]
+	if !found || len(documentAsByte) == 0 {
+		return NewFieldError(CodeInvalidArgument, TransientDocumentKey, "document must be passed in the transient map")
+	}
+
+	fields := map[string]interface{}{}
+	errFields := json.Unmarshal(documentAsByte, &fields)
+	if errFields != nil {
+		return NewFieldError(CodeInvalidArgument, TransientDocumentKey, "invalid json document: "+errFields.Error())
+	}
+
+	//routing fields may be repeated in the document, but must not differ, a version may be a json number on either side
+	for field, value := range routing {
+		documentValue, found := fields[field]
+		if found && fmt.Sprint(documentValue) != value {
+			return NewFieldError(CodeInvalidArgument, field, field+" of arguments and document differ")
+		}
+		fields[field] = value
+	}
+
+	documentAsByte, errDocumentAsByte := json.Marshal(fields)
+	if errDocumentAsByte != nil {
+		return errDocumentAsByte
+	}
+	return schema.DecodeJSON(documentAsByte, document)
+}
+
+/**
+ * get the routing fields from the arguments of a create or modify function
+ * a single argument starting with { is a json object, otherwise arguments are positional
+ * the other fields of the schema, and the positional form of older clients which has them all, are rejected
+ */
+func (schema *Schema) DecodeRouting(args []string) (map[string]string, error) {
+	routing := make(map[string]string, len(schema.Routing))
+
+	if len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+		fields := map[string]interface{}{}
+		decoder := json.NewDecoder(strings.NewReader(args[0]))
+		decoder.UseNumber()
+		errFields := decoder.Decode(&fields)
+		if errFields != nil {
+			return nil, NewError(CodeInvalidArgument, "invalid json document: "+errFields.Error())
+		}
+
+		for field, value := range fields {
+			if !schema.HasField(field) {
+				return nil, NewFieldError(CodeInvalidArgument, field, "unknown field "+field)
+			} else if !schema.IsRouting(field) {
+				return nil, schema.protectedFieldError(field)
+			}
+			//a version may be passed as a json number
+			valueAsString, isString := value.(string)
+			if number, isNumber := value.(json.Number); isNumber {
+				valueAsString, isString = number.String(), true
+			}
+			if !isString {
+				return nil, NewFieldError(CodeInvalidArgument, field, field+" must be a string")
+			}
+			routing[field] = valueAsString
+		}
+		return routing, nil
+	}
+
+	if len(args) > len(schema.Routing) && len(args) <= len(schema.Fields) {
+		return nil, schema.protectedFieldError(schema.Fields[len(schema.Routing)])
+	}
+	errArgs := CheckArgCount(args, len(schema.Routing))
+	if errArgs != nil {
+		return nil, errArgs
+	}
+
+	for i, field := range schema.Routing {
+		if len(args[i]) == 0 && schema.IsRequired(field) {
+			return nil, NewFieldError(CodeInvalidArgument, field, "argument "+strconv.Itoa(i+1)+" must be declare")
+		}
+		routing[field] = args[i]
+	}
+	return routing, nil
+}
+
+func (schema *Schema) IsRouting(field string) bool {
+	for _, routingField := range schema.Routing {
+		if routingField == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (schema *Schema) protectedFieldError(field string) error {
+	return NewFieldError(CodeInvalidArgument, field, field+" is protected health information and must be passed in the transient map")
+}
+
+//decode a json document and check it against the schema
+func (schema *Schema) DecodeJSON(documentAsByte []byte, document interface{}) error {
+	fields := map[string]interface{}{}
+	errFields := json.Unmarshal(documentAsByte, &fields)
+	if errFields != nil {
+		return NewError(CodeInvalidArgument, "invalid json document: "+errFields.Error())
+	}
+
+	for field := range fields {
+		if !schema.HasField(field) {
+			return NewFieldError(CodeInvalidArgument, field, "unknown field "+field)
+		}
+	}
+
+	errDocument := json.Unmarshal(documentAsByte, document)
+	if typeErr, isTypeErr := errDocument.(*json.UnmarshalTypeError); isTypeErr {
+		return NewFieldError(CodeInvalidArgument, typeErr.Field, typeErr.Field+" must be a "+typeErr.Type.String())
+	} else if errDocument != nil {
+		return NewError(CodeInvalidArgument, "invalid json document: "+errDocument.Error())
+	}
+
+	//a typed field, like a quantity, may be declared as a json object
+	for _, field := range schema.Required {
+		value, isString := fields[field].(string)
+		if fields[field] == nil || (isString && len(value) == 0) {
+			return NewFieldError(CodeInvalidArgument, field, field+" must be declare")
+		}
+	}
+
+	if validator, isValidator := document.(Validator); isValidator {
+		return validator.Validate()
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+	"github.com/xuansonha17031991/heathcare-chaincode/common/mockstub"
+)
+
+const drugDocument = `{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`
+
+func TestSchemaDecode(t *testing.T) {
+	stub := mockstub.NewMockStub("common", nil)
+	stub.Transient = mockstub.Document(drugDocument)
+
+	drug := &common.DrugInformation{}
+	errDrug := common.DrugInformationSchema.Decode(stub, []string{`{"id":"P1"}`}, drug)
+	if errDrug != nil {
+		t.Fatal(errDrug)
+	} else if drug.ID != "P1" || drug.PrescribedBy != "doctor" {
+		t.Fatalf("expecting decoded drug, got %+v", drug)
+	}
+
+	//positional routing arguments decode to the same document
+	positionalDrug := &common.DrugInformation{}
+	errDrug = common.DrugInformationSchema.Decode(stub, []string{"P1"}, positionalDrug)
+	if errDrug != nil {
+		t.Fatal(errDrug)
+	} else if *positionalDrug != *drug {
+		t.Fatalf("expecting %+v, got %+v", drug, positionalDrug)
+	}
+
+	stub.Transient = mockstub.Document(`{}`)
+	update := &common.DrugInformationUpdate{}
+	errUpdate := common.DrugInformationUpdateSchema.Decode(stub, []string{`{"id":"P1","location":"pharmacy","version":3}`}, update)
+	if errUpdate != nil {
+		t.Fatal(errUpdate)
+	} else if update.ID != "P1" || update.Location != "pharmacy" || update.ExpectedVersion != "3" {
+		t.Fatalf("expecting update of version 3 of P1 at pharmacy, got %+v", update)
+	}
+
+	//a numeric version may be repeated in the document
+	stub.Transient = mockstub.Document(`{"version":3}`)
+	update = &common.DrugInformationUpdate{}
+	errUpdate = common.DrugInformationUpdateSchema.Decode(stub, []string{`{"id":"P1","location":"pharmacy","version":3}`}, update)
+	if errUpdate != nil {
+		t.Fatal(errUpdate)
+	} else if update.ExpectedVersion != "3" {
+		t.Fatalf("expecting update of version 3, got %+v", update)
+	}
+	stub.Transient = mockstub.Document(`{"version":3}`)
+	update = &common.DrugInformationUpdate{}
+	errUpdate = common.DrugInformationUpdateSchema.Decode(stub, []string{`{"id":"P1","location":"pharmacy","version":"3"}`}, update)
+	if errUpdate != nil {
+		t.Fatal(errUpdate)
+	}
+	stub.Transient = mockstub.Document(`{"version":4}`)
+	errUpdate = common.DrugInformationUpdateSchema.Decode(stub, []string{`{"id":"P1","location":"pharmacy","version":3}`}, &common.DrugInformationUpdate{})
+	if errResponse, isError := errUpdate.(*common.Error); !isError || errResponse.Field != "version" {
+		t.Fatalf("expecting versions of arguments and document to differ, got %v", errUpdate)
+	}
+
+	cases := []struct {
+		args     []string
+		document string
+		field    string
+	}{
+		{[]string{"P1"}, `{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor","dose":"1"}`, "dose"},
+		{[]string{"P1"}, `{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10 tablet"}`, "prescribed_by"},
+		{[]string{"P1"}, `{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":10,"prescribed_by":"doctor"}`, "quantity"},
+		{[]string{"P1"}, `{"id":"P2","patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`, "id"},
+		{[]string{"P1"}, `{"patient_name":`, common.TransientDocumentKey},
+		{[]string{"P1"}, "", common.TransientDocumentKey},
+		{[]string{`{"id":"P1",`}, drugDocument, ""},
+		{[]string{`{"id":"P1","drug_name":"aspirin"}`}, drugDocument, "drug_name"},
+		{[]string{"P1", "John", "aspirin", "2030-01-01", "10", "doctor"}, drugDocument, "patient_name"},
+		{[]string{""}, drugDocument, "id"},
+		{[]string{}, drugDocument, ""},
+	}
+	for _, testCase := range cases {
+		stub.Transient = mockstub.Document(testCase.document)
+		errDrug = common.DrugInformationSchema.Decode(stub, testCase.args, &common.DrugInformation{})
+		errResponse, isError := errDrug.(*common.Error)
+		if !isError || errResponse.Code != common.CodeInvalidArgument || errResponse.Field != testCase.field {
+			t.Fatalf("%v %s: expecting invalid argument %q, got %v", testCase.args, testCase.document, testCase.field, errDrug)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package common
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// key of the transient map holding the selector of a search, so the searched values are not recorded in the block
+const TransientSelectorKey = "selector"
+
+/**
+ * fields a role may search on in each collection, every one of them has a couchdb index
+ * under META-INF/statedb/couchdb/collections; a record is read by id with query,
+ * and the free text of medical records is never searched
+ */
+var SearchFields = map[string]map[string][]string{
+	RoleClinician: {
+		PatientInformationCollection: {"insurance_card", "make_note_of_appointment_date"},
+		DrugInformationCollection:    {"patient_name", "drug_name", "expiration_date", "prescribed_by"},
+	},
+	RoleNurse: {
+		PatientInformationCollection: {"insurance_card", "make_note_of_appointment_date"},
+		DrugInformationCollection:    {"patient_name", "drug_name"},
+	},
+	RolePharmacist: {
+		PatientInformationCollection: {"insurance_card"},
+		DrugInformationCollection:    {"patient_name", "drug_name", "expiration_date", "prescribed_by"},
+	},
+	RoleBilling: {
+		PatientInformationCollection: {"insurance_card"},
+		HospitalFeesCollection:       {"patient_name", "account", "date_of_service"},
+	},
+}
+
+// mango operators a selector may use, combination operators take selectors and condition operators take values
+var (
+	combinationOperators = map[string]bool{"$and": true, "$or": true, "$nor": true, "$not": true}
+	conditionOperators   = map[string]bool{"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true, "$in": true, "$nin": true, "$exists": true}
+)
+
+func IsSearchField(role string, collection string, field string) bool {
+	for _, searchField := range SearchFields[role][collection] {
+		if searchField == field {
+			return true
+		}
+	}
+	return false
+}
+
+//a field some role may search on has a couchdb index
+func isIndexedField(collection string, field string) bool {
+	for role := range SearchFields {
+		if IsSearchField(role, collection, field) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * check the selector against the roles of the user allowed to read the resource, not only the role it was authorized by
+ * the role of the identity is set to the first one that may search every field of the selector,
+ * so the access log records the role the search was granted by
+ */
+func CheckSearchRole(stub shim.ChaincodeStubInterface, identity *Identity, resource string, collection string, selector map[string]interface{}) error {
+	errSelector := CheckSelector(identity.Role, collection, selector)
+	if errSelector == nil {
+		return nil
+	}
+
+	user, errUser := GetUser(stub, identity.MSPID, identity.EnrollmentID)
+	if errUser != nil {
+		return errUser
+	} else if user == nil {
+		return errSelector
+	}
+	for _, role := range user.Roles {
+		if role == identity.Role || !IsAllowed(role, resource, ActionRead) {
+			continue
+		}
+		if CheckSelector(role, collection, selector) == nil {
+			identity.Role = role
+			return nil
+		}
+	}
+	return errSelector
+}
+
+/**
+ * check a mango selector only uses the operators above and fields the role may search on
+ * @param: selector, decoded json object
+ */
+func CheckSelector(role string, collection string, selector map[string]interface{}) error {
+	for field, value := range selector {
+		if combinationOperators[field] {
+			errOperator := checkCombination(role, collection, field, value)
+			if errOperator != nil {
+				return errOperator
+			}
+			continue
+		} else if !IsSearchField(role, collection, field) {
+			return NewFieldError(CodeForbidden, field, "role "+role+" cannot search "+collection+" on "+field)
+		}
+
+		//a condition is a value the field equals, or an object of condition operators
+		conditions, isConditions := value.(map[string]interface{})
+		if !isConditions {
+			conditions = map[string]interface{}{"$eq": value}
+		}
+		for operator, operand := range conditions {
+			errOperator := checkCondition(field, operator, operand)
+			if errOperator != nil {
+				return errOperator
+			}
+		}
+	}
+	return nil
+}
+
+func checkCombination(role string, collection string, operator string, operand interface{}) error {
+	if operator == "$not" {
+		selector, isSelector := operand.(map[string]interface{})
+		if !isSelector {
+			return NewFieldError(CodeInvalidArgument, TransientSelectorKey, operator+" must be a selector")
+		}
+		return CheckSelector(role, collection, selector)
+	}
+
+	selectors, isSelectors := operand.([]interface{})
+	if !isSelectors || len(selectors) == 0 {
+		return NewFieldError(CodeInvalidArgument, TransientSelectorKey, operator+" must be a list of selectors")
+	}
+	for _, item := range selectors {
+		selector, isSelector := item.(map[string]interface{})
+		if !isSelector {
+			return NewFieldError(CodeInvalidArgument, TransientSelectorKey, operator+" must be a list of selectors")
+		}
+		errSelector := CheckSelector(role, collection, selector)
+		if errSelector != nil {
+			return errSelector
+		}
+	}
+	return nil
+}
+
+func checkCondition(field string, operator string, operand interface{}) error {
+	if !conditionOperators[operator] {
+		return NewFieldError(CodeInvalidArgument, field, "operator "+operator+" is not allowed")
+	}
+
+	switch operator {
+	case "$exists":
+		if _, isBool := operand.(bool); !isBool {
+			return NewFieldError(CodeInvalidArgument, field, operator+" of "+field+" must be a boolean")
+		}
+	case "$in", "$nin":
+		values, isValues := operand.([]interface{})
+		if !isValues {
+			return NewFieldError(CodeInvalidArgument, field, operator+" of "+field+" must be a list of strings")
+		}
+		for _, value := range values {
+			if _, isString := value.(string); !isString {
+				return NewFieldError(CodeInvalidArgument, field, operator+" of "+field+" must be a list of strings")
+			}
+		}
+	default:
+		if _, isString := operand.(string); !isString {
+			return NewFieldError(CodeInvalidArgument, field, operator+" of "+field+" must be a string")
+		}
+	}
+	return nil
+}
+
+/**
+ * field a search is sorted on, the first indexed field the selector has a condition on at its top level
+ * so couchdb reads the index of this field instead of every record of the collection
+ * empty when the selector has none, the search is then sorted on id
+ */
+func SortField(collection string, selector map[string]interface{}) string {
+	fields := []string{}
+	for field, value := range selector {
+		if field != "$and" {
+			fields = append(fields, field)
+			continue
+		}
+		selectors, _ := value.([]interface{})
+		for _, item := range selectors {
+			conditions, _ := item.(map[string]interface{})
+			for condition := range conditions {
+				fields = append(fields, condition)
+			}
+		}
+	}
+
+	sort.Strings(fields)
+	for _, field := range fields {
+		if isIndexedField(collection, field) {
+			return field
+		}
+	}
+	return ""
+}
+
+/**
+ * build the couchdb query of a search, restricted to records of the collection after the bookmark
+ * couchdb returns the records in order of the sort field then of id, as the index of the field holds them,
+ * and no more than a page reads, so the chaincode pages them as it reads them
+ * @param: sortField, returned by SortField, the records are in order of id when empty
+ * @param: bookmark, id of the last record of the previous page, and bookmarkValue its value of the sort field
+ */
+func SearchQuery(objectType string, selector map[string]interface{}, sortField string, bookmark string, bookmarkValue string) ([]byte, error) {
+	conditions := []interface{}{map[string]interface{}{"docType": objectType}, selector}
+	order := []interface{}{map[string]interface{}{"_id": "asc"}}
+	if len(sortField) > 0 {
+		order = []interface{}{map[string]interface{}{"docType": "asc"}, map[string]interface{}{sortField: "asc"}}
+	}
+
+	if len(bookmark) > 0 && len(sortField) == 0 {
+		conditions = append(conditions, map[string]interface{}{"_id": map[string]interface{}{"$gt": bookmark}})
+	} else if len(bookmark) > 0 {
+		conditions = append(conditions, map[string]interface{}{"$or": []interface{}{
+			map[string]interface{}{sortField: map[string]interface{}{"$gt": bookmarkValue}},
+			map[string]interface{}{sortField: map[string]interface{}{"$eq": bookmarkValue}, "_id": map[string]interface{}{"$gt": bookmark}},
+		}})
+	}
+	return json.Marshal(map[string]interface{}{
+		"selector": map[string]interface{}{"$and": conditions},
+		"sort":     order,
+		"limit":    MaxPageSize + 1,
+	})
+}
+
+/**
+ * search the records of a collection with a mango selector, a page of DefaultPageSize records at a time
+ * only the fields of SearchFields for the role of the invoker may be searched,
+ * and only records of patients whose consent covers the invoker are returned
+ * rich queries are not re-executed when the transaction is validated, so a search is for reading only
+ * @param: collection
+ * @param: selector, json object, may be empty when the selector is passed in the transient map
+ * @param: bookmark, optional, returned by the previous page
+ * transient selector: json object, keeps the searched values out of the block
+ */
+func SearchRecords(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return ErrorResponse(NewError(CodeInvalidArgument, "expecting 2 or 3 argument"))
+	}
+
+	errArgs := CheckNotEmpty(args[:1])
+	if errArgs != nil {
+		return ErrorResponse(errArgs)
+	}
+
+	collection := args[0]
+	selectorAsByte := []byte(args[1])
+	bookmark := ""
+	if len(args) == 3 {
+		bookmark = args[2]
+	}
+
+	recordCollection, found := RecordCollections[collection]
+	if !found {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, "collection", "records of "+collection+" cannot be searched"))
+	}
+
+	transient, errTransient := stub.GetTransient()
+	if errTransient != nil {
+		return ErrorResponse(NewError(CodeInvalidArgument, "cannot get transient map: "+errTransient.Error()))
+	}
+	if transientSelector, found := transient[TransientSelectorKey]; found {
+		if len(selectorAsByte) > 0 {
+			return ErrorResponse(NewFieldError(CodeInvalidArgument, TransientSelectorKey, "selector must be passed in the arguments or the transient map, not both"))
+		}
+		selectorAsByte = transientSelector
+	}
+	if len(selectorAsByte) == 0 {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, TransientSelectorKey, "selector must be declare"))
+	}
+
+	selector := map[string]interface{}{}
+	errSelector := json.Unmarshal(selectorAsByte, &selector)
+	if errSelector != nil {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, TransientSelectorKey, "invalid json selector: "+errSelector.Error()))
+	} else if len(selector) == 0 {
+		return ErrorResponse(NewFieldError(CodeInvalidArgument, TransientSelectorKey, "selector must have at least one condition"))
+	}
+
+	//a search spans patients, so a patient cannot search and reads its own record with query
+	identity, errPermission := AuthorizeInvoker(stub, recordCollection.Resource, ActionRead, "")
+	if errPermission != nil {
+		return ErrorResponse(errPermission)
+	}
+
+	errSelector = CheckSearchRole(stub, identity, recordCollection.Resource, collection, selector)
+	if errSelector != nil {
+		return ErrorResponse(errSelector)
+	}
+
+	//the bookmark is an id, the search continues after the value of the sort field of its record
+	sortField := SortField(collection, selector)
+	bookmarkValue := ""
+	if len(bookmark) > 0 && len(sortField) > 0 {
+		bookmarkAsByte, errBookmark := stub.GetPrivateData(collection, bookmark)
+		if errBookmark != nil {
+			return ErrorResponse(&StorageError{collection, bookmark, errBookmark})
+		} else if bookmarkAsByte == nil {
+			return ErrorResponse(NewFieldError(CodeInvalidArgument, "bookmark", "record "+bookmark+" of the bookmark does not exist anymore, the search must restart"))
+		}
+		bookmarkRecord := map[string]interface{}{}
+		errBookmarkRecord := json.Unmarshal(bookmarkAsByte, &bookmarkRecord)
+		if errBookmarkRecord != nil {
+			return ErrorResponse(&CorruptRecordError{collection, bookmark, errBookmarkRecord})
+		}
+		bookmarkValue, _ = bookmarkRecord[sortField].(string)
+	}
+
+	query, errQuery := SearchQuery(recordCollection.ObjectType, selector, sortField, bookmark, bookmarkValue)
+	if errQuery != nil {
+		return ErrorResponse(errQuery)
+	}
+
+	resultIterator, errResultIterator := stub.GetPrivateDataQueryResult(collection, string(query))
+	if errResultIterator != nil {
+		return ErrorResponse(&StorageError{collection, bookmark, errResultIterator})
+	}
+	defer resultIterator.Close()
+
+	//the query starts after the bookmark, records are not in order of id so none is skipped by id
+	page, errPage := ReadPage(stub, identity, collection, resultIterator, DefaultPageSize, "")
+	if errPage != nil {
+		return ErrorResponse(errPage)
+	}
+
+	pageAsByte, errPageAsByte := json.Marshal(page)
+	if errPageAsByte != nil {
+		return ErrorResponse(errPageAsByte)
+	}
+	return shim.Success(pageAsByte)
+}
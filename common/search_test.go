@@ -0,0 +1,88 @@
+package common_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+)
+
+func TestCheckSelector(t *testing.T) {
+	cases := []struct {
+		selector string
+		code     string
+	}{
+		{`{"drug_name":"aspirin"}`, ""},
+		{`{"drug_name":{"$in":["aspirin","ibuprofen"]},"expiration_date":{"$lt":"2031-01-01"}}`, ""},
+		{`{"$or":[{"drug_name":"aspirin"},{"$not":{"patient_name":"John"}}]}`, ""},
+		{`{"$or":[{"drug_name":"aspirin"},{"quantity":"10"}]}`, common.CodeForbidden},
+		{`{"$not":{"docType":"Query"}}`, common.CodeForbidden},
+		{`{"_id":{"$gt":""}}`, common.CodeForbidden},
+		{`{"drug_name":{"$regex":"^a"}}`, common.CodeInvalidArgument},
+		{`{"drug_name":{"$in":"aspirin"}}`, common.CodeInvalidArgument},
+		{`{"drug_name":{"$exists":"yes"}}`, common.CodeInvalidArgument},
+		{`{"drug_name":{"$gt":{"$gt":""}}}`, common.CodeInvalidArgument},
+		{`{"$and":{"drug_name":"aspirin"}}`, common.CodeInvalidArgument},
+		{`{"$and":[]}`, common.CodeInvalidArgument},
+	}
+
+	for _, testCase := range cases {
+		selector := map[string]interface{}{}
+		errSelector := json.Unmarshal([]byte(testCase.selector), &selector)
+		if errSelector != nil {
+			t.Fatal(errSelector)
+		}
+
+		errSelector = common.CheckSelector(common.RolePharmacist, common.DrugInformationCollection, selector)
+		if len(testCase.code) == 0 && errSelector != nil {
+			t.Fatalf("%s: expecting valid selector, got %v", testCase.selector, errSelector)
+		} else if len(testCase.code) != 0 && (errSelector == nil || common.ToError(errSelector).Code != testCase.code) {
+			t.Fatalf("%s: expecting error code %s, got %v", testCase.selector, testCase.code, errSelector)
+		}
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	query, errQuery := common.SearchQuery(common.ObjectTypeDrugInformation, map[string]interface{}{"quantity": "10 tablet"}, "", "P1", "")
+	if errQuery != nil {
+		t.Fatal(errQuery)
+	}
+
+	expected := `{"limit":101,"selector":{"$and":[{"docType":"DrugInformation"},{"quantity":"10 tablet"},{"_id":{"$gt":"P1"}}]},"sort":[{"_id":"asc"}]}`
+	if string(query) != expected {
+		t.Fatalf("expecting %s, got %s", expected, query)
+	}
+
+	//a search on an indexed field is sorted on it, the bookmark is the value and the id of the last record
+	query, errQuery = common.SearchQuery(common.ObjectTypeDrugInformation, map[string]interface{}{"drug_name": "aspirin"}, "drug_name", "P1", "aspirin")
+	if errQuery != nil {
+		t.Fatal(errQuery)
+	}
+
+	expected = `{"limit":101,"selector":{"$and":[{"docType":"DrugInformation"},{"drug_name":"aspirin"},{"$or":[{"drug_name":{"$gt":"aspirin"}},{"_id":{"$gt":"P1"},"drug_name":{"$eq":"aspirin"}}]}]},"sort":[{"docType":"asc"},{"drug_name":"asc"}]}`
+	if string(query) != expected {
+		t.Fatalf("expecting %s, got %s", expected, query)
+	}
+}
+
+func TestSortField(t *testing.T) {
+	for _, testCase := range []struct {
+		selector string
+		field    string
+	}{
+		{`{"drug_name":"aspirin","patient_name":"John"}`, "drug_name"},
+		{`{"$and":[{"quantity":"10 tablet"},{"prescribed_by":"doctor"}]}`, "prescribed_by"},
+		{`{"$or":[{"drug_name":"aspirin"},{"patient_name":"John"}]}`, ""},
+		{`{"quantity":"10 tablet"}`, ""},
+	} {
+		selector := map[string]interface{}{}
+		errSelector := json.Unmarshal([]byte(testCase.selector), &selector)
+		if errSelector != nil {
+			t.Fatal(errSelector)
+		}
+		field := common.SortField(common.DrugInformationCollection, selector)
+		if field != testCase.field {
+			t.Fatalf("%s: expecting sort field %q, got %q", testCase.selector, testCase.field, field)
+		}
+	}
+}
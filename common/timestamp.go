@@ -0,0 +1,58 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// layout of time.Now().String(), used by access log entries written before the transaction timestamp
+const legacyTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+//get time of the transaction from its header, the same on every endorsing peer
+func GetTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimestamp, errTxTimestamp := stub.GetTxTimestamp()
+	if errTxTimestamp != nil {
+		return time.Time{}, errors.New("cannot get transaction timestamp: " + errTxTimestamp.Error())
+	} else if txTimestamp == nil {
+		return time.Time{}, errors.New("transaction does not have a timestamp")
+	}
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
+
+//get time of the transaction as RFC 3339 UTC, the format of every timestamp the chaincode stores
+func GetTxTimestamp(stub shim.ChaincodeStubInterface) (string, error) {
+	txTime, errTxTime := GetTxTime(stub)
+	if errTxTime != nil {
+		return "", errTxTime
+	}
+	return FormatTimestamp(txTime), nil
+}
+
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+/**
+ * parse a stored timestamp
+ * accepts RFC 3339 and, for entries written before, the time.Now().String() format
+ * with or without its monotonic clock reading
+ */
+func ParseTimestamp(value string) (time.Time, error) {
+	parsedTime, errParsedTime := time.Parse(time.RFC3339Nano, value)
+	if errParsedTime == nil {
+		return parsedTime.UTC(), nil
+	}
+
+	legacyValue := value
+	if monotonicIndex := strings.Index(legacyValue, " m="); monotonicIndex >= 0 {
+		legacyValue = legacyValue[:monotonicIndex]
+	}
+	parsedTime, errParsedTime = time.Parse(legacyTimeLayout, legacyValue)
+	if errParsedTime != nil {
+		return time.Time{}, errors.New("invalid timestamp " + value)
+	}
+	return parsedTime.UTC(), nil
+}
@@ -0,0 +1,282 @@
+package common
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// layout of the ISO 8601 dates of records, like 2030-01-31
+const DateLayout = "2006-01-02"
+
+// fields of records holding a Quantity or Money, which a document may declare as a json object
+var TypedFields = map[string]bool{
+	"quantity":   true,
+	"amount_due": true,
+}
+
+/**
+ * Quantity of a drug, a whole number of units like 30 tablet
+ * a json document may also declare it as text, "30 tablet"
+ */
+type Quantity struct {
+	Value int64  `json:"value"`
+	Unit  string `json:"unit"`
+	//value written by an older release that could not be parsed, kept until the record is corrected
+	Text string `json:"text,omitempty"`
+}
+
+/**
+ * Money is a fixed-point amount with its ISO 4217 currency code, Amount is in hundredths of the currency
+ * so 125.50 USD is 12550; a json document may also declare it as text, "125.50 USD"
+ */
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	//value written by an older release that could not be parsed, kept until the record is corrected
+	Text string `json:"text,omitempty"`
+}
+
+/**
+ * parse the text of a quantity, a whole number followed by its unit
+ * @param: defaultUnit, unit of a number without one, empty when a unit is required
+ */
+func ParseQuantity(text string, defaultUnit string) (Quantity, error) {
+	tokens := strings.Fields(text)
+	if len(tokens) == 1 && len(defaultUnit) > 0 {
+		tokens = append(tokens, defaultUnit)
+	}
+	if len(tokens) != 2 {
+		return Quantity{}, NewError(CodeInvalidArgument, "quantity must be a whole number of units, like 30 tablet")
+	}
+
+	value, errValue := strconv.ParseInt(tokens[0], 10, 64)
+	if errValue != nil || value < 0 {
+		return Quantity{}, NewError(CodeInvalidArgument, "quantity must be a whole number of units, like 30 tablet")
+	}
+	return Quantity{Value: value, Unit: strings.ToLower(tokens[1])}, nil
+}
+
+func (quantity Quantity) String() string {
+	if len(quantity.Text) > 0 {
+		return quantity.Text
+	}
+	return strconv.FormatInt(quantity.Value, 10) + " " + quantity.Unit
+}
+
+func (quantity Quantity) IsZero() bool {
+	return quantity == Quantity{}
+}
+
+/**
+ * decode a quantity from its json object or its text
+ * records written by older releases hold text, which is kept in Text when it cannot be parsed
+ * so the record stays readable; Check rejects such a quantity in a new document
+ */
+func (quantity *Quantity) UnmarshalJSON(data []byte) error {
+	*quantity = Quantity{}
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		if len(strings.TrimSpace(text)) == 0 {
+			return nil
+		}
+		parsed, errParsed := ParseQuantity(text, "")
+		if errParsed != nil {
+			quantity.Text = text
+			return nil
+		}
+		*quantity = parsed
+		return nil
+	}
+
+	type quantityObject Quantity
+	object := quantityObject{}
+	if string(data) == "null" {
+		return nil
+	} else if json.Unmarshal(data, &object) != nil {
+		quantity.Text = string(data)
+		return nil
+	}
+	*quantity = Quantity(object)
+	return nil
+}
+
+//check a declared quantity has a unit and a whole number of it
+func (quantity Quantity) Check(field string) error {
+	if quantity.IsZero() {
+		return nil
+	} else if len(quantity.Text) > 0 || quantity.Value < 0 || len(strings.TrimSpace(quantity.Unit)) == 0 {
+		return NewFieldError(CodeInvalidArgument, field, field+" must be a whole number of units, like 30 tablet")
+	}
+	return nil
+}
+
+/**
+ * parse the text of an amount, a decimal number of at most 2 decimals and its currency code in either order
+ * @param: defaultCurrency, currency of an amount without one, empty when a currency is required
+ */
+func ParseMoney(text string, defaultCurrency string) (Money, error) {
+	errMoney := NewError(CodeInvalidArgument, "amount must be a number of at most 2 decimals and a currency code, like 125.50 USD")
+	tokens := strings.Fields(strings.Replace(text, ",", "", -1))
+	if len(tokens) == 1 && len(defaultCurrency) > 0 {
+		tokens = append(tokens, defaultCurrency)
+	}
+	if len(tokens) != 2 {
+		return Money{}, errMoney
+	}
+
+	amount, errAmount := parseCents(tokens[0])
+	currency := strings.ToUpper(tokens[1])
+	if errAmount != nil {
+		amount, errAmount = parseCents(tokens[1])
+		currency = strings.ToUpper(tokens[0])
+	}
+	if errAmount != nil || !isCurrency(currency) {
+		return Money{}, errMoney
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+//parse a decimal number of at most 2 decimals into hundredths
+func parseCents(text string) (int64, error) {
+	units, decimals := text, ""
+	if point := strings.Index(text, "."); point >= 0 {
+		units, decimals = text[:point], text[point+1:]
+	}
+	if len(decimals) > 2 || len(units) == 0 || strings.HasPrefix(units, "+") {
+		return 0, strconv.ErrSyntax
+	}
+	for len(decimals) < 2 {
+		decimals += "0"
+	}
+
+	negative := strings.HasPrefix(units, "-")
+	cents, errCents := strconv.ParseInt(strings.TrimPrefix(units, "-")+decimals, 10, 64)
+	if errCents != nil {
+		return 0, strconv.ErrSyntax
+	} else if negative {
+		cents = -cents
+	}
+	return cents, nil
+}
+
+func isCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, letter := range code {
+		if letter < 'A' || letter > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func (money Money) String() string {
+	if len(money.Text) > 0 {
+		return money.Text
+	}
+	amount := money.Amount
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	cents := strconv.FormatInt(amount%100, 10)
+	if len(cents) < 2 {
+		cents = "0" + cents
+	}
+	return sign + strconv.FormatInt(amount/100, 10) + "." + cents + " " + money.Currency
+}
+
+func (money Money) IsZero() bool {
+	return money == Money{}
+}
+
+/**
+ * decode an amount from its json object or its text
+ * records written by older releases hold text, which is kept in Text when it cannot be parsed
+ * so the record stays readable; Check rejects such an amount in a new document
+ */
+func (money *Money) UnmarshalJSON(data []byte) error {
+	*money = Money{}
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		if len(strings.TrimSpace(text)) == 0 {
+			return nil
+		}
+		parsed, errParsed := ParseMoney(text, "")
+		if errParsed != nil {
+			money.Text = text
+			return nil
+		}
+		*money = parsed
+		return nil
+	}
+
+	type moneyObject Money
+	object := moneyObject{}
+	if string(data) == "null" {
+		return nil
+	} else if json.Unmarshal(data, &object) != nil {
+		money.Text = string(data)
+		return nil
+	}
+	*money = Money(object)
+	return nil
+}
+
+//check a declared amount has a currency code
+func (money Money) Check(field string) error {
+	if money.IsZero() {
+		return nil
+	} else if len(money.Text) > 0 || !isCurrency(money.Currency) {
+		return NewFieldError(CodeInvalidArgument, field, field+" must be an amount and a currency code, like 125.50 USD")
+	}
+	return nil
+}
+
+//check a declared date is an ISO 8601 date, like 2030-01-31
+func CheckDate(field string, value string) error {
+	if len(value) == 0 {
+		return nil
+	}
+	_, errDate := time.Parse(DateLayout, value)
+	if errDate != nil {
+		return NewFieldError(CodeInvalidArgument, field, field+" must be an ISO 8601 date, like 2030-01-31")
+	}
+	return nil
+}
+
+//check a declared date is an ISO 8601 date or date and time, like 2030-01-31 or 2030-01-31T09:30:00+07:00
+func CheckDateTime(field string, value string) error {
+	if len(value) == 0 {
+		return nil
+	}
+	_, errDateTime := time.Parse(time.RFC3339, value)
+	if errDateTime != nil && CheckDate(field, value) != nil {
+		return NewFieldError(CodeInvalidArgument, field, field+" must be an ISO 8601 date or date and time, like 2030-01-31 or 2030-01-31T09:30:00+07:00")
+	}
+	return nil
+}
+
+// Validate checks the typed fields of a record, declared fields must be valid and empty ones are left to the schema
+func (patient *PatientInformation) Validate() error {
+	return CheckDateTime("make_note_of_appointment_date", patient.MakeNoteOfAppointmentDate)
+}
+
+func (drug *DrugInformation) Validate() error {
+	errDate := CheckDate("expiration_date", drug.ExpirationDate)
+	if errDate != nil {
+		return errDate
+	}
+	return drug.Quantity.Check("quantity")
+}
+
+func (hospitalFees *HospitalFees) Validate() error {
+	errDate := CheckDate("date_of_service", hospitalFees.DateOfService)
+	if errDate != nil {
+		return errDate
+	}
+	return hospitalFees.AmountDue.Check("amount_due")
+}
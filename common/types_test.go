@@ -0,0 +1,87 @@
+package common_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+)
+
+func TestParseQuantity(t *testing.T) {
+	cases := []struct {
+		text        string
+		defaultUnit string
+		quantity    common.Quantity
+		valid       bool
+	}{
+		{"30 tablet", "", common.Quantity{Value: 30, Unit: "tablet"}, true},
+		{" 5  ML ", "", common.Quantity{Value: 5, Unit: "ml"}, true},
+		{"30", "", common.Quantity{}, false},
+		{"30", "unit", common.Quantity{Value: 30, Unit: "unit"}, true},
+		{"-1 tablet", "", common.Quantity{}, false},
+		{"1.5 tablet", "", common.Quantity{}, false},
+		{"ten tablets", "", common.Quantity{}, false},
+	}
+	for _, testCase := range cases {
+		quantity, errQuantity := common.ParseQuantity(testCase.text, testCase.defaultUnit)
+		if (errQuantity == nil) != testCase.valid || quantity != testCase.quantity {
+			t.Fatalf("%q: expecting %+v valid %v, got %+v %v", testCase.text, testCase.quantity, testCase.valid, quantity, errQuantity)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		text            string
+		defaultCurrency string
+		money           common.Money
+		valid           bool
+	}{
+		{"125.50 USD", "", common.Money{Amount: 12550, Currency: "USD"}, true},
+		{"vnd 1,500,000", "", common.Money{Amount: 150000000, Currency: "VND"}, true},
+		{"-0.5 EUR", "", common.Money{Amount: -50, Currency: "EUR"}, true},
+		{"120", "", common.Money{}, false},
+		{"120", "USD", common.Money{Amount: 12000, Currency: "USD"}, true},
+		{"1.255 USD", "", common.Money{}, false},
+		{"12 dollars", "", common.Money{}, false},
+	}
+	for _, testCase := range cases {
+		money, errMoney := common.ParseMoney(testCase.text, testCase.defaultCurrency)
+		if (errMoney == nil) != testCase.valid || money != testCase.money {
+			t.Fatalf("%q: expecting %+v valid %v, got %+v %v", testCase.text, testCase.money, testCase.valid, money, errMoney)
+		}
+	}
+
+	if text := (common.Money{Amount: -5, Currency: "USD"}).String(); text != "-0.05 USD" {
+		t.Fatalf("expecting -0.05 USD, got %s", text)
+	}
+}
+
+func TestUnmarshalTypedFields(t *testing.T) {
+	drug := &common.DrugInformation{}
+	errDrug := json.Unmarshal([]byte(`{"docType":"DrugInformation","expiration_date":"2030-01-01","quantity":{"value":30,"unit":"tablet"}}`), drug)
+	if errDrug != nil {
+		t.Fatal(errDrug)
+	} else if drug.Quantity != (common.Quantity{Value: 30, Unit: "tablet"}) || drug.Validate() != nil {
+		t.Fatalf("expecting valid quantity of 30 tablet, got %+v", drug.Quantity)
+	}
+
+	//a value of an older release that cannot be parsed keeps the record readable, and is rejected in a new document
+	errDrug = json.Unmarshal([]byte(`{"docType":"DrugInformation","expiration_date":"2030-01-01","quantity":"a box"}`), drug)
+	if errDrug != nil {
+		t.Fatal(errDrug)
+	} else if drug.Quantity.Text != "a box" {
+		t.Fatalf("expecting text of quantity kept, got %+v", drug.Quantity)
+	} else if errValidate := drug.Validate(); errValidate == nil || common.ToError(errValidate).Field != "quantity" {
+		t.Fatalf("expecting invalid quantity, got %v", errValidate)
+	}
+
+	hospitalFees := &common.HospitalFees{DateOfService: "2020-02-30"}
+	if errValidate := hospitalFees.Validate(); errValidate == nil || common.ToError(errValidate).Field != "date_of_service" {
+		t.Fatalf("expecting invalid date_of_service, got %v", errValidate)
+	}
+	patient := &common.PatientInformation{MakeNoteOfAppointmentDate: "2030-01-31T09:30:00+07:00"}
+	if errValidate := patient.Validate(); errValidate != nil {
+		t.Fatal(errValidate)
+	}
+}
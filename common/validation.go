@@ -0,0 +1,32 @@
+package common
+
+import (
+	"strconv"
+)
+
+//check the number of arguments and that none of them is empty
+func CheckArgs(args []string, count int) error {
+	errArgs := CheckArgCount(args, count)
+	if errArgs != nil {
+		return errArgs
+	}
+	return CheckNotEmpty(args)
+}
+
+//check the number of arguments only, for functions where some arguments may be empty
+func CheckArgCount(args []string, count int) error {
+	if len(args) != count {
+		return NewError(CodeInvalidArgument, "expecting "+strconv.Itoa(count)+" argument")
+	}
+	return nil
+}
+
+//check that none of the arguments is empty, field of the error is the index of the empty argument
+func CheckNotEmpty(args []string) error {
+	for i := 0; i < len(args); i++ {
+		if len(args[i]) == 0 {
+			return NewFieldError(CodeInvalidArgument, "args["+strconv.Itoa(i)+"]", "argument "+strconv.Itoa(i+1)+" must be declare")
+		}
+	}
+	return nil
+}
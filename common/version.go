@@ -0,0 +1,53 @@
+package common
+
+import (
+	"strconv"
+)
+
+/**
+ * Versioned is embedded in every record, its version is 1 when the record is created
+ * and incremented on every modification so concurrent modifications are detected
+ * records written before versions were added have version 0
+ */
+type Versioned struct {
+	Version int64 `json:"version"`
+}
+
+// VersionedRecord is a record a modify or patch function checks the version of
+type VersionedRecord interface {
+	GetVersion() int64
+	CheckVersion(collection string, key string, expected string) error
+	NextVersion()
+}
+
+// VersionConflictError is returned when a record was modified since the invoker read it
+type VersionConflictError struct {
+	Collection string
+	Key        string
+	Version    int64
+	Expected   int64
+}
+
+func (err *VersionConflictError) Error() string {
+	return "record " + err.Key + " of " + err.Collection + " has version " + strconv.FormatInt(err.Version, 10) +
+		", expecting version " + strconv.FormatInt(err.Expected, 10)
+}
+
+func (versioned *Versioned) GetVersion() int64 {
+	return versioned.Version
+}
+
+//check the invoker read the current version of a record, expected is the version argument of a modify function
+func (versioned *Versioned) CheckVersion(collection string, key string, expected string) error {
+	expectedVersion, errExpectedVersion := strconv.ParseInt(expected, 10, 64)
+	if errExpectedVersion != nil || expectedVersion < 0 {
+		return NewFieldError(CodeInvalidArgument, "version", "version must be a number")
+	} else if expectedVersion != versioned.Version {
+		return &VersionConflictError{collection, key, versioned.Version, expectedVersion}
+	}
+	return nil
+}
+
+func (versioned *Versioned) NextVersion() {
+	versioned.Version++
+}
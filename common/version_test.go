@@ -0,0 +1,35 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+)
+
+func TestCheckVersion(t *testing.T) {
+	drug := &common.DrugInformation{Versioned: common.Versioned{Version: 2}}
+
+	errVersion := drug.CheckVersion(common.DrugInformationCollection, "P1", "2")
+	if errVersion != nil {
+		t.Fatal(errVersion)
+	}
+
+	errVersion = drug.CheckVersion(common.DrugInformationCollection, "P1", "1")
+	if _, isConflict := errVersion.(*common.VersionConflictError); !isConflict {
+		t.Fatalf("expecting version conflict error, got %v", errVersion)
+	} else if common.ToError(errVersion).Code != common.CodeConflict {
+		t.Fatalf("expecting conflict code, got %s", common.ToError(errVersion).Code)
+	}
+
+	for _, expected := range []string{"two", "-1", ""} {
+		errVersion = drug.CheckVersion(common.DrugInformationCollection, "P1", expected)
+		if errResponse, isError := errVersion.(*common.Error); !isError || errResponse.Code != common.CodeInvalidArgument {
+			t.Fatalf("%q: expecting invalid argument, got %v", expected, errVersion)
+		}
+	}
+
+	drug.NextVersion()
+	if drug.Version != 3 {
+		t.Fatalf("expecting version 3, got %d", drug.Version)
+	}
+}
@@ -0,0 +1,987 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+	"github.com/xuansonha17031991/heathcare-chaincode/common/mockstub"
+)
+
+var (
+	drugArgs     = []string{mockstub.PatientID}
+	drugDocument = mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`)
+)
+
+//transient document of a dispense of a prescription of P1, from the lot of itemid and lotNumber when given
+func dispenseDocument(prescriptionid string, lot ...string) map[string][]byte {
+	document := `{"id":"` + mockstub.PatientID + `","prescription_id":"` + prescriptionid + `"`
+	if len(lot) == 2 {
+		document += `,"item_id":"` + lot[0] + `","lot_number":"` + lot[1] + `"`
+	}
+	return mockstub.Document(document + `}`)
+}
+
+func newTestStub(t *testing.T) *mockstub.MockStub {
+	stub := mockstub.NewMockStub("drug_information", new(DrugInformation_Chainode))
+	errUsers := stub.RegisterUsers()
+	if errUsers != nil {
+		t.Fatal(errUsers)
+	}
+	return stub
+}
+
+func TestSharedFunctions(t *testing.T) {
+	newTestStub(t).Run(t, mockstub.SharedCases())
+}
+
+func TestCreateDrugInformation(t *testing.T) {
+	newTestStub(t).Run(t, []mockstub.Case{
+		{Name: "success", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "wrong arity", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "protected health information in args", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{mockstub.PatientID, "John", "aspirin", "2030-01-01", "10", "doctor"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{mockstub.PatientID}, Error: "drug_name must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John","drug_name":"","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "createDrugInformation", Args: drugArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: drugDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Billing, Function: "createDrugInformation", Args: drugArgs, Error: "is not allowed to modify DrugInformation", Code: common.CodeForbidden, Transient: drugDocument},
+		{Name: "record exists", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "record P1 already exists in DrugInformationCollection", Code: common.CodeConflict, Transient: drugDocument},
+
+		{Name: "json arguments", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{`{"id":"P2"}`}, Transient: drugDocument},
+		{Name: "json arguments with protected health information", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{`{"id":"P1","drug_name":"aspirin"}`}, Error: "drug_name is protected health information", Code: common.CodeInvalidArgument, Transient: drugDocument},
+		{Name: "document with id of another patient", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "id of arguments and document differ", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"id":"P2","patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+		{Name: "document unknown field", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "unknown field dose", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor","dose":"1"}`)},
+		{Name: "document wrong type", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "drug_name must be a string", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John","drug_name":10,"expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+		{Name: "quantity without unit", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "quantity must be a whole number of units", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10","prescribed_by":"doctor"}`)},
+		{Name: "quantity as number", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "quantity must be a whole number of units", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":10,"prescribed_by":"doctor"}`)},
+		{Name: "quantity as object", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{"P3"}, Transient: mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":{"value":10,"unit":"tablet"},"prescribed_by":"doctor"}`)},
+		{Name: "expiration date not ISO 8601", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "expiration_date must be an ISO 8601 date", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"01/01/2030","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+		{Name: "document docType", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "unknown field docType", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"docType":"MedicalRecord","patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+		{Name: "invalid document", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "invalid json document", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John"`)},
+	})
+}
+
+func TestQuery(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "does not exist", Code: common.CodeNotFound},
+		{Name: "create", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "success", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "ward"}},
+		{Name: "wrong arity", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID}, Error: "expecting 2 argument", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Patient, Function: "query", Args: []string{"", "ward"}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "unauthorized caller", Caller: mockstub.Billing, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+		{Name: "record of another patient", Caller: mockstub.Patient, Function: "query", Args: []string{"P2", "ward"}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+		{Name: "missing consent", Caller: mockstub.Clinician, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("query", mockstub.PatientID, "ward")
+	drug := &common.DrugInformation{}
+	errDrug := json.Unmarshal(response.Payload, drug)
+	if errDrug != nil {
+		t.Fatal(errDrug)
+	} else if drug.DrugName != "aspirin" {
+		t.Fatalf("expecting drug aspirin, got %q", drug.DrugName)
+	}
+}
+
+func TestConsent(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "create", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+	})
+
+	consentid, errConsent := stub.GrantConsent(mockstub.Clinician, common.ResourceDrugInformation, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "query with consent", Caller: mockstub.Clinician, Function: "query", Args: []string{mockstub.PatientID, "ward"}},
+		{Name: "revokeConsent", Caller: mockstub.Patient, Function: "revokeConsent", Args: []string{consentid}},
+		{Name: "query with revoked consent", Caller: mockstub.Clinician, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+}
+
+func TestModifyDrugData(t *testing.T) {
+	stub := newTestStub(t)
+	modifyArgs := []string{mockstub.PatientID, "pharmacy", "1"}
+	modifyDocument := mockstub.Document(`{"patient_name":"John","drug_name":"ibuprofen","expiration_date":"2031-01-01","quantity":"20 tablet","prescribed_by":"doctor"}`)
+	stub.Run(t, []mockstub.Case{
+		{Name: "wrong arity", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: []string{mockstub.PatientID, "ward"}, Error: "expecting 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "protected health information in args", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: []string{mockstub.PatientID, "pharmacy", "John", "ibuprofen", "2031-01-01", "20", "doctor"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: modifyArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: []string{mockstub.PatientID, "", "1"}, Error: "argument 2 must be declare", Code: common.CodeInvalidArgument, Transient: modifyDocument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "modifyDrugData", Args: modifyArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: modifyDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Nurse, Function: "modifyDrugData", Args: modifyArgs, Error: "is not allowed to modify DrugInformation", Code: common.CodeForbidden, Transient: modifyDocument},
+		{Name: "missing consent", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: modifyArgs, Error: "missing consent", Code: common.CodeForbidden, Transient: modifyDocument},
+	})
+
+	_, errConsent := stub.GrantConsent(mockstub.Pharmacist, common.ResourceDrugInformation, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: modifyArgs, Error: "does not exist", Code: common.CodeNotFound, Transient: modifyDocument},
+		{Name: "create", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "success", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: modifyArgs, Transient: modifyDocument},
+		{Name: "json arguments", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: []string{`{"id":"P1","location":"pharmacy","version":2}`}, Transient: modifyDocument},
+		{Name: "json arguments missing location", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: []string{`{"id":"P1"}`}, Error: "location must be declare", Code: common.CodeInvalidArgument, Transient: modifyDocument},
+		{Name: "document unknown field", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: modifyArgs, Error: "unknown field drug", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"drug":"ibuprofen"}`)},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("query", mockstub.PatientID, "ward")
+	drug := &common.DrugInformation{}
+	errDrug := json.Unmarshal(response.Payload, drug)
+	if errDrug != nil {
+		t.Fatal(errDrug)
+	} else if drug.DrugName != "ibuprofen" || drug.Quantity != (common.Quantity{Value: 20, Unit: "tablet"}) {
+		t.Fatalf("expecting modified drug, got %+v", drug)
+	}
+
+	stub.SetPrivateData(common.DrugInformationCollection, mockstub.PatientID, []byte(`{"docType":"DrugInformation",`))
+	stub.Run(t, []mockstub.Case{
+		{Name: "corrupt record", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: modifyArgs, Error: "is corrupt", Code: common.CodeCorruptRecord, Transient: modifyDocument},
+	})
+
+	stub.Errors[common.DrugInformationCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "storage failure", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: modifyArgs, Error: "cannot access record P1 of DrugInformationCollection: disk failure", Code: common.CodeStorage, Transient: modifyDocument},
+	})
+}
+
+func TestPatchDrugInformation(t *testing.T) {
+	stub := newTestStub(t)
+	patchArgs := []string{mockstub.PatientID, "pharmacy", "1"}
+	patchDocument := mockstub.Document(`{"quantity":"5 tablet"}`)
+	stub.Run(t, []mockstub.Case{
+		{Name: "wrong arity", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: []string{mockstub.PatientID, "ward"}, Error: "expecting 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: patchArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty patch", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: patchArgs, Error: "patch must change at least one field", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{}`)},
+		{Name: "patch of id", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: patchArgs, Error: "id cannot be patched", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"id":"P2"}`)},
+		{Name: "unknown field", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: patchArgs, Error: "unknown field docType", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"docType":"Query"}`)},
+		{Name: "json arguments missing location", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: []string{`{"id":"P1"}`}, Error: "location must be declare", Code: common.CodeInvalidArgument, Transient: patchDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Nurse, Function: "patchDrugInformation", Args: patchArgs, Error: "is not allowed to modify DrugInformation", Code: common.CodeForbidden, Transient: patchDocument},
+		{Name: "missing consent", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: patchArgs, Error: "missing consent", Code: common.CodeForbidden, Transient: patchDocument},
+	})
+
+	_, errConsent := stub.GrantConsent(mockstub.Pharmacist, common.ResourceDrugInformation, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: patchArgs, Error: "does not exist", Code: common.CodeNotFound, Transient: patchDocument},
+		{Name: "create", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "success", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: patchArgs, Transient: patchDocument},
+		{Name: "json arguments", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: []string{`{"id":"P1","location":"pharmacy","version":2}`}, Transient: patchDocument},
+		{Name: "member of the quantity", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: []string{mockstub.PatientID, "pharmacy", "3"}, Transient: mockstub.Document(`{"quantity":{"unit":"box"}}`)},
+	})
+
+	//only the patched field changes, and only the patched member of an object
+	drug := &common.DrugInformation{}
+	errDrug := common.GetRecord(stub, common.DrugInformationCollection, mockstub.PatientID, common.ObjectTypeDrugInformation, drug)
+	if errDrug != nil {
+		t.Fatal(errDrug)
+	} else if drug.Quantity != (common.Quantity{Value: 5, Unit: "box"}) || drug.DrugName != "aspirin" {
+		t.Fatalf("expecting patched quantity, got %+v", drug)
+	}
+}
+
+func TestListDrugInformation(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "empty collection", Caller: mockstub.Pharmacist, Function: "listDrugInformation", Args: []string{}},
+		{Name: "create", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "create P2", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{"P2"}, Transient: drugDocument},
+		{Name: "create P3", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{"P3"}, Transient: drugDocument},
+		{Name: "register P3", Caller: mockstub.Admin, Function: "registerUser", Args: []string{mockstub.MSPID, "patient3", common.RolePatient, "P3"}},
+		{Name: "consent of P3", Caller: "patient3", Function: "grantConsent", Args: []string{mockstub.UserID(mockstub.Pharmacist), common.ResourceDrugInformation, common.PurposeQuery, mockstub.Expiry}},
+		{Name: "wrong arity", Caller: mockstub.Pharmacist, Function: "listDrugInformation", Args: []string{"1", "P1", "P2"}, Error: "expecting at most 2 argument", Code: common.CodeInvalidArgument},
+		{Name: "invalid page size", Caller: mockstub.Pharmacist, Function: "listDrugInformation", Args: []string{"ten"}, Error: "pageSize must be a number between 1 and 100", Code: common.CodeInvalidArgument},
+		{Name: "page size too large", Caller: mockstub.Pharmacist, Function: "listDrugInformation", Args: []string{"101"}, Error: "pageSize must be a number between 1 and 100", Code: common.CodeInvalidArgument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "listDrugInformation", Args: []string{}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "unauthorized caller", Caller: mockstub.Billing, Function: "listDrugInformation", Args: []string{}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+		{Name: "patient", Caller: mockstub.Patient, Function: "listDrugInformation", Args: []string{}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+	})
+
+	_, errConsent := stub.GrantConsent(mockstub.Pharmacist, common.ResourceDrugInformation, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	errPharmacist := stub.As(mockstub.Pharmacist)
+	if errPharmacist != nil {
+		t.Fatal(errPharmacist)
+	}
+
+	//P2 did not consent, its record is left out of every page
+	pages := [][]string{}
+	bookmark := ""
+	for {
+		response := stub.Invoke("listDrugInformation", "1", bookmark)
+		page := &struct {
+			Records  []*common.DrugInformation `json:"records"`
+			Bookmark string                    `json:"bookmark"`
+		}{}
+		errPage := json.Unmarshal(response.Payload, page)
+		if errPage != nil {
+			t.Fatalf("expecting page, got %d %s", response.Status, response.Message)
+		}
+
+		ids := []string{}
+		for _, drug := range page.Records {
+			ids = append(ids, drug.ID)
+		}
+		pages = append(pages, ids)
+
+		if len(page.Bookmark) == 0 || len(pages) > 3 {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+
+	if len(pages) != 2 || len(pages[0]) != 1 || pages[0][0] != "P1" || len(pages[1]) != 1 || pages[1][0] != "P3" {
+		t.Fatalf("expecting pages [[P1] [P3]], got %v", pages)
+	}
+
+	stub.Errors[common.DrugInformationCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "storage failure", Caller: mockstub.Pharmacist, Function: "listDrugInformation", Args: []string{}, Error: "of DrugInformationCollection: disk failure", Code: common.CodeStorage},
+	})
+}
+
+func TestSearchRecords(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "create", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "create P2", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{"P2"}, Transient: mockstub.Document(`{"patient_name":"Jane","drug_name":"ibuprofen","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+		{Name: "wrong arity", Caller: mockstub.Pharmacist, Function: "searchRecords", Args: []string{common.DrugInformationCollection}, Error: "expecting 2 or 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "unknown collection", Caller: mockstub.Pharmacist, Function: "searchRecords", Args: []string{common.QueryCollection, `{"userid":"x"}`}, Error: "records of queryCollection cannot be searched", Code: common.CodeInvalidArgument},
+		{Name: "missing selector", Caller: mockstub.Pharmacist, Function: "searchRecords", Args: []string{common.DrugInformationCollection, ""}, Error: "selector must be declare", Code: common.CodeInvalidArgument},
+		{Name: "selector in arguments and transient map", Caller: mockstub.Pharmacist, Function: "searchRecords", Args: []string{common.DrugInformationCollection, `{"drug_name":"aspirin"}`}, Error: "not both", Code: common.CodeInvalidArgument, Transient: map[string][]byte{common.TransientSelectorKey: []byte(`{"drug_name":"aspirin"}`)}},
+		{Name: "invalid selector", Caller: mockstub.Pharmacist, Function: "searchRecords", Args: []string{common.DrugInformationCollection, `{"drug_name"`}, Error: "invalid json selector", Code: common.CodeInvalidArgument},
+		{Name: "field not allowed", Caller: mockstub.Pharmacist, Function: "searchRecords", Args: []string{common.DrugInformationCollection, `{"quantity":"10 tablet"}`}, Error: "role pharmacist cannot search DrugInformationCollection on quantity", Code: common.CodeForbidden},
+		{Name: "field not allowed for role", Caller: mockstub.Nurse, Function: "searchRecords", Args: []string{common.DrugInformationCollection, `{"prescribed_by":"doctor"}`}, Error: "role nurse cannot search DrugInformationCollection on prescribed_by", Code: common.CodeForbidden},
+		{Name: "operator not allowed", Caller: mockstub.Pharmacist, Function: "searchRecords", Args: []string{common.DrugInformationCollection, `{"drug_name":{"$regex":"^a"}}`}, Error: "operator $regex is not allowed", Code: common.CodeInvalidArgument},
+		{Name: "unauthorized caller", Caller: mockstub.Billing, Function: "searchRecords", Args: []string{common.DrugInformationCollection, `{"drug_name":"aspirin"}`}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+		{Name: "patient", Caller: mockstub.Patient, Function: "searchRecords", Args: []string{common.DrugInformationCollection, `{"drug_name":"aspirin"}`}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+	})
+
+	_, errConsent := stub.GrantConsent(mockstub.Pharmacist, common.ResourceDrugInformation, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	errPharmacist := stub.As(mockstub.Pharmacist)
+	if errPharmacist != nil {
+		t.Fatal(errPharmacist)
+	}
+
+	//P2 did not consent, so only the record of P1 is found whatever the selector
+	for _, selector := range []string{`{"drug_name":"aspirin"}`, `{"$or":[{"drug_name":"aspirin"},{"patient_name":"Jane"}]}`, `{"expiration_date":{"$gte":"2030-01-01"}}`} {
+		stub.Transient = map[string][]byte{common.TransientSelectorKey: []byte(selector)}
+		response := stub.Invoke("searchRecords", common.DrugInformationCollection, "")
+		page := &struct {
+			Records  []*common.DrugInformation `json:"records"`
+			Bookmark string                    `json:"bookmark"`
+		}{}
+		errPage := json.Unmarshal(response.Payload, page)
+		if errPage != nil {
+			t.Fatalf("%s: expecting page, got %d %s", selector, response.Status, response.Message)
+		} else if len(page.Records) != 1 || page.Records[0].ID != mockstub.PatientID || len(page.Bookmark) != 0 {
+			t.Fatalf("%s: expecting record of P1, got %+v", selector, page)
+		}
+	}
+
+	response := stub.Invoke("searchRecords", common.DrugInformationCollection, `{"drug_name":"ibuprofen"}`)
+	if response.Status != 200 || string(response.Payload) != `{"records":[],"bookmark":""}` {
+		t.Fatalf("expecting empty page, got %d %s %s", response.Status, response.Message, response.Payload)
+	}
+
+	//a page scans at most MaxPageSize records, found records of patients who did not consent included
+	for i := 0; i < common.MaxPageSize+5; i++ {
+		key := "Q" + strconv.Itoa(1000+i)
+		stub.SetPrivateData(common.DrugInformationCollection, key, []byte(`{"docType":"DrugInformation","id":"`+key+`","drug_name":"ibuprofen"}`))
+	}
+	response = stub.Invoke("searchRecords", common.DrugInformationCollection, `{"drug_name":"ibuprofen"}`)
+	expected := `{"records":[],"bookmark":"Q` + strconv.Itoa(1000+common.MaxPageSize-2) + `"}`
+	if response.Status != 200 || string(response.Payload) != expected {
+		t.Fatalf("expecting %s, got %d %s %s", expected, response.Status, response.Message, response.Payload)
+	}
+	response = stub.Invoke("searchRecords", common.DrugInformationCollection, `{"drug_name":"ibuprofen"}`, "Q"+strconv.Itoa(1000+common.MaxPageSize-2))
+	if response.Status != 200 || string(response.Payload) != `{"records":[],"bookmark":""}` {
+		t.Fatalf("expecting last empty page, got %d %s %s", response.Status, response.Message, response.Payload)
+	}
+
+	//a search on an indexed field is read in order of this field, so the bookmark is not the greatest id scanned
+	for i := 0; i < common.MaxPageSize+5; i++ {
+		key := "R" + strconv.Itoa(1000+i)
+		stub.SetPrivateData(common.DrugInformationCollection, key, []byte(`{"docType":"DrugInformation","id":"`+key+`","drug_name":"naproxen `+strconv.Itoa(2000-i)+`"}`))
+	}
+	response = stub.Invoke("searchRecords", common.DrugInformationCollection, `{"drug_name":{"$gt":"naproxen"}}`)
+	expected = `{"records":[],"bookmark":"R1005"}`
+	if response.Status != 200 || string(response.Payload) != expected {
+		t.Fatalf("expecting %s, got %d %s %s", expected, response.Status, response.Message, response.Payload)
+	}
+	response = stub.Invoke("searchRecords", common.DrugInformationCollection, `{"drug_name":{"$gt":"naproxen"}}`, "R1005")
+	if response.Status != 200 || string(response.Payload) != `{"records":[],"bookmark":""}` {
+		t.Fatalf("expecting last empty page, got %d %s %s", response.Status, response.Message, response.Payload)
+	}
+
+	//the selector is checked against every role of the invoker, the access is logged with the role that may search its fields
+	_, errConsent = stub.GrantConsent(mockstub.Nurse, common.ResourceDrugInformation, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+	stub.Run(t, []mockstub.Case{
+		{Name: "assignRole", Caller: mockstub.Admin, Function: "assignRole", Args: []string{mockstub.MSPID, mockstub.Nurse, common.RolePharmacist}},
+		{Name: "search with the field of another role", Caller: mockstub.Nurse, Function: "searchRecords", Args: []string{common.DrugInformationCollection, `{"prescribed_by":"doctor"}`}},
+	})
+	queries, errQueries := common.GetAccessLogByUser(stub, common.QueryCollection, mockstub.UserID(mockstub.Nurse))
+	if errQueries != nil {
+		t.Fatal(errQueries)
+	} else if len(queries) != 1 || queries[0].PatientID != mockstub.PatientID || queries[0].Role != common.RolePharmacist {
+		t.Fatalf("expecting 1 access to P1 as pharmacist, got %+v", queries)
+	}
+
+	stub.Errors[common.DrugInformationCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "storage failure", Caller: mockstub.Pharmacist, Function: "searchRecords", Args: []string{common.DrugInformationCollection, `{"drug_name":"aspirin"}`}, Error: "of DrugInformationCollection: disk failure", Code: common.CodeStorage},
+	})
+}
+
+func TestIndexes(t *testing.T) {
+	stub := newTestStub(t)
+
+	//entry of the index of older releases, which holds every field of the record
+	legacyKey, errLegacyKey := stub.CreateCompositeKey("id~patient_name", []string{mockstub.PatientID, "John", "aspirin"})
+	if errLegacyKey != nil {
+		t.Fatal(errLegacyKey)
+	}
+	stub.SetPrivateData(common.DrugInformationCollection, legacyKey, []byte{0x00})
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "create", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "getDrugsByPatient", Caller: mockstub.Patient, Function: "getDrugsByPatient", Args: []string{mockstub.PatientID}},
+		{Name: "getDrugsByPatient wrong arity", Caller: mockstub.Patient, Function: "getDrugsByPatient", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "getDrugsByPatient of another patient", Caller: mockstub.Patient, Function: "getDrugsByPatient", Args: []string{"P2"}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+		{Name: "getDrugsByPatient missing consent", Caller: mockstub.Pharmacist, Function: "getDrugsByPatient", Args: []string{mockstub.PatientID}, Error: "missing consent", Code: common.CodeForbidden},
+		{Name: "getPatientsByPrescriber empty argument", Caller: mockstub.Pharmacist, Function: "getPatientsByPrescriber", Args: []string{""}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "getPatientsByPrescriber patient", Caller: mockstub.Patient, Function: "getPatientsByPrescriber", Args: []string{"doctor"}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+	})
+
+	if stub.PvtState[common.DrugInformationCollection][legacyKey] != nil {
+		t.Fatal("expecting entry of legacy index to be removed")
+	}
+
+	lookup := func(function string, value string) string {
+		errPharmacist := stub.As(mockstub.Pharmacist)
+		if errPharmacist != nil {
+			t.Fatal(errPharmacist)
+		}
+		response := stub.Invoke(function, value)
+		if response.Status != 200 {
+			t.Fatalf("%s: expecting success, got %d %s", function, response.Status, response.Message)
+		}
+		return string(response.Payload)
+	}
+
+	//every returned patient and every lookup of a patient is logged to the access log
+	countAccessLog := func() int {
+		prefix, errPrefix := stub.CreateCompositeKey("patientid~userid", []string{mockstub.PatientID, mockstub.UserID(mockstub.Pharmacist)})
+		if errPrefix != nil {
+			t.Fatal(errPrefix)
+		}
+		count := 0
+		for key := range stub.PvtState[common.QueryCollection] {
+			if strings.HasPrefix(key, prefix) {
+				count++
+			}
+		}
+		return count
+	}
+
+	//patients who did not consent are left out of a lookup
+	if patients := lookup("getPatientsByPrescriber", "doctor"); patients != `[]` {
+		t.Fatalf("expecting no patient without consent, got %s", patients)
+	} else if count := countAccessLog(); count != 0 {
+		t.Fatalf("expecting no access log of a patient left out, got %d", count)
+	}
+
+	_, errConsent := stub.GrantConsent(mockstub.Pharmacist, common.ResourceDrugInformation, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+	_, errConsent = stub.GrantConsent(mockstub.Pharmacist, common.ResourceDrugInformation, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	if patients := lookup("getPatientsByPrescriber", "doctor"); patients != `["P1"]` {
+		t.Fatalf("expecting patient P1, got %s", patients)
+	} else if count := countAccessLog(); count != 1 {
+		t.Fatalf("expecting access log of patient P1, got %d", count)
+	} else if drugs := lookup("getDrugsByPatient", mockstub.PatientID); drugs != `["aspirin"]` {
+		t.Fatalf("expecting drug aspirin, got %s", drugs)
+	} else if count := countAccessLog(); count != 2 {
+		t.Fatalf("expecting access log of drugs of patient P1, got %d", count)
+	}
+
+	//entries of the old values are replaced when the record is modified
+	stub.Run(t, []mockstub.Case{
+		{Name: "patch drug", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: []string{mockstub.PatientID, "pharmacy", "1"}, Transient: mockstub.Document(`{"drug_name":"ibuprofen"}`)},
+		{Name: "modify prescriber", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: []string{mockstub.PatientID, "pharmacy", "2"}, Transient: mockstub.Document(`{"patient_name":"John","drug_name":"ibuprofen","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"surgeon"}`)},
+	})
+
+	if drugs := lookup("getDrugsByPatient", mockstub.PatientID); drugs != `["ibuprofen"]` {
+		t.Fatalf("expecting drug ibuprofen, got %s", drugs)
+	} else if patients := lookup("getPatientsByPrescriber", "doctor"); patients != `[]` {
+		t.Fatalf("expecting no patient of old prescriber, got %s", patients)
+	} else if patients := lookup("getPatientsByPrescriber", "surgeon"); patients != `["P1"]` {
+		t.Fatalf("expecting patient P1 of new prescriber, got %s", patients)
+	}
+
+	//a cleared field has no entry
+	stub.Run(t, []mockstub.Case{
+		{Name: "clear prescriber", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: []string{mockstub.PatientID, "pharmacy", "3"}, Transient: mockstub.Document(`{"prescribed_by":null}`)},
+	})
+	if patients := lookup("getPatientsByPrescriber", "surgeon"); patients != `[]` {
+		t.Fatalf("expecting no patient of cleared prescriber, got %s", patients)
+	}
+
+	stub.Errors[common.DrugInformationCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "storage failure", Caller: mockstub.Pharmacist, Function: "getPatientsByPrescriber", Args: []string{"surgeon"}, Error: "cannot access record prescriber~patient of DrugInformationCollection: disk failure", Code: common.CodeStorage},
+	})
+}
+
+func TestPrescription(t *testing.T) {
+	stub := newTestStub(t)
+	prescriptionDocument := mockstub.Document(`{"drug_name":"amoxicillin","dosage":"500mg","frequency":"3 times a day","quantity":"30","refills":"1"}`)
+	stub.Run(t, []mockstub.Case{
+		{Name: "register pharmacist of another org", Caller: mockstub.Admin, Function: "registerUser", Args: []string{mockstub.MSPID, "pharmacist1", common.RolePharmacist}},
+		{Name: "missing consent", Caller: mockstub.Clinician, Function: "prescribe", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden, Transient: prescriptionDocument},
+	})
+
+	for _, enrollmentID := range []string{mockstub.Clinician, mockstub.Pharmacist, "pharmacist1"} {
+		_, errConsent := stub.GrantConsent(enrollmentID, common.ResourceDrugInformation, common.PurposeModify)
+		if errConsent != nil {
+			t.Fatal(errConsent)
+		}
+	}
+
+	prescribe := func() string {
+		errClinician := stub.As(mockstub.Clinician)
+		if errClinician != nil {
+			t.Fatal(errClinician)
+		}
+		stub.Transient = prescriptionDocument
+		response := stub.Invoke("prescribe", mockstub.PatientID, "ward")
+		if response.Status != 200 {
+			t.Fatalf("prescribe: expecting success, got %d %s", response.Status, response.Message)
+		}
+		return string(response.Payload)
+	}
+	prescriptionid := prescribe()
+	cancelledid := prescribe()
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "prescribe wrong arity", Caller: mockstub.Clinician, Function: "prescribe", Args: []string{mockstub.PatientID}, Error: "expecting 2 argument", Code: common.CodeInvalidArgument, Transient: prescriptionDocument},
+		{Name: "prescribe invalid quantity", Caller: mockstub.Clinician, Function: "prescribe", Args: []string{mockstub.PatientID, "ward"}, Error: "quantity must be a whole number of at least 1", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"drug_name":"amoxicillin","dosage":"500mg","frequency":"daily","quantity":"0","refills":"1"}`)},
+		{Name: "prescribe invalid refills", Caller: mockstub.Clinician, Function: "prescribe", Args: []string{mockstub.PatientID, "ward"}, Error: "refills must be a whole number of at least 0", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"drug_name":"amoxicillin","dosage":"500mg","frequency":"daily","quantity":"30","refills":"-1"}`)},
+		{Name: "prescribe by pharmacist", Caller: mockstub.Pharmacist, Function: "prescribe", Args: []string{mockstub.PatientID, "ward"}, Error: "is not allowed to prescribe DrugInformation", Code: common.CodeForbidden, Transient: prescriptionDocument},
+
+		{Name: "catalog item", Caller: mockstub.Pharmacist, Function: "putCatalogItem", Args: []string{"AMX500", "Amoxicillin", "capsule", "amoxicillin 500mg"}},
+		{Name: "receive stock", Caller: mockstub.Pharmacist, Function: "receiveStock", Args: []string{"AMX500", "L1", "Acme", "2100-01-01", "100"}},
+		{Name: "dispense by nurse", Caller: mockstub.Nurse, Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Error: "is not allowed to dispense DrugInformation", Code: common.CodeForbidden, Transient: dispenseDocument(prescriptionid)},
+		{Name: "dispense outside the pharmacies", Caller: "pharmacist1", Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Error: "is not a pharmacist of Org4MSP", Code: common.CodeForbidden, Transient: dispenseDocument(prescriptionid)},
+		{Name: "dispense wrong arity", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy"}, Error: "expecting 2 argument", Code: common.CodeInvalidArgument, Transient: dispenseDocument(prescriptionid)},
+		{Name: "dispense patient in arguments", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{mockstub.PatientID, prescriptionid, "10", "pharmacy"}, Error: "id is protected health information and must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "dispense without document", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "dispense without prescription", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Error: "prescription_id must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"id":"P1"}`)},
+		{Name: "dispense of an item without lot", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Error: "item_id and lot_number must be declare together", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"id":"P1","prescription_id":"` + prescriptionid + `","item_id":"AMX500"}`)},
+		{Name: "dispense invalid quantity", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "ten"}, Error: "quantity must be a whole number of at least 1", Code: common.CodeInvalidArgument, Transient: dispenseDocument(prescriptionid)},
+		{Name: "dispense missing prescription", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Error: "prescription unknown of patient P1 does not exist", Code: common.CodeNotFound, Transient: dispenseDocument("unknown")},
+		{Name: "partial dispense", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Transient: dispenseDocument(prescriptionid)},
+		{Name: "dispense over the fill", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "25"}, Error: "quantity exceeds the remaining quantity 20 of the fill", Code: common.CodeInvalidArgument, Transient: dispenseDocument(prescriptionid)},
+		{Name: "refill of a partial fill", Caller: mockstub.Pharmacist, Function: "refillPrescription", Args: []string{mockstub.PatientID, prescriptionid, "pharmacy"}, Error: "is not fully dispensed", Code: common.CodeConflict},
+		{Name: "dispense rest of the fill", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "20"}, Transient: dispenseDocument(prescriptionid)},
+		{Name: "dispense of a full fill", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "1"}, Error: "it must be refilled", Code: common.CodeConflict, Transient: dispenseDocument(prescriptionid)},
+		{Name: "refill by clinician", Caller: mockstub.Clinician, Function: "refillPrescription", Args: []string{mockstub.PatientID, prescriptionid, "ward"}, Error: "is not allowed to dispense DrugInformation", Code: common.CodeForbidden},
+		{Name: "refill", Caller: mockstub.Pharmacist, Function: "refillPrescription", Args: []string{mockstub.PatientID, prescriptionid, "pharmacy"}},
+		{Name: "dispense last fill", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "30"}, Transient: dispenseDocument(prescriptionid)},
+		{Name: "dispense of a completed prescription", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "1"}, Error: "is completed", Code: common.CodeConflict, Transient: dispenseDocument(prescriptionid)},
+		{Name: "refill of a completed prescription", Caller: mockstub.Pharmacist, Function: "refillPrescription", Args: []string{mockstub.PatientID, prescriptionid, "pharmacy"}, Error: "is completed", Code: common.CodeConflict},
+		{Name: "cancel of a completed prescription", Caller: mockstub.Clinician, Function: "cancelPrescription", Args: []string{mockstub.PatientID, prescriptionid, "ward"}, Error: "is completed", Code: common.CodeConflict},
+
+		{Name: "cancel by pharmacist", Caller: mockstub.Pharmacist, Function: "cancelPrescription", Args: []string{mockstub.PatientID, cancelledid, "pharmacy"}, Error: "is not allowed to prescribe DrugInformation", Code: common.CodeForbidden},
+		{Name: "cancel", Caller: mockstub.Clinician, Function: "cancelPrescription", Args: []string{mockstub.PatientID, cancelledid, "ward"}},
+		{Name: "cancel twice", Caller: mockstub.Clinician, Function: "cancelPrescription", Args: []string{mockstub.PatientID, cancelledid, "ward"}, Error: "is cancelled", Code: common.CodeConflict},
+		{Name: "dispense of a cancelled prescription", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "1"}, Error: "is cancelled", Code: common.CodeConflict, Transient: dispenseDocument(cancelledid)},
+
+		{Name: "listPrescriptions of another patient", Caller: mockstub.Patient, Function: "listPrescriptions", Args: []string{"P2", "home"}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("listPrescriptions", mockstub.PatientID, "home")
+	prescriptions := []*common.Prescription{}
+	errPrescriptions := json.Unmarshal(response.Payload, &prescriptions)
+	if errPrescriptions != nil {
+		t.Fatalf("expecting prescriptions, got %d %s", response.Status, response.Message)
+	} else if len(prescriptions) != 2 {
+		t.Fatalf("expecting 2 prescriptions, got %d", len(prescriptions))
+	}
+
+	for _, prescription := range prescriptions {
+		switch prescription.ID {
+		case prescriptionid:
+			if prescription.Status != common.PrescriptionCompleted || prescription.RefillsRemaining != 0 || len(prescription.Dispenses) != 3 {
+				t.Fatalf("expecting completed prescription with 3 dispenses, got %+v", prescription)
+			} else if prescription.Dispenses[2].Fill != 2 || prescription.Dispenses[2].Pharmacist != mockstub.UserID(mockstub.Pharmacist) || len(prescription.Dispenses[2].Lots) != 1 || prescription.Dispenses[2].Lots[0].LotNumber != "L1" {
+				t.Fatalf("expecting last dispense of fill 2 by the pharmacist from lot L1, got %+v", prescription.Dispenses[2])
+			} else if prescription.Prescriber != mockstub.UserID(mockstub.Clinician) || prescription.Dosage != "500mg" {
+				t.Fatalf("expecting prescription of the clinician, got %+v", prescription)
+			}
+		case cancelledid:
+			if prescription.Status != common.PrescriptionCancelled || len(prescription.Dispenses) != 0 {
+				t.Fatalf("expecting cancelled prescription, got %+v", prescription)
+			}
+		default:
+			t.Fatalf("unexpected prescription %s", prescription.ID)
+		}
+	}
+
+	//prescriptions are kept in their own collection, which is never purged, and not with the drug records
+	if count := len(stub.PvtState[common.PrescriptionCollection]); count != 2 {
+		t.Fatalf("expecting 2 prescriptions in %s, got %d", common.PrescriptionCollection, count)
+	}
+	for key := range stub.PvtState[common.DrugInformationCollection] {
+		if strings.Contains(key, "prescription") {
+			t.Fatalf("expecting no prescription in %s, got %q", common.DrugInformationCollection, key)
+		}
+	}
+
+	stub.Errors[common.PrescriptionCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "storage failure", Caller: mockstub.Patient, Function: "listPrescriptions", Args: []string{mockstub.PatientID, "home"}, Error: "cannot access record P1 of prescriptionCollection: disk failure", Code: common.CodeStorage},
+	})
+}
+
+func TestDrugSafety(t *testing.T) {
+	stub := newTestStub(t)
+	prescriptionDocument := func(drugName string) map[string][]byte {
+		return mockstub.Document(`{"drug_name":"` + drugName + `","dosage":"5mg","frequency":"daily","quantity":"30","refills":"0"}`)
+	}
+	overrideDocument := prescriptionDocument("Warfarin")
+	overrideDocument[common.TransientOverrideKey] = []byte("benefit outweighs the bleeding risk")
+	pharmacistOverride := mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`)
+	pharmacistOverride[common.TransientOverrideKey] = []byte("patient insists")
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "putInteraction by clinician", Caller: mockstub.Clinician, Function: "putInteraction", Args: []string{"aspirin", "warfarin", common.SeverityMajor, "bleeding"}, Error: "is not an admin", Code: common.CodeForbidden},
+		{Name: "putInteraction wrong arity", Caller: mockstub.Admin, Function: "putInteraction", Args: []string{"aspirin", "warfarin", common.SeverityMajor}, Error: "expecting 4 argument", Code: common.CodeInvalidArgument},
+		{Name: "putInteraction invalid severity", Caller: mockstub.Admin, Function: "putInteraction", Args: []string{"aspirin", "warfarin", "severe", "bleeding"}, Error: "severity must be", Code: common.CodeInvalidArgument},
+		{Name: "putInteraction with itself", Caller: mockstub.Admin, Function: "putInteraction", Args: []string{"aspirin", "Aspirin", common.SeverityMajor, "bleeding"}, Error: "cannot interact with itself", Code: common.CodeInvalidArgument},
+		{Name: "putInteraction", Caller: mockstub.Admin, Function: "putInteraction", Args: []string{"Warfarin", "aspirin", common.SeverityMajor, "bleeding"}},
+		{Name: "putInteraction to remove", Caller: mockstub.Admin, Function: "putInteraction", Args: []string{"ibuprofen", "aspirin", common.SeverityMinor, "less effect"}},
+		{Name: "removeInteraction", Caller: mockstub.Admin, Function: "removeInteraction", Args: []string{"aspirin", "ibuprofen"}},
+		{Name: "removeInteraction missing", Caller: mockstub.Admin, Function: "removeInteraction", Args: []string{"aspirin", "ibuprofen"}, Error: "does not exist", Code: common.CodeNotFound},
+		{Name: "putContraindication", Caller: mockstub.Admin, Function: "putContraindication", Args: []string{"penicillin", "amoxicillin", "cross reactivity"}},
+		{Name: "removeContraindication missing", Caller: mockstub.Admin, Function: "removeContraindication", Args: []string{"penicillin", "aspirin"}, Error: "does not exist", Code: common.CodeNotFound},
+		{Name: "addAllergy missing consent", Caller: mockstub.Clinician, Function: "addAllergy", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden, Transient: mockstub.Document(`{"allergen":"Penicillin","reaction":"rash"}`)},
+	})
+
+	for _, enrollmentID := range []string{mockstub.Clinician, mockstub.Pharmacist} {
+		_, errConsent := stub.GrantConsent(enrollmentID, common.ResourceDrugInformation, common.PurposeModify)
+		if errConsent != nil {
+			t.Fatal(errConsent)
+		}
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "addAllergy missing allergen", Caller: mockstub.Clinician, Function: "addAllergy", Args: []string{mockstub.PatientID, "ward"}, Error: "allergen must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"reaction":"rash"}`)},
+		{Name: "addAllergy", Caller: mockstub.Clinician, Function: "addAllergy", Args: []string{mockstub.PatientID, "ward"}, Transient: mockstub.Document(`{"allergen":"Penicillin","reaction":"rash"}`)},
+		{Name: "addAllergy to remove", Caller: mockstub.Clinician, Function: "addAllergy", Args: []string{mockstub.PatientID, "ward"}, Transient: mockstub.Document(`{"allergen":"latex","reaction":"hives"}`)},
+		{Name: "removeAllergy", Caller: mockstub.Clinician, Function: "removeAllergy", Args: []string{mockstub.PatientID, "ward"}, Transient: mockstub.Document(`{"allergen":"Latex"}`)},
+		{Name: "removeAllergy missing", Caller: mockstub.Clinician, Function: "removeAllergy", Args: []string{mockstub.PatientID, "ward"}, Error: "does not exist", Code: common.CodeNotFound, Transient: mockstub.Document(`{"allergen":"latex"}`)},
+
+		{Name: "create", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "prescribe interacting drug", Caller: mockstub.Clinician, Function: "prescribe", Args: []string{mockstub.PatientID, "ward"}, Error: "warfarin interacts with aspirin (major): bleeding", Code: common.CodeConflict, Transient: prescriptionDocument("Warfarin")},
+		{Name: "prescribe contraindicated drug", Caller: mockstub.Clinician, Function: "prescribe", Args: []string{mockstub.PatientID, "ward"}, Error: "amoxicillin is contraindicated by allergy to penicillin", Code: common.CodeConflict, Transient: prescriptionDocument("amoxicillin")},
+		{Name: "prescribe allergen", Caller: mockstub.Clinician, Function: "prescribe", Args: []string{mockstub.PatientID, "ward"}, Error: "patient is allergic to penicillin", Code: common.CodeConflict, Transient: prescriptionDocument("penicillin")},
+		{Name: "prescribe safe drug", Caller: mockstub.Clinician, Function: "prescribe", Args: []string{mockstub.PatientID, "ward"}, Transient: prescriptionDocument("metformin")},
+		{Name: "prescribe with override", Caller: mockstub.Clinician, Function: "prescribe", Args: []string{mockstub.PatientID, "ward"}, Transient: overrideDocument},
+
+		{Name: "create interacting with prescription", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "aspirin interacts with warfarin", Code: common.CodeConflict, Transient: drugDocument},
+		{Name: "override by pharmacist", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "is not a prescriber", Code: common.CodeForbidden, Transient: pharmacistOverride},
+		{Name: "patch to contraindicated drug", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: []string{mockstub.PatientID, "pharmacy", "1"}, Error: "contraindicated by allergy to penicillin", Code: common.CodeConflict, Transient: mockstub.Document(`{"drug_name":"Amoxicillin"}`)},
+		{Name: "patch of another field", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: []string{mockstub.PatientID, "pharmacy", "1"}, Transient: mockstub.Document(`{"quantity":"5 tablet"}`)},
+		{Name: "modify to contraindicated drug", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: []string{mockstub.PatientID, "pharmacy", "2"}, Error: "contraindicated by allergy to penicillin", Code: common.CodeConflict, Transient: mockstub.Document(`{"patient_name":"John","drug_name":"amoxicillin","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+
+		{Name: "listAllergies by billing", Caller: mockstub.Billing, Function: "listAllergies", Args: []string{mockstub.PatientID, "office"}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("listAllergies", mockstub.PatientID, "home")
+	allergies := []*common.Allergy{}
+	errAllergies := json.Unmarshal(response.Payload, &allergies)
+	if errAllergies != nil {
+		t.Fatalf("expecting allergies, got %d %s", response.Status, response.Message)
+	} else if len(allergies) != 1 || allergies[0].Allergen != "penicillin" || allergies[0].Reaction != "rash" {
+		t.Fatalf("expecting allergy to penicillin, got %+v", allergies)
+	}
+
+	//the override is kept on the prescription and in the modify log
+	response = stub.Invoke("listPrescriptions", mockstub.PatientID, "home")
+	prescriptions := []*common.Prescription{}
+	errPrescriptions := json.Unmarshal(response.Payload, &prescriptions)
+	if errPrescriptions != nil {
+		t.Fatalf("expecting prescriptions, got %d %s", response.Status, response.Message)
+	}
+	overrides := 0
+	for _, prescription := range prescriptions {
+		if prescription.Override == nil {
+			continue
+		}
+		overrides++
+		if prescription.DrugName != "Warfarin" || prescription.Override.Reason != "benefit outweighs the bleeding risk" || prescription.Override.By != mockstub.UserID(mockstub.Clinician) {
+			t.Fatalf("expecting override of warfarin by the clinician, got %+v", prescription.Override)
+		}
+	}
+	if len(prescriptions) != 2 || overrides != 1 {
+		t.Fatalf("expecting 2 prescriptions, 1 with an override, got %d and %d", len(prescriptions), overrides)
+	}
+
+	queries, errQueries := common.GetAccessLogByPatient(stub, common.ModifyCollection, mockstub.PatientID)
+	if errQueries != nil {
+		t.Fatal(errQueries)
+	}
+	overrides = 0
+	for _, query := range queries {
+		if query.Override != nil {
+			overrides++
+			if query.UserID != mockstub.UserID(mockstub.Clinician) || len(query.Override.Conflicts) != 1 {
+				t.Fatalf("expecting override of 1 conflict by the clinician, got %+v", query)
+			}
+		}
+	}
+	if overrides != 1 {
+		t.Fatalf("expecting 1 override in the modify log, got %d", overrides)
+	}
+
+	stub.Errors[common.AllergyCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "listAllergies storage failure", Caller: mockstub.Patient, Function: "listAllergies", Args: []string{mockstub.PatientID, "home"}, Error: "cannot access record P1 of allergyCollection: disk failure", Code: common.CodeStorage},
+		{Name: "prescribe storage failure", Caller: mockstub.Clinician, Function: "prescribe", Args: []string{mockstub.PatientID, "ward"}, Error: "cannot access record P1 of allergyCollection: disk failure", Code: common.CodeStorage, Transient: prescriptionDocument("metformin")},
+	})
+}
+
+func TestAllergyIsNotPurged(t *testing.T) {
+	stub := newTestStub(t)
+	errConfig := stub.LoadCollectionConfig("drugCollection.json")
+	if errConfig != nil {
+		t.Fatal(errConfig)
+	}
+	_, errConsent := stub.GrantConsent(mockstub.Clinician, common.ResourceDrugInformation, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+	prescriptionDocument := mockstub.Document(`{"drug_name":"amoxicillin","dosage":"5mg","frequency":"daily","quantity":"30","refills":"0"}`)
+
+	//the allergy and the drug information are written at block N
+	stub.Run(t, []mockstub.Case{
+		{Name: "putContraindication", Caller: mockstub.Admin, Function: "putContraindication", Args: []string{"penicillin", "amoxicillin", "cross reactivity"}},
+		{Name: "addAllergy", Caller: mockstub.Clinician, Function: "addAllergy", Args: []string{mockstub.PatientID, "ward"}, Transient: mockstub.Document(`{"allergen":"penicillin","reaction":"rash"}`)},
+		{Name: "create", Caller: mockstub.Clinician, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+	})
+
+	stub.AdvanceBlocks(stub.BlockToLive[common.DrugInformationCollection] + 1)
+	if stub.PvtState[common.DrugInformationCollection][mockstub.PatientID] != nil {
+		t.Fatal("expecting the drug information to be purged")
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "prescribe contraindicated drug after the purge", Caller: mockstub.Clinician, Function: "prescribe", Args: []string{mockstub.PatientID, "ward"}, Error: "amoxicillin is contraindicated by allergy to penicillin", Code: common.CodeConflict, Transient: prescriptionDocument},
+	})
+}
+
+func TestListExpiringDrugs(t *testing.T) {
+	stub := newTestStub(t)
+	stub.TxTime = time.Date(2030, 1, 1, 15, 0, 0, 0, time.UTC)
+	stub.Run(t, []mockstub.Case{
+		{Name: "empty collection", Caller: mockstub.Pharmacist, Function: "listExpiringDrugs", Args: []string{"2030-12-31"}},
+		{Name: "create", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-03-01","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+		{Name: "create P2", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{"P2"}, Transient: mockstub.Document(`{"patient_name":"Jane","drug_name":"ibuprofen","expiration_date":"2029-12-01","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+		{Name: "create P3", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{"P3"}, Transient: mockstub.Document(`{"patient_name":"Jack","drug_name":"paracetamol","expiration_date":"2030-01-11","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+		{Name: "register P3", Caller: mockstub.Admin, Function: "registerUser", Args: []string{mockstub.MSPID, "patient3", common.RolePatient, "P3"}},
+		{Name: "consent of P3", Caller: "patient3", Function: "grantConsent", Args: []string{mockstub.UserID(mockstub.Pharmacist), common.ResourceDrugInformation, common.PurposeQuery, mockstub.Expiry}},
+		{Name: "wrong arity", Caller: mockstub.Pharmacist, Function: "listExpiringDrugs", Args: []string{}, Error: "expecting 1 or 2 argument", Code: common.CodeInvalidArgument},
+		{Name: "date not ISO 8601", Caller: mockstub.Pharmacist, Function: "listExpiringDrugs", Args: []string{"31/12/2030"}, Error: "before must be an ISO 8601 date", Code: common.CodeInvalidArgument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "listExpiringDrugs", Args: []string{"2030-12-31"}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "unauthorized caller", Caller: mockstub.Billing, Function: "listExpiringDrugs", Args: []string{"2030-12-31"}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+		{Name: "patient", Caller: mockstub.Patient, Function: "listExpiringDrugs", Args: []string{"2030-12-31"}, Error: "is not allowed to read DrugInformation", Code: common.CodeForbidden},
+	})
+
+	//P2 did not consent, its record is left out
+	_, errConsent := stub.GrantConsent(mockstub.Pharmacist, common.ResourceDrugInformation, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	errPharmacist := stub.As(mockstub.Pharmacist)
+	if errPharmacist != nil {
+		t.Fatal(errPharmacist)
+	}
+
+	listExpiring := func(before string) []*common.ExpiringDrug {
+		response := stub.Invoke("listExpiringDrugs", before)
+		page := &struct {
+			Records  []*common.ExpiringDrug `json:"records"`
+			Bookmark string                 `json:"bookmark"`
+		}{}
+		errPage := json.Unmarshal(response.Payload, page)
+		if errPage != nil {
+			t.Fatalf("expecting page, got %d %s", response.Status, response.Message)
+		} else if len(page.Bookmark) > 0 {
+			t.Fatalf("expecting a single page, got bookmark %s", page.Bookmark)
+		}
+		return page.Records
+	}
+
+	drugs := listExpiring("2030-12-31")
+	if len(drugs) != 2 || drugs[0].ID != "P3" || drugs[1].ID != "P1" {
+		t.Fatalf("expecting drugs of P3 then P1, got %v", drugs)
+	}
+	if drugs[0].DrugName != "paracetamol" || drugs[0].ExpirationDate != "2030-01-11" || drugs[0].DaysUntilExpiry != 10 {
+		t.Fatalf("expecting paracetamol expiring in 10 days, got %+v", drugs[0])
+	}
+	if drugs[1].DaysUntilExpiry != 59 {
+		t.Fatalf("expecting aspirin expiring in 59 days, got %+v", drugs[1])
+	}
+
+	//drugs expiring on the date are not listed
+	drugs = listExpiring("2030-03-01")
+	if len(drugs) != 1 || drugs[0].ID != "P3" {
+		t.Fatalf("expecting drug of P3, got %v", drugs)
+	}
+
+	//a modified expiration date moves the drug in the index
+	_, errConsent = stub.GrantConsent(mockstub.Pharmacist, common.ResourceDrugInformation, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+	errPharmacist = stub.As(mockstub.Pharmacist)
+	if errPharmacist != nil {
+		t.Fatal(errPharmacist)
+	}
+	stub.Transient = mockstub.Document(`{"expiration_date":"2029-12-15"}`)
+	response := stub.Invoke("patchDrugInformation", mockstub.PatientID, "pharmacy", "1")
+	if response.Status != 200 {
+		t.Fatalf("expecting patch, got %d %s", response.Status, response.Message)
+	}
+	drugs = listExpiring("2030-03-01")
+	if len(drugs) != 2 || drugs[0].ID != "P1" || drugs[0].DaysUntilExpiry != -17 || drugs[1].ID != "P3" {
+		t.Fatalf("expecting drugs of P1 expired 17 days ago then P3, got %v", drugs)
+	}
+
+	//a page scans at most MaxPageSize entries, entries of patients who did not consent included
+	for i := 0; i < common.MaxPageSize+5; i++ {
+		entryKey, errEntryKey := stub.CreateCompositeKey(common.ExpirationIndex, []string{"2029-06-01", "Q" + strconv.Itoa(1000+i)})
+		if errEntryKey != nil {
+			t.Fatal(errEntryKey)
+		}
+		stub.SetPrivateData(common.DrugInformationCollection, entryKey, []byte{0x00})
+	}
+	bookmark, errBookmark := stub.CreateCompositeKey(common.ExpirationIndex, []string{"2029-06-01", "Q" + strconv.Itoa(1000+common.MaxPageSize-1)})
+	if errBookmark != nil {
+		t.Fatal(errBookmark)
+	}
+	response = stub.Invoke("listExpiringDrugs", "2030-12-31")
+	expected, _ := json.Marshal(&common.Page{Records: []interface{}{}, Bookmark: bookmark})
+	if response.Status != 200 || string(response.Payload) != string(expected) {
+		t.Fatalf("expecting %s, got %d %s %s", expected, response.Status, response.Message, response.Payload)
+	}
+	response = stub.Invoke("listExpiringDrugs", "2030-12-31", bookmark)
+	page := &struct {
+		Records  []*common.ExpiringDrug `json:"records"`
+		Bookmark string                 `json:"bookmark"`
+	}{}
+	errPage := json.Unmarshal(response.Payload, page)
+	if errPage != nil {
+		t.Fatalf("expecting page, got %d %s", response.Status, response.Message)
+	} else if len(page.Records) != 2 || page.Records[0].ID != "P1" || page.Records[1].ID != "P3" || len(page.Bookmark) != 0 {
+		t.Fatalf("expecting last page with drugs of P1 then P3, got %+v", page)
+	}
+
+	stub.Errors[common.DrugInformationCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "storage failure", Caller: mockstub.Pharmacist, Function: "listExpiringDrugs", Args: []string{"2030-12-31"}, Error: "cannot access record expirationDate~patient of DrugInformationCollection: disk failure", Code: common.CodeStorage},
+	})
+}
+
+func TestInventory(t *testing.T) {
+	stub := newTestStub(t)
+	stub.TxTime = time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+	stub.Run(t, []mockstub.Case{
+		{Name: "register pharmacist of another org", Caller: mockstub.Admin, Function: "registerUser", Args: []string{mockstub.MSPID, "pharmacist1", common.RolePharmacist}},
+		{Name: "catalog item wrong arity", Caller: mockstub.Pharmacist, Function: "putCatalogItem", Args: []string{"AMX500", "amoxicillin", "capsule"}, Error: "expecting 4 argument", Code: common.CodeInvalidArgument},
+		{Name: "catalog item unit of two words", Caller: mockstub.Pharmacist, Function: "putCatalogItem", Args: []string{"AMX500", "amoxicillin", "500mg capsule", ""}, Error: "unit must be a single word", Code: common.CodeInvalidArgument},
+		{Name: "catalog item by nurse", Caller: mockstub.Nurse, Function: "putCatalogItem", Args: []string{"AMX500", "amoxicillin", "capsule", ""}, Error: "is not allowed to modify Inventory", Code: common.CodeForbidden},
+		{Name: "catalog item outside the pharmacies", Caller: "pharmacist1", Function: "putCatalogItem", Args: []string{"AMX500", "amoxicillin", "capsule", ""}, Error: "is not a pharmacist of Org4MSP", Code: common.CodeForbidden},
+		{Name: "catalog item", Caller: mockstub.Pharmacist, Function: "putCatalogItem", Args: []string{"AMX500", "Amoxicillin", "capsule", "amoxicillin 500mg"}},
+		{Name: "catalog item of the same drug", Caller: mockstub.Pharmacist, Function: "putCatalogItem", Args: []string{"AMX250", "amoxicillin", "capsule", "amoxicillin 250mg"}},
+		{Name: "catalog item of another drug", Caller: mockstub.Pharmacist, Function: "putCatalogItem", Args: []string{"IBU200", "ibuprofen", "tablet", ""}},
+
+		{Name: "receive wrong arity", Caller: mockstub.Pharmacist, Function: "receiveStock", Args: []string{"AMX500", "L1", "Acme", "2030-06-01"}, Error: "expecting 5 argument", Code: common.CodeInvalidArgument},
+		{Name: "receive date not ISO 8601", Caller: mockstub.Pharmacist, Function: "receiveStock", Args: []string{"AMX500", "L1", "Acme", "06/01/2030", "50"}, Error: "expiration_date must be an ISO 8601 date", Code: common.CodeInvalidArgument},
+		{Name: "receive nothing", Caller: mockstub.Pharmacist, Function: "receiveStock", Args: []string{"AMX500", "L1", "Acme", "2030-06-01", "0"}, Error: "quantity must be a whole number of at least 1", Code: common.CodeInvalidArgument},
+		{Name: "receive unknown item", Caller: mockstub.Pharmacist, Function: "receiveStock", Args: []string{"AMX100", "L1", "Acme", "2030-06-01", "50"}, Error: "catalog item AMX100 does not exist", Code: common.CodeNotFound},
+		{Name: "receive expired lot", Caller: mockstub.Pharmacist, Function: "receiveStock", Args: []string{"AMX500", "L0", "Acme", "2029-12-31", "50"}, Error: "lot L0 expired on 2029-12-31", Code: common.CodeInvalidArgument},
+		{Name: "receive by clinician", Caller: mockstub.Clinician, Function: "receiveStock", Args: []string{"AMX500", "L1", "Acme", "2030-06-01", "50"}, Error: "is not allowed to modify Inventory", Code: common.CodeForbidden},
+		{Name: "receive", Caller: mockstub.Pharmacist, Function: "receiveStock", Args: []string{"AMX500", "L1", "Acme", "2030-06-01", "50"}},
+		{Name: "receive more of the lot", Caller: mockstub.Pharmacist, Function: "receiveStock", Args: []string{"AMX500", "L1", "Acme", "2030-06-01", "10"}},
+		{Name: "receive lot of another manufacturer", Caller: mockstub.Pharmacist, Function: "receiveStock", Args: []string{"AMX500", "L1", "Globex", "2030-06-01", "10"}, Error: "is of Acme and expires on 2030-06-01", Code: common.CodeConflict},
+		{Name: "receive lot expiring first", Caller: mockstub.Pharmacist, Function: "receiveStock", Args: []string{"AMX250", "L2", "Acme", "2030-03-01", "20"}},
+		{Name: "receive lot expiring today", Caller: mockstub.Pharmacist, Function: "receiveStock", Args: []string{"AMX500", "L3", "Acme", "2030-01-01", "5"}},
+
+		{Name: "adjust by nothing", Caller: mockstub.Pharmacist, Function: "adjustStock", Args: []string{"AMX500", "L1", "0", "count"}, Error: "quantity must be a whole number other than 0", Code: common.CodeInvalidArgument},
+		{Name: "adjust without reason", Caller: mockstub.Pharmacist, Function: "adjustStock", Args: []string{"AMX500", "L1", "-5", ""}, Error: "argument 4 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "adjust unknown lot", Caller: mockstub.Pharmacist, Function: "adjustStock", Args: []string{"AMX500", "L9", "-5", "damaged"}, Error: "lot L9 of catalog item AMX500 does not exist", Code: common.CodeNotFound},
+		{Name: "adjust below zero", Caller: mockstub.Pharmacist, Function: "adjustStock", Args: []string{"AMX500", "L1", "-61", "count"}, Error: "insufficient stock of lot L1 of catalog item AMX500, 60 remaining", Code: common.CodeConflict},
+		{Name: "adjust", Caller: mockstub.Pharmacist, Function: "adjustStock", Args: []string{"AMX500", "L1", "-5", "damaged"}},
+
+		{Name: "stock by billing", Caller: mockstub.Billing, Function: "getStock", Args: []string{"AMX500"}, Error: "is not allowed to read Inventory", Code: common.CodeForbidden},
+		{Name: "stock of unknown item", Caller: mockstub.Nurse, Function: "getStock", Args: []string{"AMX100"}, Error: "catalog item AMX100 does not exist", Code: common.CodeNotFound},
+		{Name: "movements wrong arity", Caller: mockstub.Nurse, Function: "listStockMovements", Args: []string{"AMX500"}, Error: "expecting 2 or 3 argument", Code: common.CodeInvalidArgument},
+	})
+
+	for _, enrollmentID := range []string{mockstub.Clinician, mockstub.Pharmacist} {
+		_, errConsent := stub.GrantConsent(enrollmentID, common.ResourceDrugInformation, common.PurposeModify)
+		if errConsent != nil {
+			t.Fatal(errConsent)
+		}
+	}
+	errClinician := stub.As(mockstub.Clinician)
+	if errClinician != nil {
+		t.Fatal(errClinician)
+	}
+	stub.Transient = mockstub.Document(`{"drug_name":"amoxicillin","dosage":"500mg","frequency":"3 times a day","quantity":"100","refills":"0"}`)
+	response := stub.Invoke("prescribe", mockstub.PatientID, "ward")
+	if response.Status != 200 {
+		t.Fatalf("prescribe: expecting success, got %d %s", response.Status, response.Message)
+	}
+	prescriptionid := string(response.Payload)
+
+	//the lot expiring today is too small, the rest is taken from the lot expiring first after it
+	stub.Run(t, []mockstub.Case{
+		{Name: "dispense of a lot too small", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Error: "insufficient stock of lot L3 of catalog item AMX500, 5 remaining", Code: common.CodeConflict, Transient: dispenseDocument(prescriptionid, "AMX500", "L3")},
+		{Name: "dispense of a lot of another drug", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Error: "catalog item IBU200 is not amoxicillin", Code: common.CodeInvalidArgument, Transient: dispenseDocument(prescriptionid, "IBU200", "L1")},
+		{Name: "dispense of an unknown lot", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Error: "lot L9 of catalog item AMX500 does not exist", Code: common.CodeNotFound, Transient: dispenseDocument(prescriptionid, "AMX500", "L9")},
+		{Name: "dispense", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Transient: dispenseDocument(prescriptionid)},
+	})
+
+	//L2 expired and L3 is empty
+	stub.TxTime = time.Date(2030, 3, 2, 9, 0, 0, 0, time.UTC)
+	stub.Run(t, []mockstub.Case{
+		{Name: "dispense of an expired lot", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "5"}, Error: "lot L2 of catalog item AMX250 expired on 2030-03-01", Code: common.CodeConflict, Transient: dispenseDocument(prescriptionid, "AMX250", "L2")},
+		{Name: "dispense past expiry", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "10"}, Transient: dispenseDocument(prescriptionid)},
+		{Name: "dispense over the stock", Caller: mockstub.Pharmacist, Function: "dispensePrescription", Args: []string{"pharmacy", "50"}, Error: "insufficient stock of amoxicillin, 45 remaining in lots not expired", Code: common.CodeConflict, Transient: dispenseDocument(prescriptionid)},
+	})
+
+	errNurse := stub.As(mockstub.Nurse)
+	if errNurse != nil {
+		t.Fatal(errNurse)
+	}
+	for itemid, expected := range map[string][2]int64{"AMX500": {45, 0}, "AMX250": {0, 15}} {
+		response = stub.Invoke("getStock", itemid)
+		stock := &common.StockLevel{}
+		errStock := json.Unmarshal(response.Payload, stock)
+		if errStock != nil {
+			t.Fatalf("expecting stock of %s, got %d %s", itemid, response.Status, response.Message)
+		} else if stock.Item.ID != itemid || stock.Item.DrugName != "amoxicillin" || stock.Available != expected[0] || stock.Expired != expected[1] {
+			t.Fatalf("expecting %d available and %d expired of %s, got %+v", expected[0], expected[1], itemid, stock)
+		}
+	}
+
+	response = stub.Invoke("listStockMovements", "AMX500", "L1")
+	page := &struct {
+		Records  []*common.StockMovement `json:"records"`
+		Bookmark string                  `json:"bookmark"`
+	}{}
+	errPage := json.Unmarshal(response.Payload, page)
+	if errPage != nil {
+		t.Fatalf("expecting movements, got %d %s", response.Status, response.Message)
+	}
+	movements := []string{}
+	for _, movement := range page.Records {
+		movements = append(movements, movement.Kind+" "+strconv.FormatInt(movement.Quantity, 10)+" "+strconv.FormatInt(movement.Balance, 10))
+	}
+	if strings.Join(movements, ", ") != "receipt 50 50, receipt 10 60, adjustment -5 55, dispense -10 45" {
+		t.Fatalf("expecting movements of lot L1, got %v", movements)
+	} else if page.Records[3].By != mockstub.UserID(mockstub.Pharmacist) || page.Records[2].Reason != "damaged" {
+		t.Fatalf("expecting dispense by the pharmacist and adjustment of damaged stock, got %+v %+v", page.Records[3], page.Records[2])
+	}
+
+	//a dispense spanning lots writes a movement per lot
+	for lot, expected := range map[[2]string]string{{"AMX500", "L3"}: "receipt 5 5, dispense -5 0", {"AMX250", "L2"}: "receipt 20 20, dispense -5 15"} {
+		response = stub.Invoke("listStockMovements", lot[0], lot[1])
+		errPage = json.Unmarshal(response.Payload, page)
+		if errPage != nil {
+			t.Fatalf("expecting movements, got %d %s", response.Status, response.Message)
+		}
+		movements = []string{}
+		for _, movement := range page.Records {
+			movements = append(movements, movement.Kind+" "+strconv.FormatInt(movement.Quantity, 10)+" "+strconv.FormatInt(movement.Balance, 10))
+		}
+		if strings.Join(movements, ", ") != expected {
+			t.Fatalf("expecting movements %s of lot %s, got %v", expected, lot[1], movements)
+		}
+	}
+
+	//the dispenses of the prescription name the lots they were taken from
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response = stub.Invoke("listPrescriptions", mockstub.PatientID, "home")
+	prescriptions := []*common.Prescription{}
+	errPrescriptions := json.Unmarshal(response.Payload, &prescriptions)
+	if errPrescriptions != nil || len(prescriptions) != 1 {
+		t.Fatalf("expecting prescription, got %d %s", response.Status, response.Message)
+	}
+	lots := []string{}
+	for _, dispense := range prescriptions[0].Dispenses {
+		for _, lot := range dispense.Lots {
+			lots = append(lots, strconv.FormatInt(dispense.Fill, 10)+" "+lot.ItemID+" "+lot.LotNumber+" "+strconv.FormatInt(lot.Quantity, 10))
+		}
+	}
+	if strings.Join(lots, ", ") != "1 AMX500 L3 5, 1 AMX250 L2 5, 1 AMX500 L1 10" {
+		t.Fatalf("expecting dispenses from lots L3 and L2 then L1, got %v", lots)
+	}
+
+	//the stock movements of the dispenses link neither the patient nor the prescription to the lots in world state
+	for key, value := range stub.State {
+		if strings.Contains(key, prescriptionid) || strings.Contains(string(value), prescriptionid) || strings.Contains(string(value), `"`+mockstub.PatientID+`"`) {
+			t.Fatalf("expecting no patient or prescription in world state, got %s", key)
+		}
+	}
+}
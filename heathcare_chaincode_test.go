@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+	"github.com/xuansonha17031991/heathcare-chaincode/common/mockstub"
+)
+
+var (
+	medicalRecordArgs     = []string{mockstub.PatientID}
+	medicalRecordDocument = mockstub.Document(`{"personal_identification":"passport 123","medical_history":"asthma","family_medical_history":"diabetes","medication_history":"salbutamol","treatment_history":"inhaler","medical_directives":"none"}`)
+	drugArgs              = []string{mockstub.PatientID}
+	drugDocument          = mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`)
+	patientArgs           = []string{mockstub.PatientID}
+	patientDocument       = mockstub.Document(`{"insurance_card":"INS-001","current_medication_information":"salbutamol","related_medical_records":"MR-001","make_note_of_appointment_date":"2030-01-01"}`)
+	hospitalFeesArgs      = []string{mockstub.PatientID}
+	hospitalFeesDocument  = mockstub.Document(`{"patient_name":"John","account":"ACC-001","date_of_service":"2020-01-01","patient_service":"x-ray","primary_insurance_billed":"100","secondary_insurance_billed":"0","pharmacy":"20","room":"101","amount_due":"120.00 USD"}`)
+)
+
+func newTestStub(t *testing.T) *mockstub.MockStub {
+	stub := mockstub.NewMockStub("heathcare_chaincode", new(HeathCare_Chaincode))
+	errUsers := stub.RegisterUsers()
+	if errUsers != nil {
+		t.Fatal(errUsers)
+	}
+	return stub
+}
+
+//number of entries of an access log returned by a history function
+func countAccessLog(t *testing.T, stub *mockstub.MockStub, caller string, function string, args ...string) int {
+	errCaller := stub.As(caller)
+	if errCaller != nil {
+		t.Fatal(errCaller)
+	}
+
+	response := stub.Invoke(function, args...)
+	queries := []*common.Query{}
+	errQueries := json.Unmarshal(response.Payload, &queries)
+	if errQueries != nil {
+		t.Fatalf("%s: %s %s", function, response.Message, errQueries)
+	}
+	return len(queries)
+}
+
+func TestSharedFunctions(t *testing.T) {
+	newTestStub(t).Run(t, mockstub.SharedCases())
+}
+
+func TestAdminBootstrap(t *testing.T) {
+	mockstub.NewMockStub("heathcare_chaincode", new(HeathCare_Chaincode)).Run(t, []mockstub.Case{
+		{Name: "registerUser by admin attribute of another msp", Caller: mockstub.ForeignAdmin, Function: "registerUser", Args: []string{mockstub.ForeignMSPID, mockstub.ForeignAdmin, common.RoleAdmin}, Error: "is not an admin", Code: common.CodeForbidden},
+		{Name: "registerUser first admin", Caller: mockstub.Admin, Function: "registerUser", Args: []string{mockstub.MSPID, mockstub.Admin, common.RoleAdmin}},
+		{Name: "registerUser by admin attribute once an admin is registered", Caller: mockstub.LateAdmin, Function: "registerUser", Args: []string{mockstub.MSPID, mockstub.LateAdmin, common.RoleAdmin}, Error: "is not an admin", Code: common.CodeForbidden},
+		{Name: "registerUser by registered admin", Caller: mockstub.Admin, Function: "registerUser", Args: []string{mockstub.MSPID, mockstub.Nurse, common.RoleNurse}},
+	})
+}
+
+func TestConsentPrivacy(t *testing.T) {
+	stub := newTestStub(t)
+	_, errConsent := stub.GrantConsent(mockstub.Clinician, common.ResourceMedicalRecord, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	//neither the registry nor the consents link the patient to a user in world state
+	for key, value := range stub.State {
+		if strings.Contains(key, mockstub.PatientID) || strings.Contains(string(value), `"`+mockstub.PatientID+`"`) {
+			t.Fatalf("expecting no patient id in world state, got %s", key)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	newTestStub(t).Run(t, []mockstub.Case{
+		{Name: "createMedicalRecord", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Transient: medicalRecordDocument},
+		{Name: "createMedicalRecord wrong arity", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "createMedicalRecord protected health information in args", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: []string{mockstub.PatientID, "passport 123", "asthma", "diabetes", "salbutamol", "inhaler", "none"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "createMedicalRecord missing document", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "createMedicalRecord empty argument", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: []string{""}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument, Transient: medicalRecordDocument},
+		{Name: "createMedicalRecord unregistered caller", Caller: mockstub.Stranger, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: medicalRecordDocument},
+		{Name: "createMedicalRecord unauthorized caller", Caller: mockstub.Pharmacist, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "is not allowed to modify MedicalRecord", Code: common.CodeForbidden, Transient: medicalRecordDocument},
+		{Name: "createMedicalRecord record exists", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "record P1 already exists in MedicalRecordCollection", Code: common.CodeConflict, Transient: medicalRecordDocument},
+
+		{Name: "createDrugInformation", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "createDrugInformation wrong arity", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "createDrugInformation protected health information in args", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{mockstub.PatientID, "John", "aspirin", "2030-01-01", "10", "doctor"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "createDrugInformation missing document", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "createDrugInformation empty argument", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{mockstub.PatientID}, Error: "expiration_date must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+		{Name: "createDrugInformation unregistered caller", Caller: mockstub.Stranger, Function: "createDrugInformation", Args: drugArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: drugDocument},
+		{Name: "createDrugInformation unauthorized caller", Caller: mockstub.Nurse, Function: "createDrugInformation", Args: drugArgs, Error: "is not allowed to modify DrugInformation", Code: common.CodeForbidden, Transient: drugDocument},
+		{Name: "createDrugInformation record exists", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "record P1 already exists in DrugInformationCollection", Code: common.CodeConflict, Transient: drugDocument},
+
+		{Name: "createPatientInformation", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "createPatientInformation wrong arity", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "createPatientInformation protected health information in args", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: []string{mockstub.PatientID, "INS-001", "salbutamol", "MR-001", "2030-01-01"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "createPatientInformation missing document", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "createPatientInformation empty argument", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: []string{mockstub.PatientID}, Error: "make_note_of_appointment_date must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"insurance_card":"INS-001","current_medication_information":"salbutamol","related_medical_records":"MR-001","make_note_of_appointment_date":""}`)},
+		{Name: "createPatientInformation unregistered caller", Caller: mockstub.Stranger, Function: "createPatientInformation", Args: patientArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: patientDocument},
+		{Name: "createPatientInformation unauthorized caller", Caller: mockstub.Billing, Function: "createPatientInformation", Args: patientArgs, Error: "is not allowed to modify PatientInformation", Code: common.CodeForbidden, Transient: patientDocument},
+		{Name: "createPatientInformation record exists", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Error: "record P1 already exists in PatientInformationCollection", Code: common.CodeConflict, Transient: patientDocument},
+
+		{Name: "createHospitalFees", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Transient: hospitalFeesDocument},
+		{Name: "createHospitalFees wrong arity", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "createHospitalFees protected health information in args", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{mockstub.PatientID, "John", "ACC-001", "2020-01-01", "x-ray", "100", "0", "20", "101", "120"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "createHospitalFees missing document", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "createHospitalFees empty argument", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{mockstub.PatientID}, Error: "patient_name must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"","account":"ACC-001","date_of_service":"2020-01-01","patient_service":"x-ray","primary_insurance_billed":"100","secondary_insurance_billed":"0","pharmacy":"20","room":"101","amount_due":"120.00 USD"}`)},
+		{Name: "createHospitalFees unregistered caller", Caller: mockstub.Stranger, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: hospitalFeesDocument},
+		{Name: "createHospitalFees unauthorized caller", Caller: mockstub.Clinician, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "is not allowed to modify HospitalFees", Code: common.CodeForbidden, Transient: hospitalFeesDocument},
+		{Name: "createHospitalFees record exists", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "record P1 already exists in HospitalFeesCollection", Code: common.CodeConflict, Transient: hospitalFeesDocument},
+	})
+}
+
+func TestQuery(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "does not exist", Code: common.CodeNotFound},
+		{Name: "create", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "success", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "ward"}},
+		{Name: "wrong arity", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID}, Error: "expecting 2 argument", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Patient, Function: "query", Args: []string{"", "ward"}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "record of another patient", Caller: mockstub.Patient, Function: "query", Args: []string{"P2", "ward"}, Error: "is not allowed to read PatientInformation", Code: common.CodeForbidden},
+		{Name: "missing consent", Caller: mockstub.Clinician, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+
+	consentid, errConsent := stub.GrantConsent(mockstub.Clinician, common.ResourcePatientInformation, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "query with consent", Caller: mockstub.Clinician, Function: "query", Args: []string{mockstub.PatientID, "ward"}},
+		{Name: "revokeConsent", Caller: mockstub.Patient, Function: "revokeConsent", Args: []string{consentid}},
+		{Name: "query with revoked consent", Caller: mockstub.Clinician, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+}
+
+func TestModifyData(t *testing.T) {
+	stub := newTestStub(t)
+	modifyArgs := []string{mockstub.PatientID, "ward", "1"}
+	modifyDocument := mockstub.Document(`{"insurance_card":"INS-002","current_medication_information":"salbutamol","related_medical_records":"MR-001","make_note_of_appointment_date":"2030-02-01"}`)
+	stub.Run(t, []mockstub.Case{
+		{Name: "wrong arity", Caller: mockstub.Nurse, Function: "modifyData", Args: []string{mockstub.PatientID, "ward"}, Error: "expecting 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "protected health information in args", Caller: mockstub.Nurse, Function: "modifyData", Args: []string{mockstub.PatientID, "ward", "INS-002", "salbutamol", "MR-001", "2030-02-01"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Nurse, Function: "modifyData", Args: []string{"", "ward", "1"}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument, Transient: modifyDocument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "modifyData", Args: modifyArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: modifyDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Pharmacist, Function: "modifyData", Args: modifyArgs, Error: "is not allowed to modify PatientInformation", Code: common.CodeForbidden, Transient: modifyDocument},
+		{Name: "missing consent", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Error: "missing consent", Code: common.CodeForbidden, Transient: modifyDocument},
+	})
+
+	_, errConsent := stub.GrantConsent(mockstub.Nurse, common.ResourcePatientInformation, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Error: "does not exist", Code: common.CodeNotFound, Transient: modifyDocument},
+		{Name: "create", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "success", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Transient: modifyDocument},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("query", mockstub.PatientID, "ward")
+	patient := &common.PatientInformation{}
+	errPatient = json.Unmarshal(response.Payload, patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	} else if patient.InsuranceCard != "INS-002" {
+		t.Fatalf("expecting modified insurance card, got %q", patient.InsuranceCard)
+	}
+
+	stub.SetPrivateData(common.PatientInformationCollection, mockstub.PatientID, []byte("not json"))
+	stub.Run(t, []mockstub.Case{
+		{Name: "corrupt record", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Error: "is corrupt", Code: common.CodeCorruptRecord, Transient: modifyDocument},
+	})
+
+	stub.Errors[common.PatientInformationCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "storage failure", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Error: "cannot access record P1 of PatientInformationCollection: disk failure", Code: common.CodeStorage, Transient: modifyDocument},
+	})
+}
+
+func TestPatch(t *testing.T) {
+	stub := newTestStub(t)
+	consents := []struct {
+		grantee  string
+		resource string
+	}{
+		{mockstub.Nurse, common.ResourcePatientInformation},
+		{mockstub.Clinician, common.ResourceMedicalRecord},
+		{mockstub.Pharmacist, common.ResourceDrugInformation},
+		{mockstub.Billing, common.ResourceHospitalFees},
+	}
+	for _, consent := range consents {
+		_, errConsent := stub.GrantConsent(consent.grantee, consent.resource, common.PurposeModify)
+		if errConsent != nil {
+			t.Fatal(errConsent)
+		}
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "createPatientInformation", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "createMedicalRecord", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Transient: medicalRecordDocument},
+		{Name: "createDrugInformation", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "createHospitalFees", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Transient: hospitalFeesDocument},
+
+		{Name: "patchPatientInformation", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: []string{mockstub.PatientID, "ward", "1"}, Transient: mockstub.Document(`{"make_note_of_appointment_date":"2030-02-01"}`)},
+		{Name: "patchMedicalRecord", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: []string{mockstub.PatientID, "ward", "1"}, Transient: mockstub.Document(`{"treatment_history":"inhaler, physiotherapy","medical_directives":null}`)},
+		{Name: "patchDrugInformation", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: []string{mockstub.PatientID, "pharmacy", "1"}, Transient: mockstub.Document(`{"quantity":"5 tablet"}`)},
+		{Name: "patchHospitalFees", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: []string{mockstub.PatientID, "billing office", "1"}, Transient: mockstub.Document(`{"amount_due":"80.00 USD"}`)},
+		{Name: "patchHospitalFees unauthorized caller", Caller: mockstub.Nurse, Function: "patchHospitalFees", Args: []string{mockstub.PatientID, "ward", "1"}, Error: "is not allowed to modify HospitalFees", Code: common.CodeForbidden, Transient: mockstub.Document(`{"amount_due":"0.00 USD"}`)},
+	})
+
+	patient := &common.PatientInformation{}
+	errPatient := common.GetRecord(stub, common.PatientInformationCollection, mockstub.PatientID, common.ObjectTypePatientInformation, patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	} else if patient.MakeNoteOfAppointmentDate != "2030-02-01" || patient.InsuranceCard != "INS-001" {
+		t.Fatalf("expecting patched appointment date, got %+v", patient)
+	}
+	medicalRecord := &common.MedicalRecord{}
+	errMedicalRecord := common.GetRecord(stub, common.MedicalRecordCollection, mockstub.PatientID, common.ObjectTypeMedicalRecord, medicalRecord)
+	if errMedicalRecord != nil {
+		t.Fatal(errMedicalRecord)
+	} else if medicalRecord.TreatmentHistory != "inhaler, physiotherapy" || medicalRecord.MedicalDirectives != "" || medicalRecord.MedicalHistory != "asthma" {
+		t.Fatalf("expecting patched treatment history and cleared medical directives, got %+v", medicalRecord)
+	}
+
+	//entry of the modify log lists the patched fields
+	errAdmin := stub.As(mockstub.Admin)
+	if errAdmin != nil {
+		t.Fatal(errAdmin)
+	}
+	response := stub.Invoke("historyModify", mockstub.UserID(mockstub.Clinician))
+	queries := []*common.Query{}
+	errQueries := json.Unmarshal(response.Payload, &queries)
+	if errQueries != nil {
+		t.Fatalf("historyModify: %s %s", response.Message, errQueries)
+	} else if len(queries) != 1 || strings.Join(queries[0].Fields, ",") != "medical_directives,treatment_history" {
+		t.Fatalf("expecting patched fields in the modify log, got %+v", queries)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "create", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "query", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "home"}},
+
+		{Name: "historyQuery", Caller: mockstub.Admin, Function: "historyQuery", Args: []string{mockstub.UserID(mockstub.Patient)}},
+		{Name: "historyQuery wrong arity", Caller: mockstub.Admin, Function: "historyQuery", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "historyQuery empty argument", Caller: mockstub.Admin, Function: "historyQuery", Args: []string{""}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "historyQuery unregistered caller", Caller: mockstub.Stranger, Function: "historyQuery", Args: []string{mockstub.UserID(mockstub.Patient)}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "historyQuery unauthorized caller", Caller: mockstub.Clinician, Function: "historyQuery", Args: []string{mockstub.UserID(mockstub.Patient)}, Error: "is not allowed to read Query", Code: common.CodeForbidden},
+
+		{Name: "historyModify", Caller: mockstub.Admin, Function: "historyModify", Args: []string{mockstub.UserID(mockstub.Nurse)}},
+		{Name: "historyModify wrong arity", Caller: mockstub.Admin, Function: "historyModify", Args: []string{mockstub.UserID(mockstub.Nurse), "now"}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "historyModify empty argument", Caller: mockstub.Admin, Function: "historyModify", Args: []string{""}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "historyModify unregistered caller", Caller: mockstub.Stranger, Function: "historyModify", Args: []string{mockstub.UserID(mockstub.Nurse)}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "historyModify unauthorized caller", Caller: mockstub.Nurse, Function: "historyModify", Args: []string{mockstub.UserID(mockstub.Nurse)}, Error: "is not allowed to read Query", Code: common.CodeForbidden},
+
+		{Name: "historyPatient", Caller: mockstub.Patient, Function: "historyPatient", Args: []string{mockstub.PatientID}},
+		{Name: "historyPatient wrong arity", Caller: mockstub.Patient, Function: "historyPatient", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "historyPatient empty argument", Caller: mockstub.Patient, Function: "historyPatient", Args: []string{""}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "historyPatient unregistered caller", Caller: mockstub.Stranger, Function: "historyPatient", Args: []string{mockstub.PatientID}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "historyPatient of another patient", Caller: mockstub.Patient, Function: "historyPatient", Args: []string{"P2"}, Error: "is not allowed to read Query", Code: common.CodeForbidden},
+	})
+
+	if count := countAccessLog(t, stub, mockstub.Admin, "historyQuery", mockstub.UserID(mockstub.Patient)); count != 1 {
+		t.Fatalf("expecting 1 query of patient, got %d", count)
+	}
+	if count := countAccessLog(t, stub, mockstub.Admin, "historyModify", mockstub.UserID(mockstub.Patient)); count != 0 {
+		t.Fatalf("expecting 0 modification of patient, got %d", count)
+	}
+	if count := countAccessLog(t, stub, mockstub.Patient, "historyPatient", mockstub.PatientID); count != 1 {
+		t.Fatalf("expecting 1 access to patient's records, got %d", count)
+	}
+
+	stub.Errors[common.QueryCollection] = errors.New("disk failure")
+	stub.Errors[common.ModifyCollection] = errors.New("disk failure")
+	stub.Errors[common.ConsentCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "historyQuery storage failure", Caller: mockstub.Admin, Function: "historyQuery", Args: []string{mockstub.UserID(mockstub.Patient)}, Error: "cannot get access log: disk failure", Code: common.CodeStorage},
+		{Name: "historyModify storage failure", Caller: mockstub.Admin, Function: "historyModify", Args: []string{mockstub.UserID(mockstub.Nurse)}, Error: "cannot get access log: disk failure", Code: common.CodeStorage},
+		{Name: "historyPatient storage failure", Caller: mockstub.Patient, Function: "historyPatient", Args: []string{mockstub.PatientID}, Error: "cannot get access log: disk failure", Code: common.CodeStorage},
+		{Name: "listConsents storage failure", Caller: mockstub.Patient, Function: "listConsents", Args: []string{}, Error: "cannot get consents: disk failure", Code: common.CodeStorage},
+	})
+}
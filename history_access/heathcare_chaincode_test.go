@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+	"github.com/xuansonha17031991/heathcare-chaincode/common/mockstub"
+)
+
+var (
+	medicalRecordArgs     = []string{mockstub.PatientID}
+	medicalRecordDocument = mockstub.Document(`{"personal_identification":"passport 123","medical_history":"asthma","family_medical_history":"diabetes","medication_history":"salbutamol","treatment_history":"inhaler","medical_directives":"none"}`)
+	drugArgs              = []string{mockstub.PatientID}
+	drugDocument          = mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"2030-01-01","quantity":"10 tablet","prescribed_by":"doctor"}`)
+	patientArgs           = []string{mockstub.PatientID}
+	patientDocument       = mockstub.Document(`{"insurance_card":"INS-001","current_medication_information":"salbutamol","related_medical_records":"MR-001","make_note_of_appointment_date":"2030-01-01"}`)
+	hospitalFeesArgs      = []string{mockstub.PatientID}
+	hospitalFeesDocument  = mockstub.Document(`{"patient_name":"John","account":"ACC-001","date_of_service":"2020-01-01","patient_service":"x-ray","primary_insurance_billed":"100","secondary_insurance_billed":"0","pharmacy":"20","room":"101","amount_due":"120.00 USD"}`)
+)
+
+func newTestStub(t *testing.T) *mockstub.MockStub {
+	stub := mockstub.NewMockStub("history_access", new(HeathCare_Chaincode))
+	errUsers := stub.RegisterUsers()
+	if errUsers != nil {
+		t.Fatal(errUsers)
+	}
+	return stub
+}
+
+//number of entries of an access log returned by a history function
+func countAccessLog(t *testing.T, stub *mockstub.MockStub, caller string, function string, args ...string) int {
+	errCaller := stub.As(caller)
+	if errCaller != nil {
+		t.Fatal(errCaller)
+	}
+
+	response := stub.Invoke(function, args...)
+	queries := []*common.Query{}
+	errQueries := json.Unmarshal(response.Payload, &queries)
+	if errQueries != nil {
+		t.Fatalf("%s: %s %s", function, response.Message, errQueries)
+	}
+	return len(queries)
+}
+
+func TestSharedFunctions(t *testing.T) {
+	newTestStub(t).Run(t, mockstub.SharedCases())
+}
+
+func TestCreate(t *testing.T) {
+	newTestStub(t).Run(t, []mockstub.Case{
+		{Name: "createMedicalRecord", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Transient: medicalRecordDocument},
+		{Name: "createMedicalRecord wrong arity", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "createMedicalRecord protected health information in args", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: []string{mockstub.PatientID, "passport 123", "asthma", "diabetes", "salbutamol", "inhaler", "none"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "createMedicalRecord missing document", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "createMedicalRecord empty argument", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: []string{""}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument, Transient: medicalRecordDocument},
+		{Name: "createMedicalRecord unregistered caller", Caller: mockstub.Stranger, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: medicalRecordDocument},
+		{Name: "createMedicalRecord unauthorized caller", Caller: mockstub.Pharmacist, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "is not allowed to modify MedicalRecord", Code: common.CodeForbidden, Transient: medicalRecordDocument},
+		{Name: "createMedicalRecord record exists", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "record P1 already exists in MedicalRecordCollection", Code: common.CodeConflict, Transient: medicalRecordDocument},
+
+		{Name: "createDrugInformation", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "createDrugInformation wrong arity", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "createDrugInformation protected health information in args", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{mockstub.PatientID, "John", "aspirin", "2030-01-01", "10", "doctor"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "createDrugInformation missing document", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "createDrugInformation empty argument", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: []string{mockstub.PatientID}, Error: "expiration_date must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John","drug_name":"aspirin","expiration_date":"","quantity":"10 tablet","prescribed_by":"doctor"}`)},
+		{Name: "createDrugInformation unregistered caller", Caller: mockstub.Stranger, Function: "createDrugInformation", Args: drugArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: drugDocument},
+		{Name: "createDrugInformation unauthorized caller", Caller: mockstub.Nurse, Function: "createDrugInformation", Args: drugArgs, Error: "is not allowed to modify DrugInformation", Code: common.CodeForbidden, Transient: drugDocument},
+		{Name: "createDrugInformation record exists", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Error: "record P1 already exists in DrugInformationCollection", Code: common.CodeConflict, Transient: drugDocument},
+
+		{Name: "createPatientInformation", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "createPatientInformation wrong arity", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "createPatientInformation protected health information in args", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: []string{mockstub.PatientID, "INS-001", "salbutamol", "MR-001", "2030-01-01"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "createPatientInformation missing document", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "createPatientInformation empty argument", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: []string{mockstub.PatientID}, Error: "make_note_of_appointment_date must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"insurance_card":"INS-001","current_medication_information":"salbutamol","related_medical_records":"MR-001","make_note_of_appointment_date":""}`)},
+		{Name: "createPatientInformation unregistered caller", Caller: mockstub.Stranger, Function: "createPatientInformation", Args: patientArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: patientDocument},
+		{Name: "createPatientInformation unauthorized caller", Caller: mockstub.Billing, Function: "createPatientInformation", Args: patientArgs, Error: "is not allowed to modify PatientInformation", Code: common.CodeForbidden, Transient: patientDocument},
+		{Name: "createPatientInformation record exists", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Error: "record P1 already exists in PatientInformationCollection", Code: common.CodeConflict, Transient: patientDocument},
+
+		{Name: "createHospitalFees", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Transient: hospitalFeesDocument},
+		{Name: "createHospitalFees wrong arity", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "createHospitalFees protected health information in args", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{mockstub.PatientID, "John", "ACC-001", "2020-01-01", "x-ray", "100", "0", "20", "101", "120"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "createHospitalFees missing document", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "createHospitalFees empty argument", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{mockstub.PatientID}, Error: "patient_name must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"","account":"ACC-001","date_of_service":"2020-01-01","patient_service":"x-ray","primary_insurance_billed":"100","secondary_insurance_billed":"0","pharmacy":"20","room":"101","amount_due":"120.00 USD"}`)},
+		{Name: "createHospitalFees unregistered caller", Caller: mockstub.Stranger, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: hospitalFeesDocument},
+		{Name: "createHospitalFees unauthorized caller", Caller: mockstub.Clinician, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "is not allowed to modify HospitalFees", Code: common.CodeForbidden, Transient: hospitalFeesDocument},
+		{Name: "createHospitalFees record exists", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "record P1 already exists in HospitalFeesCollection", Code: common.CodeConflict, Transient: hospitalFeesDocument},
+	})
+}
+
+func TestQuery(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "does not exist", Code: common.CodeNotFound},
+		{Name: "create", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "success", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "ward"}},
+		{Name: "wrong arity", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID}, Error: "expecting 2 argument", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Patient, Function: "query", Args: []string{"", "ward"}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "record of another patient", Caller: mockstub.Patient, Function: "query", Args: []string{"P2", "ward"}, Error: "is not allowed to read PatientInformation", Code: common.CodeForbidden},
+		{Name: "missing consent", Caller: mockstub.Clinician, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+
+	consentid, errConsent := stub.GrantConsent(mockstub.Clinician, common.ResourcePatientInformation, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "query with consent", Caller: mockstub.Clinician, Function: "query", Args: []string{mockstub.PatientID, "ward"}},
+		{Name: "revokeConsent", Caller: mockstub.Patient, Function: "revokeConsent", Args: []string{consentid}},
+		{Name: "query with revoked consent", Caller: mockstub.Clinician, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+}
+
+func TestModify(t *testing.T) {
+	stub := newTestStub(t)
+	drugModifyArgs := []string{mockstub.PatientID, "pharmacy", "1"}
+	drugModifyDocument := mockstub.Document(`{"patient_name":"John","drug_name":"ibuprofen","expiration_date":"2031-01-01","quantity":"20 tablet","prescribed_by":"doctor"}`)
+	medicalModifyArgs := []string{mockstub.PatientID, "ward", "1"}
+	medicalModifyDocument := mockstub.Document(`{"personal_identification":"passport 123","medical_history":"asthma","family_medical_history":"diabetes","medication_history":"salbutamol","treatment_history":"inhaler","medical_directives":"do not resuscitate"}`)
+	patientModifyArgs := []string{mockstub.PatientID, "ward", "1"}
+	patientModifyDocument := mockstub.Document(`{"insurance_card":"INS-002","current_medication_information":"salbutamol","related_medical_records":"MR-001","make_note_of_appointment_date":"2030-02-01"}`)
+	stub.Run(t, []mockstub.Case{
+		{Name: "modifyDrugData wrong arity", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: []string{mockstub.PatientID, "ward"}, Error: "expecting 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "modifyDrugData protected health information in args", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: []string{mockstub.PatientID, "pharmacy", "John", "ibuprofen", "2031-01-01", "20", "doctor"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "modifyDrugData missing document", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: drugModifyArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "modifyDrugData empty argument", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: []string{mockstub.PatientID, "", "1"}, Error: "argument 2 must be declare", Code: common.CodeInvalidArgument, Transient: drugModifyDocument},
+		{Name: "modifyDrugData unregistered caller", Caller: mockstub.Stranger, Function: "modifyDrugData", Args: drugModifyArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: drugModifyDocument},
+		{Name: "modifyDrugData unauthorized caller", Caller: mockstub.Nurse, Function: "modifyDrugData", Args: drugModifyArgs, Error: "is not allowed to modify DrugInformation", Code: common.CodeForbidden, Transient: drugModifyDocument},
+		{Name: "modifyDrugData missing consent", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: drugModifyArgs, Error: "missing consent", Code: common.CodeForbidden, Transient: drugModifyDocument},
+
+		{Name: "modifyMedicalData wrong arity", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: []string{mockstub.PatientID, "ward"}, Error: "expecting 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "modifyMedicalData protected health information in args", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: []string{mockstub.PatientID, "ward", "passport 123", "asthma", "diabetes", "salbutamol", "inhaler", "do not resuscitate"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "modifyMedicalData missing document", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: medicalModifyArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "modifyMedicalData empty argument", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: []string{"", "ward", "1"}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument, Transient: medicalModifyDocument},
+		{Name: "modifyMedicalData unregistered caller", Caller: mockstub.Stranger, Function: "modifyMedicalData", Args: medicalModifyArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: medicalModifyDocument},
+		{Name: "modifyMedicalData unauthorized caller", Caller: mockstub.Nurse, Function: "modifyMedicalData", Args: medicalModifyArgs, Error: "is not allowed to modify MedicalRecord", Code: common.CodeForbidden, Transient: medicalModifyDocument},
+		{Name: "modifyMedicalData missing consent", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: medicalModifyArgs, Error: "missing consent", Code: common.CodeForbidden, Transient: medicalModifyDocument},
+
+		{Name: "modifyPatientInformation wrong arity", Caller: mockstub.Nurse, Function: "modifyPatientInformation", Args: []string{mockstub.PatientID, "ward"}, Error: "expecting 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "modifyPatientInformation protected health information in args", Caller: mockstub.Nurse, Function: "modifyPatientInformation", Args: []string{mockstub.PatientID, "ward", "INS-002", "salbutamol", "MR-001", "2030-02-01"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "modifyPatientInformation missing document", Caller: mockstub.Nurse, Function: "modifyPatientInformation", Args: patientModifyArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "modifyPatientInformation empty argument", Caller: mockstub.Nurse, Function: "modifyPatientInformation", Args: []string{mockstub.PatientID, "", "1"}, Error: "argument 2 must be declare", Code: common.CodeInvalidArgument, Transient: patientModifyDocument},
+		{Name: "modifyPatientInformation unregistered caller", Caller: mockstub.Stranger, Function: "modifyPatientInformation", Args: patientModifyArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: patientModifyDocument},
+		{Name: "modifyPatientInformation unauthorized caller", Caller: mockstub.Billing, Function: "modifyPatientInformation", Args: patientModifyArgs, Error: "is not allowed to modify PatientInformation", Code: common.CodeForbidden, Transient: patientModifyDocument},
+		{Name: "modifyPatientInformation missing consent", Caller: mockstub.Nurse, Function: "modifyPatientInformation", Args: patientModifyArgs, Error: "missing consent", Code: common.CodeForbidden, Transient: patientModifyDocument},
+	})
+
+	consents := []struct {
+		grantee  string
+		resource string
+	}{
+		{mockstub.Pharmacist, common.ResourceDrugInformation},
+		{mockstub.Clinician, common.ResourceMedicalRecord},
+		{mockstub.Nurse, common.ResourcePatientInformation},
+	}
+	for _, consent := range consents {
+		_, errConsent := stub.GrantConsent(consent.grantee, consent.resource, common.PurposeModify)
+		if errConsent != nil {
+			t.Fatal(errConsent)
+		}
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "modifyDrugData missing record", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: drugModifyArgs, Error: "does not exist", Code: common.CodeNotFound, Transient: drugModifyDocument},
+		{Name: "modifyMedicalData missing record", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: medicalModifyArgs, Error: "does not exist", Code: common.CodeNotFound, Transient: medicalModifyDocument},
+		{Name: "modifyPatientInformation missing record", Caller: mockstub.Nurse, Function: "modifyPatientInformation", Args: patientModifyArgs, Error: "does not exist", Code: common.CodeNotFound, Transient: patientModifyDocument},
+
+		{Name: "createDrugInformation", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "createMedicalRecord", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Transient: medicalRecordDocument},
+		{Name: "createPatientInformation", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "modifyDrugData success", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: drugModifyArgs, Transient: drugModifyDocument},
+		{Name: "modifyMedicalData success", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: medicalModifyArgs, Transient: medicalModifyDocument},
+		{Name: "modifyPatientInformation success", Caller: mockstub.Nurse, Function: "modifyPatientInformation", Args: patientModifyArgs, Transient: patientModifyDocument},
+	})
+
+	drug := &common.DrugInformation{}
+	errDrug := common.GetRecord(stub, common.DrugInformationCollection, mockstub.PatientID, common.ObjectTypeDrugInformation, drug)
+	if errDrug != nil {
+		t.Fatal(errDrug)
+	} else if drug.DrugName != "ibuprofen" {
+		t.Fatalf("expecting modified drug name, got %q", drug.DrugName)
+	}
+	medicalRecord := &common.MedicalRecord{}
+	errMedicalRecord := common.GetRecord(stub, common.MedicalRecordCollection, mockstub.PatientID, common.ObjectTypeMedicalRecord, medicalRecord)
+	if errMedicalRecord != nil {
+		t.Fatal(errMedicalRecord)
+	} else if medicalRecord.MedicalDirectives != "do not resuscitate" {
+		t.Fatalf("expecting modified medical directives, got %q", medicalRecord.MedicalDirectives)
+	}
+	patient := &common.PatientInformation{}
+	errPatient := common.GetRecord(stub, common.PatientInformationCollection, mockstub.PatientID, common.ObjectTypePatientInformation, patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	} else if patient.InsuranceCard != "INS-002" {
+		t.Fatalf("expecting modified insurance card, got %q", patient.InsuranceCard)
+	}
+
+	//records of one type stored in the collection of another are corrupt
+	stub.SetPrivateData(common.DrugInformationCollection, mockstub.PatientID, []byte(`{"docType":"MedicalRecord"}`))
+	stub.SetPrivateData(common.MedicalRecordCollection, mockstub.PatientID, []byte("{"))
+	stub.SetPrivateData(common.PatientInformationCollection, mockstub.PatientID, []byte(`{"docType":"PatientInformation","insurance_card":7}`))
+	stub.Run(t, []mockstub.Case{
+		{Name: "modifyDrugData corrupt record", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: drugModifyArgs, Error: "is corrupt", Code: common.CodeCorruptRecord, Transient: drugModifyDocument},
+		{Name: "modifyMedicalData corrupt record", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: medicalModifyArgs, Error: "is corrupt", Code: common.CodeCorruptRecord, Transient: medicalModifyDocument},
+		{Name: "modifyPatientInformation corrupt record", Caller: mockstub.Nurse, Function: "modifyPatientInformation", Args: patientModifyArgs, Error: "is corrupt", Code: common.CodeCorruptRecord, Transient: patientModifyDocument},
+	})
+
+	stub.Errors[common.DrugInformationCollection] = errors.New("disk failure")
+	stub.Errors[common.MedicalRecordCollection] = errors.New("disk failure")
+	stub.Errors[common.PatientInformationCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "modifyDrugData storage failure", Caller: mockstub.Pharmacist, Function: "modifyDrugData", Args: drugModifyArgs, Error: "cannot access record", Code: common.CodeStorage, Transient: drugModifyDocument},
+		{Name: "modifyMedicalData storage failure", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: medicalModifyArgs, Error: "cannot access record", Code: common.CodeStorage, Transient: medicalModifyDocument},
+		{Name: "modifyPatientInformation storage failure", Caller: mockstub.Nurse, Function: "modifyPatientInformation", Args: patientModifyArgs, Error: "cannot access record", Code: common.CodeStorage, Transient: patientModifyDocument},
+	})
+}
+
+func TestPatch(t *testing.T) {
+	stub := newTestStub(t)
+	consents := []struct {
+		grantee  string
+		resource string
+	}{
+		{mockstub.Nurse, common.ResourcePatientInformation},
+		{mockstub.Clinician, common.ResourceMedicalRecord},
+		{mockstub.Pharmacist, common.ResourceDrugInformation},
+		{mockstub.Billing, common.ResourceHospitalFees},
+	}
+	for _, consent := range consents {
+		_, errConsent := stub.GrantConsent(consent.grantee, consent.resource, common.PurposeModify)
+		if errConsent != nil {
+			t.Fatal(errConsent)
+		}
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "createPatientInformation", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "createMedicalRecord", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Transient: medicalRecordDocument},
+		{Name: "createDrugInformation", Caller: mockstub.Pharmacist, Function: "createDrugInformation", Args: drugArgs, Transient: drugDocument},
+		{Name: "createHospitalFees", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Transient: hospitalFeesDocument},
+
+		{Name: "patchPatientInformation", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: []string{mockstub.PatientID, "ward", "1"}, Transient: mockstub.Document(`{"make_note_of_appointment_date":"2030-02-01"}`)},
+		{Name: "patchMedicalRecord", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: []string{mockstub.PatientID, "ward", "1"}, Transient: mockstub.Document(`{"treatment_history":"inhaler, physiotherapy","medical_directives":null}`)},
+		{Name: "patchDrugInformation", Caller: mockstub.Pharmacist, Function: "patchDrugInformation", Args: []string{mockstub.PatientID, "pharmacy", "1"}, Transient: mockstub.Document(`{"quantity":"5 tablet"}`)},
+		{Name: "patchHospitalFees", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: []string{mockstub.PatientID, "billing office", "1"}, Transient: mockstub.Document(`{"amount_due":"80.00 USD"}`)},
+		{Name: "patchHospitalFees unauthorized caller", Caller: mockstub.Nurse, Function: "patchHospitalFees", Args: []string{mockstub.PatientID, "ward", "1"}, Error: "is not allowed to modify HospitalFees", Code: common.CodeForbidden, Transient: mockstub.Document(`{"amount_due":"0.00 USD"}`)},
+	})
+
+	patient := &common.PatientInformation{}
+	errPatient := common.GetRecord(stub, common.PatientInformationCollection, mockstub.PatientID, common.ObjectTypePatientInformation, patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	} else if patient.MakeNoteOfAppointmentDate != "2030-02-01" || patient.InsuranceCard != "INS-001" {
+		t.Fatalf("expecting patched appointment date, got %+v", patient)
+	}
+	medicalRecord := &common.MedicalRecord{}
+	errMedicalRecord := common.GetRecord(stub, common.MedicalRecordCollection, mockstub.PatientID, common.ObjectTypeMedicalRecord, medicalRecord)
+	if errMedicalRecord != nil {
+		t.Fatal(errMedicalRecord)
+	} else if medicalRecord.TreatmentHistory != "inhaler, physiotherapy" || medicalRecord.MedicalDirectives != "" || medicalRecord.MedicalHistory != "asthma" {
+		t.Fatalf("expecting patched treatment history and cleared medical directives, got %+v", medicalRecord)
+	}
+
+	//entry of the modify log lists the patched fields
+	errAdmin := stub.As(mockstub.Admin)
+	if errAdmin != nil {
+		t.Fatal(errAdmin)
+	}
+	response := stub.Invoke("historyModify", mockstub.UserID(mockstub.Clinician))
+	queries := []*common.Query{}
+	errQueries := json.Unmarshal(response.Payload, &queries)
+	if errQueries != nil {
+		t.Fatalf("historyModify: %s %s", response.Message, errQueries)
+	} else if len(queries) != 1 || strings.Join(queries[0].Fields, ",") != "medical_directives,treatment_history" {
+		t.Fatalf("expecting patched fields in the modify log, got %+v", queries)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	stub := newTestStub(t)
+	consents := []struct {
+		grantee  string
+		resource string
+	}{
+		{mockstub.Nurse, common.ResourcePatientInformation},
+		{mockstub.Clinician, common.ResourceMedicalRecord},
+		{mockstub.Pharmacist, common.ResourceDrugInformation},
+	}
+	for _, consent := range consents {
+		_, errConsent := stub.GrantConsent(consent.grantee, consent.resource, common.PurposeModify)
+		if errConsent != nil {
+			t.Fatal(errConsent)
+		}
+	}
+
+	events := []struct {
+		caller     string
+		function   string
+		args       []string
+		transient  map[string][]byte
+		eventType  string
+		recordType string
+	}{
+		{mockstub.Nurse, "createPatientInformation", patientArgs, patientDocument, common.EventRecordCreated, common.ObjectTypePatientInformation},
+		{mockstub.Clinician, "createMedicalRecord", medicalRecordArgs, medicalRecordDocument, common.EventRecordCreated, common.ObjectTypeMedicalRecord},
+		{mockstub.Pharmacist, "createDrugInformation", drugArgs, drugDocument, common.EventRecordCreated, common.ObjectTypeDrugInformation},
+		{mockstub.Billing, "createHospitalFees", hospitalFeesArgs, hospitalFeesDocument, common.EventRecordCreated, common.ObjectTypeHospitalFees},
+		{mockstub.Nurse, "modifyPatientInformation", []string{mockstub.PatientID, "ward", "1"}, patientDocument, common.EventRecordModified, common.ObjectTypePatientInformation},
+		{mockstub.Clinician, "modifyMedicalData", []string{mockstub.PatientID, "ward", "1"}, medicalRecordDocument, common.EventRecordModified, common.ObjectTypeMedicalRecord},
+		{mockstub.Pharmacist, "modifyDrugData", []string{mockstub.PatientID, "pharmacy", "1"}, drugDocument, common.EventRecordModified, common.ObjectTypeDrugInformation},
+		{mockstub.Clinician, "patchMedicalRecord", []string{mockstub.PatientID, "ward", "2"}, mockstub.Document(`{"medical_directives":"do not resuscitate"}`), common.EventRecordModified, common.ObjectTypeMedicalRecord},
+		{mockstub.Patient, "query", []string{mockstub.PatientID, "home"}, nil, common.EventRecordQueried, common.ObjectTypePatientInformation},
+	}
+	for _, expected := range events {
+		errCaller := stub.As(expected.caller)
+		if errCaller != nil {
+			t.Fatal(errCaller)
+		}
+		stub.Transient = expected.transient
+		response := stub.Invoke(expected.function, expected.args...)
+		if response.Status != shim.OK {
+			t.Fatalf("%s: %s", expected.function, response.Message)
+		} else if stub.Event == nil || stub.Event.EventName != expected.eventType {
+			t.Fatalf("%s: expecting event %s, got %v", expected.function, expected.eventType, stub.Event)
+		}
+
+		event := &common.Event{}
+		errEvent := json.Unmarshal(stub.Event.Payload, event)
+		if errEvent != nil {
+			t.Fatal(errEvent)
+		}
+		expectedEvent := common.Event{EventType: expected.eventType, RecordType: expected.recordType, RecordID: mockstub.PatientID, Actor: mockstub.UserID(expected.caller), TxID: event.TxID}
+		if *event != expectedEvent || len(event.TxID) == 0 {
+			t.Fatalf("%s: expecting event %+v, got %+v", expected.function, expectedEvent, event)
+		}
+	}
+
+	//a failed transaction emits no event
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("query", "P2", "home")
+	if response.Status == shim.OK || stub.Event != nil {
+		t.Fatalf("expecting failed query without event, got %d %v", response.Status, stub.Event)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "create", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "query", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "home"}},
+
+		{Name: "historyQuery", Caller: mockstub.Admin, Function: "historyQuery", Args: []string{mockstub.UserID(mockstub.Patient)}},
+		{Name: "historyQuery wrong arity", Caller: mockstub.Admin, Function: "historyQuery", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "historyQuery empty argument", Caller: mockstub.Admin, Function: "historyQuery", Args: []string{""}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "historyQuery unregistered caller", Caller: mockstub.Stranger, Function: "historyQuery", Args: []string{mockstub.UserID(mockstub.Patient)}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "historyQuery unauthorized caller", Caller: mockstub.Clinician, Function: "historyQuery", Args: []string{mockstub.UserID(mockstub.Patient)}, Error: "is not allowed to read Query", Code: common.CodeForbidden},
+
+		{Name: "historyModify", Caller: mockstub.Admin, Function: "historyModify", Args: []string{mockstub.UserID(mockstub.Nurse)}},
+		{Name: "historyModify wrong arity", Caller: mockstub.Admin, Function: "historyModify", Args: []string{mockstub.UserID(mockstub.Nurse), "now"}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "historyModify empty argument", Caller: mockstub.Admin, Function: "historyModify", Args: []string{""}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "historyModify unregistered caller", Caller: mockstub.Stranger, Function: "historyModify", Args: []string{mockstub.UserID(mockstub.Nurse)}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "historyModify unauthorized caller", Caller: mockstub.Nurse, Function: "historyModify", Args: []string{mockstub.UserID(mockstub.Nurse)}, Error: "is not allowed to read Query", Code: common.CodeForbidden},
+
+		{Name: "historyPatient", Caller: mockstub.Patient, Function: "historyPatient", Args: []string{mockstub.PatientID}},
+		{Name: "historyPatient wrong arity", Caller: mockstub.Patient, Function: "historyPatient", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "historyPatient empty argument", Caller: mockstub.Patient, Function: "historyPatient", Args: []string{""}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "historyPatient unregistered caller", Caller: mockstub.Stranger, Function: "historyPatient", Args: []string{mockstub.PatientID}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "historyPatient of another patient", Caller: mockstub.Patient, Function: "historyPatient", Args: []string{"P2"}, Error: "is not allowed to read Query", Code: common.CodeForbidden},
+	})
+
+	if count := countAccessLog(t, stub, mockstub.Admin, "historyQuery", mockstub.UserID(mockstub.Patient)); count != 1 {
+		t.Fatalf("expecting 1 query of patient, got %d", count)
+	}
+	if count := countAccessLog(t, stub, mockstub.Admin, "historyModify", mockstub.UserID(mockstub.Patient)); count != 0 {
+		t.Fatalf("expecting 0 modification of patient, got %d", count)
+	}
+	if count := countAccessLog(t, stub, mockstub.Patient, "historyPatient", mockstub.PatientID); count != 1 {
+		t.Fatalf("expecting 1 access to patient's records, got %d", count)
+	}
+
+	stub.Errors[common.QueryCollection] = errors.New("disk failure")
+	stub.Errors[common.ModifyCollection] = errors.New("disk failure")
+	stub.Errors[common.ConsentCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "historyQuery storage failure", Caller: mockstub.Admin, Function: "historyQuery", Args: []string{mockstub.UserID(mockstub.Patient)}, Error: "cannot get access log: disk failure", Code: common.CodeStorage},
+		{Name: "historyModify storage failure", Caller: mockstub.Admin, Function: "historyModify", Args: []string{mockstub.UserID(mockstub.Nurse)}, Error: "cannot get access log: disk failure", Code: common.CodeStorage},
+		{Name: "historyPatient storage failure", Caller: mockstub.Patient, Function: "historyPatient", Args: []string{mockstub.PatientID}, Error: "cannot get access log: disk failure", Code: common.CodeStorage},
+		{Name: "listConsents storage failure", Caller: mockstub.Patient, Function: "listConsents", Args: []string{}, Error: "cannot get consents: disk failure", Code: common.CodeStorage},
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+	"github.com/xuansonha17031991/heathcare-chaincode/common/mockstub"
+)
+
+var hospitalFeesArgs = []string{mockstub.PatientID}
+var hospitalFeesDocument = mockstub.Document(`{"patient_name":"John","account":"ACC-001","date_of_service":"2020-01-01","patient_service":"x-ray","primary_insurance_billed":"100","secondary_insurance_billed":"0","pharmacy":"20","room":"101","amount_due":"120.00 USD"}`)
+
+func newTestStub(t *testing.T) *mockstub.MockStub {
+	stub := mockstub.NewMockStub("hospital_fees", new(HospitalFees_Chaincode))
+	errUsers := stub.RegisterUsers()
+	if errUsers != nil {
+		t.Fatal(errUsers)
+	}
+	return stub
+}
+
+func TestSharedFunctions(t *testing.T) {
+	newTestStub(t).Run(t, mockstub.SharedCases())
+}
+
+func TestCreateHospitalFees(t *testing.T) {
+	newTestStub(t).Run(t, []mockstub.Case{
+		{Name: "success", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Transient: hospitalFeesDocument},
+		{Name: "wrong arity", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "protected health information in args", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{mockstub.PatientID, "John", "ACC-001", "2020-01-01", "x-ray", "100", "0", "20", "101", "120"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{mockstub.PatientID}, Error: "amount_due must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John","account":"ACC-001","date_of_service":"2020-01-01","patient_service":"x-ray","primary_insurance_billed":"100","secondary_insurance_billed":"0","pharmacy":"20","room":"101","amount_due":""}`)},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: hospitalFeesDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Clinician, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "is not allowed to modify HospitalFees", Code: common.CodeForbidden, Transient: hospitalFeesDocument},
+		{Name: "record exists", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "record P1 already exists in HospitalFeesCollection", Code: common.CodeConflict, Transient: hospitalFeesDocument},
+
+		{Name: "json arguments", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{`{"id":"P2"}`}, Transient: hospitalFeesDocument},
+		{Name: "json arguments with protected health information", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{`{"id":"P1","account":"ACC-001"}`}, Error: "account is protected health information", Code: common.CodeInvalidArgument, Transient: hospitalFeesDocument},
+		{Name: "document unknown field", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "unknown field discount", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John","account":"ACC-001","date_of_service":"2020-01-01","patient_service":"x-ray","primary_insurance_billed":"100","secondary_insurance_billed":"0","pharmacy":"20","room":"101","amount_due":"120.00 USD","discount":"10"}`)},
+		{Name: "document missing field", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Error: "must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"patient_name":"John"}`)},
+	})
+}
+
+func TestQuery(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "billing office"}, Error: "does not exist", Code: common.CodeNotFound},
+		{Name: "create", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Transient: hospitalFeesDocument},
+		{Name: "success", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "billing office"}},
+		{Name: "wrong arity", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID}, Error: "expecting 2 argument", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, ""}, Error: "argument 2 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "query", Args: []string{mockstub.PatientID, "billing office"}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "unauthorized caller", Caller: mockstub.Nurse, Function: "query", Args: []string{mockstub.PatientID, "billing office"}, Error: "is not allowed to read HospitalFees", Code: common.CodeForbidden},
+		{Name: "record of another patient", Caller: mockstub.Patient, Function: "query", Args: []string{"P2", "billing office"}, Error: "is not allowed to read HospitalFees", Code: common.CodeForbidden},
+		{Name: "missing consent", Caller: mockstub.Billing, Function: "query", Args: []string{mockstub.PatientID, "billing office"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("query", mockstub.PatientID, "billing office")
+	hospitalFees := &common.HospitalFees{}
+	errHospitalFees := json.Unmarshal(response.Payload, hospitalFees)
+	if errHospitalFees != nil {
+		t.Fatal(errHospitalFees)
+	} else if hospitalFees.AmountDue != (common.Money{Amount: 12000, Currency: "USD"}) {
+		t.Fatalf("expecting amount due 120, got %q", hospitalFees.AmountDue)
+	}
+}
+
+func TestConsent(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "create", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Transient: hospitalFeesDocument},
+	})
+
+	consentid, errConsent := stub.GrantConsent(mockstub.Billing, common.ResourceHospitalFees, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "query with consent", Caller: mockstub.Billing, Function: "query", Args: []string{mockstub.PatientID, "billing office"}},
+		{Name: "revokeConsent", Caller: mockstub.Patient, Function: "revokeConsent", Args: []string{consentid}},
+		{Name: "query with revoked consent", Caller: mockstub.Billing, Function: "query", Args: []string{mockstub.PatientID, "billing office"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+}
+
+func TestPatchHospitalFees(t *testing.T) {
+	stub := newTestStub(t)
+	patchArgs := []string{mockstub.PatientID, "billing office", "1"}
+	patchDocument := mockstub.Document(`{"amount_due":"80.00 USD"}`)
+	stub.Run(t, []mockstub.Case{
+		{Name: "wrong arity", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: []string{mockstub.PatientID, "ward"}, Error: "expecting 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: patchArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty patch", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: patchArgs, Error: "patch must change at least one field", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{}`)},
+		{Name: "patch of id", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: patchArgs, Error: "id cannot be patched", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"id":"P2"}`)},
+		{Name: "unknown field", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: patchArgs, Error: "unknown field docType", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"docType":"Query"}`)},
+		{Name: "json arguments missing location", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: []string{`{"id":"P1"}`}, Error: "location must be declare", Code: common.CodeInvalidArgument, Transient: patchDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Clinician, Function: "patchHospitalFees", Args: patchArgs, Error: "is not allowed to modify HospitalFees", Code: common.CodeForbidden, Transient: patchDocument},
+		{Name: "missing consent", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: patchArgs, Error: "missing consent", Code: common.CodeForbidden, Transient: patchDocument},
+	})
+
+	_, errConsent := stub.GrantConsent(mockstub.Billing, common.ResourceHospitalFees, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: patchArgs, Error: "does not exist", Code: common.CodeNotFound, Transient: patchDocument},
+		{Name: "create", Caller: mockstub.Billing, Function: "createHospitalFees", Args: hospitalFeesArgs, Transient: hospitalFeesDocument},
+		{Name: "success", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: patchArgs, Transient: patchDocument},
+		{Name: "amount without currency", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: []string{mockstub.PatientID, "billing office", "2"}, Error: "amount_due must be an amount and a currency code", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"amount_due":"80"}`)},
+		{Name: "object of untyped field", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: []string{mockstub.PatientID, "billing office", "2"}, Error: "account must be a string or null", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"account":{"value":1}}`)},
+		{Name: "amount as object", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: []string{mockstub.PatientID, "billing office", "2"}, Transient: mockstub.Document(`{"amount_due":{"amount":9000,"currency":"USD"}}`)},
+		{Name: "json arguments", Caller: mockstub.Billing, Function: "patchHospitalFees", Args: []string{`{"id":"P1","location":"billing office","version":3}`}, Transient: patchDocument},
+	})
+
+	//only the patched field changes
+	hospitalFees := &common.HospitalFees{}
+	errHospitalFees := common.GetRecord(stub, common.HospitalFeesCollection, mockstub.PatientID, common.ObjectTypeHospitalFees, hospitalFees)
+	if errHospitalFees != nil {
+		t.Fatal(errHospitalFees)
+	} else if hospitalFees.AmountDue != (common.Money{Amount: 8000, Currency: "USD"}) || hospitalFees.Account != "ACC-001" {
+		t.Fatalf("expecting patched amount_due, got %+v", hospitalFees)
+	}
+}
+
+func TestMigrateRecords(t *testing.T) {
+	stub := newTestStub(t)
+	legacyFees := map[string]string{
+		"P1": `{"docType":"HospitalFees","id":"P1","patient_name":"John","account":"ACC-001","date_of_service":"31/01/2020","amount_due":"120"}`,
+		"P2": `{"docType":"HospitalFees","id":"P2","patient_name":"Jane","account":"ACC-002","date_of_service":"01/02/2020","amount_due":"a lot"}`,
+		"P4": `{"id":"P4","docType":"HospitalFees","patient_name":"Ann","account":"ACC-004","date_of_service":"2020-03-01","amount_due":{"amount":500,"currency":"USD"}}`,
+	}
+	for id, legacyFee := range legacyFees {
+		stub.SetPrivateData(common.HospitalFeesCollection, id, []byte(legacyFee))
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "create typed record", Caller: mockstub.Billing, Function: "createHospitalFees", Args: []string{"P3"}, Transient: hospitalFeesDocument},
+		{Name: "migrateRecords by billing", Caller: mockstub.Billing, Function: "migrateRecords", Args: []string{common.HospitalFeesCollection, "", "USD"}, Error: "is not an admin", Code: common.CodeForbidden},
+		{Name: "migrateRecords wrong arity", Caller: mockstub.Admin, Function: "migrateRecords", Args: []string{common.HospitalFeesCollection}, Error: "expecting 3 or 4 argument", Code: common.CodeInvalidArgument},
+		{Name: "migrateRecords untyped collection", Caller: mockstub.Admin, Function: "migrateRecords", Args: []string{common.MedicalRecordCollection, "", "USD"}, Error: "have no typed fields", Code: common.CodeInvalidArgument},
+		{Name: "migrateRecords invalid currency", Caller: mockstub.Admin, Function: "migrateRecords", Args: []string{common.HospitalFeesCollection, "", "dollar"}, Error: "currency must be an ISO 4217 code", Code: common.CodeInvalidArgument},
+	})
+
+	migrate := func() *common.MigrationReport {
+		errAdmin := stub.As(mockstub.Admin)
+		if errAdmin != nil {
+			t.Fatal(errAdmin)
+		}
+		response := stub.Invoke("migrateRecords", common.HospitalFeesCollection, "", "usd")
+		report := &common.MigrationReport{}
+		errReport := json.Unmarshal(response.Payload, report)
+		if errReport != nil {
+			t.Fatalf("expecting migration report, got %d %s", response.Status, response.Message)
+		}
+		return report
+	}
+
+	report := migrate()
+	if report.Migrated != 1 || len(report.Bookmark) != 0 {
+		t.Fatalf("expecting P1 migrated on one page, got %+v", report)
+	} else if len(report.Failures) != 2 || *report.Failures[0] != (common.MigrationFailure{ID: "P2", Field: "date_of_service"}) || *report.Failures[1] != (common.MigrationFailure{ID: "P2", Field: "amount_due"}) {
+		t.Fatalf("expecting date_of_service and amount_due of P2 to fail, got %+v", report.Failures)
+	}
+
+	hospitalFees := &common.HospitalFees{}
+	errHospitalFees := common.GetRecord(stub, common.HospitalFeesCollection, "P1", common.ObjectTypeHospitalFees, hospitalFees)
+	if errHospitalFees != nil {
+		t.Fatal(errHospitalFees)
+	} else if hospitalFees.DateOfService != "2020-01-31" || hospitalFees.AmountDue != (common.Money{Amount: 12000, Currency: "USD"}) || hospitalFees.Version != 1 {
+		t.Fatalf("expecting migrated P1, got %+v", hospitalFees)
+	}
+
+	//a record whose values could not be parsed, or were already typed, is kept as it was written, and a second run changes nothing
+	for _, id := range []string{"P2", "P4"} {
+		if stored := string(stub.PvtState[common.HospitalFeesCollection][id]); stored != legacyFees[id] {
+			t.Fatalf("expecting %s kept, got %s", id, stored)
+		}
+	}
+	if report = migrate(); report.Migrated != 0 || len(report.Failures) != 2 {
+		t.Fatalf("expecting nothing to migrate and the failures of P2, got %+v", report)
+	}
+
+	stub.Errors[common.HospitalFeesCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "storage failure", Caller: mockstub.Admin, Function: "migrateRecords", Args: []string{common.HospitalFeesCollection, "", "USD"}, Error: "of HospitalFeesCollection: disk failure", Code: common.CodeStorage},
+	})
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+	"github.com/xuansonha17031991/heathcare-chaincode/common/mockstub"
+)
+
+var medicalRecordArgs = []string{mockstub.PatientID}
+var medicalRecordDocument = mockstub.Document(`{"personal_identification":"passport 123","medical_history":"asthma","family_medical_history":"diabetes","medication_history":"salbutamol","treatment_history":"inhaler","medical_directives":"none"}`)
+
+func newTestStub(t *testing.T) *mockstub.MockStub {
+	stub := mockstub.NewMockStub("medical_record", new(MedicalRecord_Chaincode))
+	errUsers := stub.RegisterUsers()
+	if errUsers != nil {
+		t.Fatal(errUsers)
+	}
+	return stub
+}
+
+func TestSharedFunctions(t *testing.T) {
+	newTestStub(t).Run(t, mockstub.SharedCases())
+}
+
+func TestCreateMedicalRecord(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "success", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Transient: medicalRecordDocument},
+		{Name: "wrong arity", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "protected health information in args", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: []string{mockstub.PatientID, "passport 123", "asthma", "diabetes", "salbutamol", "inhaler", "none"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: []string{mockstub.PatientID}, Error: "medical_history must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"personal_identification":"passport 123","medical_history":"","family_medical_history":"diabetes","medication_history":"salbutamol","treatment_history":"inhaler","medical_directives":"none"}`)},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: medicalRecordDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Nurse, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "is not allowed to modify MedicalRecord", Code: common.CodeForbidden, Transient: medicalRecordDocument},
+		{Name: "record exists", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Error: "record P1 already exists in MedicalRecordCollection", Code: common.CodeConflict, Transient: mockstub.Document(`{"personal_identification":"passport 456","medical_history":"none","family_medical_history":"none","medication_history":"none","treatment_history":"none","medical_directives":"none"}`)},
+	})
+
+	//the record and its first revision are kept
+	revisions, errRevisions := common.GetRevisions(stub, common.MedicalRecordCollection, mockstub.PatientID)
+	if errRevisions != nil {
+		t.Fatal(errRevisions)
+	} else if len(revisions) != 1 || !strings.Contains(string(revisions[0].Record), "passport 123") {
+		t.Fatalf("expecting the first revision only, got %+v", revisions)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "does not exist", Code: common.CodeNotFound},
+		{Name: "create", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Transient: medicalRecordDocument},
+		{Name: "success", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "ward"}},
+		{Name: "wrong arity", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "ward", "now"}, Error: "expecting 2 argument", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, ""}, Error: "argument 2 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "unauthorized caller", Caller: mockstub.Billing, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "is not allowed to read MedicalRecord", Code: common.CodeForbidden},
+		{Name: "record of another patient", Caller: mockstub.Patient, Function: "query", Args: []string{"P2", "ward"}, Error: "is not allowed to read MedicalRecord", Code: common.CodeForbidden},
+		{Name: "missing consent", Caller: mockstub.Nurse, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("query", mockstub.PatientID, "ward")
+	medicalRecord := &common.MedicalRecord{}
+	errMedicalRecord := json.Unmarshal(response.Payload, medicalRecord)
+	if errMedicalRecord != nil {
+		t.Fatal(errMedicalRecord)
+	} else if medicalRecord.MedicalHistory != "asthma" {
+		t.Fatalf("expecting medical history asthma, got %q", medicalRecord.MedicalHistory)
+	}
+}
+
+func TestConsent(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "create", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Transient: medicalRecordDocument},
+	})
+
+	consentid, errConsent := stub.GrantConsent(mockstub.Nurse, common.ResourceMedicalRecord, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "query with consent", Caller: mockstub.Nurse, Function: "query", Args: []string{mockstub.PatientID, "ward"}},
+		{Name: "query with consent of another user", Caller: mockstub.Clinician, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden},
+		{Name: "revokeConsent", Caller: mockstub.Patient, Function: "revokeConsent", Args: []string{consentid}},
+		{Name: "query with revoked consent", Caller: mockstub.Nurse, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+}
+
+func TestModifyMedicalData(t *testing.T) {
+	stub := newTestStub(t)
+	modifyArgs := []string{mockstub.PatientID, "ward", "1"}
+	modifyDocument := mockstub.Document(`{"personal_identification":"passport 123","medical_history":"asthma","family_medical_history":"diabetes","medication_history":"salbutamol","treatment_history":"inhaler","medical_directives":"do not resuscitate"}`)
+	stub.Run(t, []mockstub.Case{
+		{Name: "wrong arity", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: []string{mockstub.PatientID, "ward"}, Error: "expecting 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "protected health information in args", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: []string{mockstub.PatientID, "ward", "passport 123", "asthma", "diabetes", "salbutamol", "inhaler", "do not resuscitate"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: modifyArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: []string{"", "ward", "1"}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument, Transient: modifyDocument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "modifyMedicalData", Args: modifyArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: modifyDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Nurse, Function: "modifyMedicalData", Args: modifyArgs, Error: "is not allowed to modify MedicalRecord", Code: common.CodeForbidden, Transient: modifyDocument},
+		{Name: "missing consent", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: modifyArgs, Error: "missing consent", Code: common.CodeForbidden, Transient: modifyDocument},
+	})
+
+	_, errConsent := stub.GrantConsent(mockstub.Clinician, common.ResourceMedicalRecord, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: modifyArgs, Error: "does not exist", Code: common.CodeNotFound, Transient: modifyDocument},
+		{Name: "create", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Transient: medicalRecordDocument},
+		{Name: "success", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: modifyArgs, Transient: modifyDocument},
+		{Name: "stale version", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: modifyArgs, Error: "has version 2, expecting version 1", Code: common.CodeConflict, Transient: modifyDocument},
+		{Name: "invalid version", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: []string{mockstub.PatientID, "ward", "latest"}, Error: "version must be a number", Code: common.CodeInvalidArgument, Transient: modifyDocument},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("query", mockstub.PatientID, "ward")
+	medicalRecord := &common.MedicalRecord{}
+	errMedicalRecord := json.Unmarshal(response.Payload, medicalRecord)
+	if errMedicalRecord != nil {
+		t.Fatal(errMedicalRecord)
+	} else if medicalRecord.MedicalDirectives != "do not resuscitate" {
+		t.Fatalf("expecting modified medical directives, got %q", medicalRecord.MedicalDirectives)
+	} else if medicalRecord.Version != 2 {
+		t.Fatalf("expecting version 2, got %d", medicalRecord.Version)
+	}
+
+	stub.SetPrivateData(common.MedicalRecordCollection, mockstub.PatientID, []byte(`{"docType":"PatientInformation","photo_id":"P1"}`))
+	stub.Run(t, []mockstub.Case{
+		{Name: "corrupt record", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: modifyArgs, Error: "is corrupt", Code: common.CodeCorruptRecord, Transient: modifyDocument},
+	})
+
+	stub.Errors[common.MedicalRecordCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "storage failure", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: modifyArgs, Error: "cannot access record P1 of MedicalRecordCollection: disk failure", Code: common.CodeStorage, Transient: modifyDocument},
+	})
+}
+
+func TestPatchMedicalRecord(t *testing.T) {
+	stub := newTestStub(t)
+	patchArgs := []string{mockstub.PatientID, "ward", "1"}
+	patchDocument := mockstub.Document(`{"medical_directives":"do not resuscitate"}`)
+	stub.Run(t, []mockstub.Case{
+		{Name: "wrong arity", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: []string{mockstub.PatientID, "ward"}, Error: "expecting 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: patchArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty patch", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: patchArgs, Error: "patch must change at least one field", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{}`)},
+		{Name: "patch of id", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: patchArgs, Error: "id cannot be patched", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"id":"P2"}`)},
+		{Name: "unknown field", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: patchArgs, Error: "unknown field docType", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"docType":"Query"}`)},
+		{Name: "json arguments missing location", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: []string{`{"id":"P1"}`}, Error: "location must be declare", Code: common.CodeInvalidArgument, Transient: patchDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Nurse, Function: "patchMedicalRecord", Args: patchArgs, Error: "is not allowed to modify MedicalRecord", Code: common.CodeForbidden, Transient: patchDocument},
+		{Name: "missing consent", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: patchArgs, Error: "missing consent", Code: common.CodeForbidden, Transient: patchDocument},
+	})
+
+	_, errConsent := stub.GrantConsent(mockstub.Clinician, common.ResourceMedicalRecord, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: patchArgs, Error: "does not exist", Code: common.CodeNotFound, Transient: patchDocument},
+		{Name: "create", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Transient: medicalRecordDocument},
+		{Name: "success", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: patchArgs, Transient: patchDocument},
+		{Name: "json arguments", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: []string{`{"id":"P1","location":"ward","version":2}`}, Transient: patchDocument},
+		{Name: "stale version", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: patchArgs, Error: "has version 3, expecting version 1", Code: common.CodeConflict, Transient: patchDocument},
+	})
+
+	//only the patched field changes
+	medicalRecord := &common.MedicalRecord{}
+	errMedicalRecord := common.GetRecord(stub, common.MedicalRecordCollection, mockstub.PatientID, common.ObjectTypeMedicalRecord, medicalRecord)
+	if errMedicalRecord != nil {
+		t.Fatal(errMedicalRecord)
+	} else if medicalRecord.MedicalDirectives != "do not resuscitate" || medicalRecord.MedicalHistory != "asthma" {
+		t.Fatalf("expecting patched medical_directives, got %+v", medicalRecord)
+	}
+}
+
+func TestMedicalRecordHistory(t *testing.T) {
+	stub := newTestStub(t)
+	_, errConsent := stub.GrantConsent(mockstub.Clinician, common.ResourceMedicalRecord, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Patient, Function: "getMedicalRecordHistory", Args: []string{mockstub.PatientID}, Error: "does not exist", Code: common.CodeNotFound},
+	})
+
+	stub.TxTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	stub.Run(t, []mockstub.Case{
+		{Name: "create", Caller: mockstub.Clinician, Function: "createMedicalRecord", Args: medicalRecordArgs, Transient: medicalRecordDocument},
+	})
+	stub.TxTime = time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	stub.Run(t, []mockstub.Case{
+		{Name: "modify", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: []string{mockstub.PatientID, "ward", "1"}, Transient: mockstub.Document(`{"personal_identification":"passport 123","medical_history":"asthma, bronchitis","family_medical_history":"diabetes","medication_history":"salbutamol","treatment_history":"inhaler","medical_directives":"none"}`)},
+	})
+	stub.TxTime = time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	stub.Run(t, []mockstub.Case{
+		{Name: "patch", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: []string{mockstub.PatientID, "ward", "2"}, Transient: mockstub.Document(`{"medical_directives":"do not resuscitate"}`)},
+
+		{Name: "getMedicalRecordHistory", Caller: mockstub.Patient, Function: "getMedicalRecordHistory", Args: []string{mockstub.PatientID}},
+		{Name: "getMedicalRecordHistory wrong arity", Caller: mockstub.Patient, Function: "getMedicalRecordHistory", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "getMedicalRecordHistory unauthorized caller", Caller: mockstub.Billing, Function: "getMedicalRecordHistory", Args: []string{mockstub.PatientID}, Error: "is not allowed to read MedicalRecord", Code: common.CodeForbidden},
+		{Name: "getMedicalRecordHistory of another patient", Caller: mockstub.Patient, Function: "getMedicalRecordHistory", Args: []string{"P2"}, Error: "is not allowed to read MedicalRecord", Code: common.CodeForbidden},
+
+		{Name: "getMedicalRecordAsOf", Caller: mockstub.Patient, Function: "getMedicalRecordAsOf", Args: []string{mockstub.PatientID, "2020-02-15T00:00:00Z"}},
+		{Name: "getMedicalRecordAsOf before creation", Caller: mockstub.Patient, Function: "getMedicalRecordAsOf", Args: []string{mockstub.PatientID, "2019-12-31T00:00:00Z"}, Error: "did not exist", Code: common.CodeNotFound},
+		{Name: "getMedicalRecordAsOf invalid timestamp", Caller: mockstub.Patient, Function: "getMedicalRecordAsOf", Args: []string{mockstub.PatientID, "yesterday"}, Error: "invalid timestamp", Code: common.CodeInvalidArgument},
+		{Name: "getMedicalRecordAsOf wrong arity", Caller: mockstub.Patient, Function: "getMedicalRecordAsOf", Args: []string{mockstub.PatientID}, Error: "expecting 2 argument", Code: common.CodeInvalidArgument},
+		{Name: "getMedicalRecordAsOf missing consent", Caller: mockstub.Nurse, Function: "getMedicalRecordAsOf", Args: []string{mockstub.PatientID, "2020-02-15T00:00:00Z"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("getMedicalRecordHistory", mockstub.PatientID)
+	revisions := []*common.Revision{}
+	errRevisions := json.Unmarshal(response.Payload, &revisions)
+	if errRevisions != nil {
+		t.Fatal(errRevisions)
+	} else if len(revisions) != 3 {
+		t.Fatalf("expecting 3 revisions, got %d", len(revisions))
+	}
+	for i, revision := range revisions {
+		if revision.Version != int64(i+1) || revision.Author != mockstub.UserID(mockstub.Clinician) || len(revision.TxID) == 0 {
+			t.Fatalf("expecting version %d by clinician, got %+v", i+1, revision)
+		}
+	}
+	if revisions[1].Time != "2020-02-01T00:00:00Z" {
+		t.Fatalf("expecting time of modification, got %s", revisions[1].Time)
+	}
+
+	//record as of a time between the modification and the patch
+	response = stub.Invoke("getMedicalRecordAsOf", mockstub.PatientID, "2020-02-15T00:00:00Z")
+	medicalRecord := &common.MedicalRecord{}
+	errMedicalRecord := json.Unmarshal(response.Payload, medicalRecord)
+	if errMedicalRecord != nil {
+		t.Fatal(errMedicalRecord)
+	} else if medicalRecord.Version != 2 || medicalRecord.MedicalHistory != "asthma, bronchitis" || medicalRecord.MedicalDirectives != "none" {
+		t.Fatalf("expecting version 2 of the record, got %+v", medicalRecord)
+	}
+
+	stub.Errors[common.MedicalRecordCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "getMedicalRecordHistory storage failure", Caller: mockstub.Patient, Function: "getMedicalRecordHistory", Args: []string{mockstub.PatientID}, Error: "cannot access record P1 of MedicalRecordCollection: disk failure", Code: common.CodeStorage},
+		{Name: "getMedicalRecordAsOf storage failure", Caller: mockstub.Patient, Function: "getMedicalRecordAsOf", Args: []string{mockstub.PatientID, "2020-02-15T00:00:00Z"}, Error: "cannot access record P1 of MedicalRecordCollection: disk failure", Code: common.CodeStorage},
+	})
+}
+
+func TestMedicalRecordHistoryBeforeRevisions(t *testing.T) {
+	stub := newTestStub(t)
+	_, errConsent := stub.GrantConsent(mockstub.Clinician, common.ResourceMedicalRecord, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	//a record written before versions and revisions were added
+	stub.SetPrivateData(common.MedicalRecordCollection, mockstub.PatientID, []byte(`{"docType":"MedicalRecord","id":"P1","personal_identification":"passport 123","medical_history":"asthma","family_medical_history":"diabetes","medication_history":"salbutamol","treatment_history":"inhaler","medical_directives":"none"}`))
+	stub.TxTime = time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	stub.Run(t, []mockstub.Case{
+		{Name: "modify", Caller: mockstub.Clinician, Function: "modifyMedicalData", Args: []string{mockstub.PatientID, "ward", "0"}, Transient: mockstub.Document(`{"personal_identification":"passport 123","medical_history":"asthma, bronchitis","family_medical_history":"diabetes","medication_history":"salbutamol","treatment_history":"inhaler","medical_directives":"none"}`)},
+	})
+	stub.TxTime = time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	stub.Run(t, []mockstub.Case{
+		{Name: "patch", Caller: mockstub.Clinician, Function: "patchMedicalRecord", Args: []string{mockstub.PatientID, "ward", "1"}, Transient: mockstub.Document(`{"medical_directives":"do not resuscitate"}`)},
+	})
+
+	//the stored version is kept once, before the first modification
+	revisions, errRevisions := common.GetRevisions(stub, common.MedicalRecordCollection, mockstub.PatientID)
+	if errRevisions != nil {
+		t.Fatal(errRevisions)
+	} else if len(revisions) != 3 {
+		t.Fatalf("expecting 3 revisions, got %d", len(revisions))
+	}
+	previous := &common.MedicalRecord{}
+	errPrevious := json.Unmarshal(revisions[0].Record, previous)
+	if errPrevious != nil {
+		t.Fatal(errPrevious)
+	} else if revisions[0].Version != 0 || revisions[0].Author != "" || revisions[0].Time != "" || previous.MedicalHistory != "asthma" {
+		t.Fatalf("expecting version 0 of the record without author and time, got %+v %+v", revisions[0], previous)
+	}
+
+	//the time the stored version was written is unknown, it is current until the first modification
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("getMedicalRecordAsOf", mockstub.PatientID, "2020-02-15T00:00:00Z")
+	asOf := &common.MedicalRecord{}
+	errAsOf := json.Unmarshal(response.Payload, asOf)
+	if errAsOf != nil {
+		t.Fatalf("expecting the record as of 2020-02-15, got %d %s", response.Status, response.Message)
+	} else if asOf.Version != 0 || asOf.MedicalHistory != "asthma" {
+		t.Fatalf("expecting version 0 of the record as of 2020-02-15, got %+v", asOf)
+	}
+}
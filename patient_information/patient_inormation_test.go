@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/xuansonha17031991/heathcare-chaincode/common"
+	"github.com/xuansonha17031991/heathcare-chaincode/common/mockstub"
+)
+
+var patientArgs = []string{mockstub.PatientID}
+var patientDocument = mockstub.Document(`{"insurance_card":"INS-001","current_medication_information":"salbutamol","related_medical_records":"MR-001","make_note_of_appointment_date":"2030-01-01"}`)
+
+func newTestStub(t *testing.T) *mockstub.MockStub {
+	stub := mockstub.NewMockStub("patient_information", new(PatientInformation_Chaincode))
+	errUsers := stub.RegisterUsers()
+	if errUsers != nil {
+		t.Fatal(errUsers)
+	}
+	return stub
+}
+
+func TestSharedFunctions(t *testing.T) {
+	newTestStub(t).Run(t, mockstub.SharedCases())
+}
+
+func TestCreatePatientInformation(t *testing.T) {
+	newTestStub(t).Run(t, []mockstub.Case{
+		{Name: "success", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "wrong arity", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: []string{}, Error: "expecting 1 argument", Code: common.CodeInvalidArgument},
+		{Name: "protected health information in args", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: []string{mockstub.PatientID, "INS-001", "salbutamol", "MR-001", "2030-01-01"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: []string{mockstub.PatientID}, Error: "insurance_card must be declare", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"insurance_card":"","current_medication_information":"salbutamol","related_medical_records":"MR-001","make_note_of_appointment_date":"2030-01-01"}`)},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "createPatientInformation", Args: patientArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: patientDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Pharmacist, Function: "createPatientInformation", Args: patientArgs, Error: "is not allowed to modify PatientInformation", Code: common.CodeForbidden, Transient: patientDocument},
+		{Name: "record exists", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Error: "record P1 already exists in PatientInformationCollection", Code: common.CodeConflict, Transient: patientDocument},
+	})
+}
+
+func TestQuery(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "does not exist", Code: common.CodeNotFound},
+		{Name: "create", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "success", Caller: mockstub.Patient, Function: "query", Args: []string{mockstub.PatientID, "ward"}},
+		{Name: "wrong arity", Caller: mockstub.Patient, Function: "query", Args: []string{}, Error: "expecting 2 argument", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Patient, Function: "query", Args: []string{"", "ward"}, Error: "argument 1 must be declare", Code: common.CodeInvalidArgument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "is not registered", Code: common.CodeForbidden},
+		{Name: "unauthorized caller", Caller: mockstub.Admin, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "is not allowed to read PatientInformation", Code: common.CodeForbidden},
+		{Name: "record of another patient", Caller: mockstub.Patient, Function: "query", Args: []string{"P2", "ward"}, Error: "is not allowed to read PatientInformation", Code: common.CodeForbidden},
+		{Name: "missing consent", Caller: mockstub.Pharmacist, Function: "query", Args: []string{mockstub.PatientID, "ward"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("query", mockstub.PatientID, "ward")
+	patient := &common.PatientInformation{}
+	errPatient = json.Unmarshal(response.Payload, patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	} else if patient.InsuranceCard != "INS-001" {
+		t.Fatalf("expecting insurance card INS-001, got %q", patient.InsuranceCard)
+	}
+}
+
+func TestConsent(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "create", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+	})
+
+	consentid, errConsent := stub.GrantConsent(mockstub.Pharmacist, common.ResourcePatientInformation, common.PurposeQuery)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "query with consent", Caller: mockstub.Pharmacist, Function: "query", Args: []string{mockstub.PatientID, "pharmacy"}},
+		{Name: "revokeConsent", Caller: mockstub.Patient, Function: "revokeConsent", Args: []string{consentid}},
+		{Name: "query with revoked consent", Caller: mockstub.Pharmacist, Function: "query", Args: []string{mockstub.PatientID, "pharmacy"}, Error: "missing consent", Code: common.CodeForbidden},
+	})
+}
+
+func TestModifyData(t *testing.T) {
+	stub := newTestStub(t)
+	modifyArgs := []string{mockstub.PatientID, "ward", "1"}
+	modifyDocument := mockstub.Document(`{"insurance_card":"INS-002","current_medication_information":"salbutamol","related_medical_records":"MR-001","make_note_of_appointment_date":"2030-02-01"}`)
+	stub.Run(t, []mockstub.Case{
+		{Name: "wrong arity", Caller: mockstub.Nurse, Function: "modifyData", Args: []string{mockstub.PatientID, "ward"}, Error: "expecting 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "protected health information in args", Caller: mockstub.Nurse, Function: "modifyData", Args: []string{mockstub.PatientID, "ward", "INS-002", "salbutamol", "MR-001", "2030-02-01"}, Error: "is protected health information", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty argument", Caller: mockstub.Nurse, Function: "modifyData", Args: []string{mockstub.PatientID, "", "1"}, Error: "argument 2 must be declare", Code: common.CodeInvalidArgument, Transient: modifyDocument},
+		{Name: "unregistered caller", Caller: mockstub.Stranger, Function: "modifyData", Args: modifyArgs, Error: "is not registered", Code: common.CodeForbidden, Transient: modifyDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Billing, Function: "modifyData", Args: modifyArgs, Error: "is not allowed to modify PatientInformation", Code: common.CodeForbidden, Transient: modifyDocument},
+		{Name: "missing consent", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Error: "missing consent", Code: common.CodeForbidden, Transient: modifyDocument},
+	})
+
+	_, errConsent := stub.GrantConsent(mockstub.Nurse, common.ResourcePatientInformation, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Error: "does not exist", Code: common.CodeNotFound, Transient: modifyDocument},
+		{Name: "create", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "success", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Transient: modifyDocument},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	response := stub.Invoke("query", mockstub.PatientID, "ward")
+	patient := &common.PatientInformation{}
+	errPatient = json.Unmarshal(response.Payload, patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	} else if patient.InsuranceCard != "INS-002" {
+		t.Fatalf("expecting modified insurance card, got %q", patient.InsuranceCard)
+	}
+
+	stub.SetPrivateData(common.PatientInformationCollection, mockstub.PatientID, []byte("not json"))
+	stub.Run(t, []mockstub.Case{
+		{Name: "corrupt record", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Error: "is corrupt", Code: common.CodeCorruptRecord, Transient: modifyDocument},
+	})
+
+	stub.Errors[common.PatientInformationCollection] = errors.New("disk failure")
+	stub.Run(t, []mockstub.Case{
+		{Name: "storage failure", Caller: mockstub.Nurse, Function: "modifyData", Args: modifyArgs, Error: "cannot access record P1 of PatientInformationCollection: disk failure", Code: common.CodeStorage, Transient: modifyDocument},
+	})
+}
+
+func TestPatchPatientInformation(t *testing.T) {
+	stub := newTestStub(t)
+	patchArgs := []string{mockstub.PatientID, "ward", "1"}
+	patchDocument := mockstub.Document(`{"make_note_of_appointment_date":"2030-02-01"}`)
+	stub.Run(t, []mockstub.Case{
+		{Name: "wrong arity", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: []string{mockstub.PatientID, "ward"}, Error: "expecting 3 argument", Code: common.CodeInvalidArgument},
+		{Name: "missing document", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: patchArgs, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "empty patch", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: patchArgs, Error: "patch must change at least one field", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{}`)},
+		{Name: "patch of id", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: patchArgs, Error: "photo_id cannot be patched", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"photo_id":"P2"}`)},
+		{Name: "unknown field", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: patchArgs, Error: "unknown field docType", Code: common.CodeInvalidArgument, Transient: mockstub.Document(`{"docType":"Query"}`)},
+		{Name: "json arguments missing location", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: []string{`{"photo_id":"P1"}`}, Error: "location must be declare", Code: common.CodeInvalidArgument, Transient: patchDocument},
+		{Name: "unauthorized caller", Caller: mockstub.Billing, Function: "patchPatientInformation", Args: patchArgs, Error: "is not allowed to modify PatientInformation", Code: common.CodeForbidden, Transient: patchDocument},
+		{Name: "missing consent", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: patchArgs, Error: "missing consent", Code: common.CodeForbidden, Transient: patchDocument},
+	})
+
+	_, errConsent := stub.GrantConsent(mockstub.Nurse, common.ResourcePatientInformation, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+
+	stub.Run(t, []mockstub.Case{
+		{Name: "missing record", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: patchArgs, Error: "does not exist", Code: common.CodeNotFound, Transient: patchDocument},
+		{Name: "create", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+		{Name: "success", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: patchArgs, Transient: patchDocument},
+		{Name: "json arguments", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: []string{`{"photo_id":"P1","location":"ward","version":2}`}, Transient: patchDocument},
+	})
+
+	//only the patched field changes
+	patient := &common.PatientInformation{}
+	errPatient := common.GetRecord(stub, common.PatientInformationCollection, mockstub.PatientID, common.ObjectTypePatientInformation, patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	} else if patient.MakeNoteOfAppointmentDate != "2030-02-01" || patient.InsuranceCard != "INS-001" {
+		t.Fatalf("expecting patched make_note_of_appointment_date, got %+v", patient)
+	}
+}
+
+func TestVerifyRecord(t *testing.T) {
+	stub := newTestStub(t)
+	stub.Run(t, []mockstub.Case{
+		{Name: "create without salt", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Error: "salt of at least 16 bytes must be passed in the transient map", Code: common.CodeInvalidArgument, Transient: map[string][]byte{common.TransientDocumentKey: patientDocument[common.TransientDocumentKey]}},
+		{Name: "create", Caller: mockstub.Nurse, Function: "createPatientInformation", Args: patientArgs, Transient: patientDocument},
+	})
+
+	errPatient := stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	disclosed := string(stub.Invoke("query", mockstub.PatientID, "home").Payload)
+
+	//world state holds the anchor but no health information
+	for key, value := range stub.State {
+		if strings.Contains(string(value), "INS-001") || strings.Contains(key, "INS-001") {
+			t.Fatalf("expecting no health information in world state, got %s", key)
+		}
+	}
+
+	tampered := strings.Replace(disclosed, "INS-001", "INS-999", 1)
+	stub.Run(t, []mockstub.Case{
+		{Name: "verifyRecord by unregistered auditor", Caller: mockstub.Stranger, Function: "verifyRecord", Args: []string{common.PatientInformationCollection, mockstub.PatientID}, Transient: mockstub.Document(disclosed)},
+		{Name: "verifyRecord wrong arity", Caller: mockstub.Stranger, Function: "verifyRecord", Args: []string{common.PatientInformationCollection}, Error: "expecting 2 argument", Code: common.CodeInvalidArgument, Transient: mockstub.Document(disclosed)},
+		{Name: "verifyRecord missing document", Caller: mockstub.Stranger, Function: "verifyRecord", Args: []string{common.PatientInformationCollection, mockstub.PatientID}, Error: "document must be passed in the transient map", Code: common.CodeInvalidArgument},
+		{Name: "verifyRecord missing salt", Caller: mockstub.Stranger, Function: "verifyRecord", Args: []string{common.PatientInformationCollection, mockstub.PatientID}, Error: "salt must be passed in the transient map", Code: common.CodeInvalidArgument, Transient: map[string][]byte{common.TransientDocumentKey: []byte(disclosed)}},
+		{Name: "verifyRecord of another id", Caller: mockstub.Stranger, Function: "verifyRecord", Args: []string{common.PatientInformationCollection, "P2"}, Error: "id of arguments and document differ", Code: common.CodeInvalidArgument, Transient: mockstub.Document(disclosed)},
+		{Name: "verifyRecord collection not anchored", Caller: mockstub.Stranger, Function: "verifyRecord", Args: []string{common.DrugInformationCollection, mockstub.PatientID}, Error: "are not anchored", Code: common.CodeInvalidArgument, Transient: mockstub.Document(disclosed)},
+		{Name: "verifyRecord unknown field", Caller: mockstub.Stranger, Function: "verifyRecord", Args: []string{common.PatientInformationCollection, mockstub.PatientID}, Error: "invalid json document", Code: common.CodeInvalidArgument, Transient: mockstub.Document(strings.Replace(disclosed, "{", `{"note":"x",`, 1))},
+	})
+
+	verify := func(document string, salt string) *common.Verification {
+		errStranger := stub.As(mockstub.Stranger)
+		if errStranger != nil {
+			t.Fatal(errStranger)
+		}
+		stub.Transient = map[string][]byte{common.TransientDocumentKey: []byte(document), common.TransientSaltKey: []byte(salt)}
+		response := stub.Invoke("verifyRecord", common.PatientInformationCollection, mockstub.PatientID)
+		verification := &common.Verification{}
+		errVerification := json.Unmarshal(response.Payload, verification)
+		if errVerification != nil {
+			t.Fatalf("verifyRecord: %s %s", response.Message, errVerification)
+		}
+		return verification
+	}
+
+	if verification := verify(disclosed, mockstub.Salt); !verification.Verified || verification.Anchor.Version != 1 {
+		t.Fatalf("expecting disclosed record to be verified, got %+v", verification)
+	}
+	if verification := verify(tampered, mockstub.Salt); verification.Verified {
+		t.Fatal("expecting tampered record not to be verified")
+	}
+	if verification := verify(disclosed, "fedcba9876543210"); verification.Verified {
+		t.Fatal("expecting record with another salt not to be verified")
+	}
+
+	//a copy of an earlier version stays verifiable after a modification
+	_, errConsent := stub.GrantConsent(mockstub.Nurse, common.ResourcePatientInformation, common.PurposeModify)
+	if errConsent != nil {
+		t.Fatal(errConsent)
+	}
+	stub.Run(t, []mockstub.Case{
+		{Name: "patch", Caller: mockstub.Nurse, Function: "patchPatientInformation", Args: []string{mockstub.PatientID, "ward", "1"}, Transient: mockstub.Document(`{"insurance_card":"INS-002"}`)},
+	})
+	if verification := verify(disclosed, mockstub.Salt); !verification.Verified || verification.Anchor.Version != 1 {
+		t.Fatalf("expecting version 1 to be verified, got %+v", verification)
+	}
+	errPatient = stub.As(mockstub.Patient)
+	if errPatient != nil {
+		t.Fatal(errPatient)
+	}
+	disclosed = string(stub.Invoke("query", mockstub.PatientID, "home").Payload)
+	if verification := verify(disclosed, mockstub.Salt); !verification.Verified || verification.Anchor.Version != 2 {
+		t.Fatalf("expecting version 2 to be verified, got %+v", verification)
+	}
+}